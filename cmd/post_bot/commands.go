@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/migrate"
+	"github.com/grbit/post_bot/internal/repo"
+
+	"golang.org/x/xerrors"
+)
+
+const migrateUsage = "usage: post_bot migrate up | down [steps] | version | force <version>"
+
+// runCommand runs a one-shot subcommand instead of the bot.
+func runCommand(ctx context.Context, cfg config.Values, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(ctx, cfg, args[1:])
+	case "auth":
+		return db.RunOAuthFlow(ctx, googleAuth(cfg), os.Stdin, os.Stdout)
+	case "reencrypt":
+		return runReencrypt(ctx, cfg)
+	default:
+		return xerrors.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runMigrate(ctx context.Context, cfg config.Values, args []string) error {
+	_, sqlStore, err := openStorage(cfg)
+	if err != nil {
+		return xerrors.Errorf("opening storage: %w", err)
+	}
+
+	if sqlStore == nil {
+		return xerrors.Errorf("%s storage has nothing to migrate", cfg.Storage)
+	}
+
+	defer sqlStore.Close()
+
+	m, err := migrate.New(sqlStore.DB)
+	if err != nil {
+		return xerrors.Errorf("creating migrator: %w", err)
+	}
+
+	if len(args) == 0 {
+		return xerrors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return xerrors.Errorf("parsing steps %q: %w", args[1], err)
+			}
+		}
+
+		return m.Down(ctx, steps)
+	case "version":
+		version, dirty, err := m.Version(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting version: %w", err)
+		}
+
+		fmt.Printf("version: %d, dirty: %t\n", version, dirty)
+
+		return nil
+	case "force":
+		if len(args) < 2 {
+			return xerrors.New(migrateUsage)
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing version %q: %w", args[1], err)
+		}
+
+		return m.Force(ctx, version)
+	default:
+		return xerrors.New(migrateUsage)
+	}
+}
+
+// runReencrypt encrypts personal data with the current key,
+// it's run after encryption is turned on or a new key is added.
+func runReencrypt(ctx context.Context, cfg config.Values) error {
+	_, sqlStore, err := openStorage(cfg)
+	if err != nil {
+		return xerrors.Errorf("opening storage: %w", err)
+	}
+
+	if sqlStore == nil {
+		return xerrors.Errorf("%s storage keeps nothing at rest", cfg.Storage)
+	}
+
+	defer sqlStore.Close()
+
+	n, err := sqlStore.Reencrypt(ctx)
+	if err != nil {
+		return xerrors.Errorf("reencrypting addresses: %w", err)
+	}
+
+	fmt.Printf("addresses reencrypted: %d\n", n)
+
+	return nil
+}
+
+func migrateUp(ctx context.Context, store *db.GormStore) error {
+	m, err := migrate.New(store.DB)
+	if err != nil {
+		return xerrors.Errorf("creating migrator: %w", err)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		return xerrors.Errorf("applying migrations: %w", err)
+	}
+
+	return nil
+}
@@ -3,19 +3,37 @@ package main
 import (
 	"context"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/grbit/post_bot/internal/bot"
 	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/consents"
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/pii"
+	"github.com/grbit/post_bot/internal/postcards"
 	"github.com/grbit/post_bot/internal/repo"
+	"github.com/grbit/post_bot/internal/requests"
+	"github.com/grbit/post_bot/internal/state"
+	"github.com/grbit/post_bot/internal/users"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/xerrors"
 )
 
+const (
+	// minRestartDelay is the delay before the first restart of the failed bot, it doubles with every next failure
+	minRestartDelay = time.Second
+	// maxRestartDelay limits the delay between restarts
+	maxRestartDelay = 5 * time.Minute
+)
+
 func main() {
-	ctx := context.TODO()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cfg := config.Get()
 
 	if err := configureLogging(cfg); err != nil {
@@ -24,26 +42,223 @@ func main() {
 
 	log.Info().Interface("config", cfg).Send()
 
-	repo, err := db.InitDataUpdater(ctx, cfg.PostgresURL, cfg.SpreadsheetID)
+	if len(cfg.Args) > 0 {
+		if err := runCommand(ctx, cfg, cfg.Args); err != nil {
+			log.Fatal().Err(err).Msgf("command %q failed: %+v", cfg.Args[0], err)
+		}
+
+		return
+	}
+
+	store, sqlStore, err := openStorage(cfg)
+	if err != nil {
+		log.Panic().Err(err).Msgf("can't open storage: %+v", err)
+	}
+
+	if sqlStore != nil && !cfg.SkipMigrations {
+		if err := migrateUp(ctx, sqlStore); err != nil {
+			log.Panic().Err(err).Msgf("can't migrate DB: %+v", err)
+		}
+	}
+
+	source, err := openSyncSource(ctx, cfg)
+	if err != nil {
+		log.Panic().Err(err).Msgf("can't open sync source: %+v", err)
+	}
+
+	repo, err := db.InitDataUpdater(ctx, store, source, cfg.SyncPolicy)
 	if err != nil {
 		log.Panic().Err(err).Msgf("can't run data updater: %+v", err)
 	}
 
-	go repo.RunDataUpdater(ctx, cfg.DataReloadTimeout)
+	updaterDone := make(chan struct{})
+
+	go func() {
+		defer close(updaterDone)
+		repo.RunDataUpdater(ctx, cfg.DataReloadTimeout)
+	}()
+
+	var (
+		states state.StateStore
+		uu     users.UserStore
+		rs     requests.RequestStore
+		pc     postcards.PostcardStore
+		cs     consents.ConsentStore
+		// inTx joins the stores in transactions, if they share the database
+		inTx dbtx.Func = dbtx.None
+	)
+
+	switch {
+	case cfg.StateStorage == "memory":
+		states = state.NewMemoryStore(cfg.StateTTL)
+		uu = users.NewMemoryStore()
+		rs = requests.NewMemoryStore()
+		pc = postcards.NewMemoryStore()
+		cs = consents.NewMemoryStore()
+	case sqlStore == nil:
+		log.Warn().Msg("there is no database to keep chat states, users, address requests, postcards and consents in, keeping them in memory")
 
-	b, err := bot.New(cfg)
+		states = state.NewMemoryStore(cfg.StateTTL)
+		uu = users.NewMemoryStore()
+		rs = requests.NewMemoryStore()
+		pc = postcards.NewMemoryStore()
+		cs = consents.NewMemoryStore()
+	default:
+		states = state.NewPostgresStore(sqlStore.DB, cfg.StateTTL)
+		uu = users.NewPostgresStore(sqlStore.DB)
+		rs = requests.NewPostgresStore(sqlStore.DB)
+		pc = postcards.NewPostgresStore(sqlStore.DB)
+		cs = consents.NewPostgresStore(sqlStore.DB)
+		inTx = dbtx.Over(sqlStore.DB)
+	}
+
+	b, err := bot.New(cfg, states, uu, rs, pc, cs, repo)
 	if err != nil {
 		log.Panic().Err(err).Msgf("can't create bot: %+v", err)
 	}
 
+	b.InTx = inTx
+
+	repo.OnApproved(b.NotifyApproved)
+	repo.OnCreated(b.Announce)
+
+	expiryDone := make(chan struct{})
+
+	go func() {
+		defer close(expiryDone)
+		b.RunExpiry(ctx)
+	}()
+
+	failed := runBot(ctx, b)
+	// the updater and expiry stop with ctx
+	stop()
+
+	log.Info().Msg("shutting down")
+
+	shCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := b.Shutdown(shCtx); err != nil {
+		log.Error().Err(err).Msg("shutting down bot")
+	}
+
+	<-updaterDone
+	<-expiryDone
+
+	if sqlStore != nil {
+		if err := sqlStore.Close(); err != nil {
+			log.Error().Err(err).Msg("closing storage")
+		}
+	}
+
+	log.Info().Msg("bye")
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runBot restarts the bot with growing delays until ctx is done.
+// It's true if the bot is stopped by an error which restarting doesn't fix.
+func runBot(ctx context.Context, b *bot.MyBot) bool {
+	delay := minRestartDelay
+
 	for {
-		if err := b.StartBot(); err != nil {
-			log.Error().Err(err).Msgf("error from start bot function: %+v", err)
+		started := time.Now()
+
+		err := b.StartBot(ctx)
+		if ctx.Err() != nil {
+			return false
+		}
+
+		if bot.IsFatal(err) {
+			log.Error().Err(err).Msgf("can't start bot: %+v", err)
+
+			return true
+		}
+
+		// the bot worked for a while, so it's a new failure rather than the same one
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+
+		log.Error().Err(err).Dur("restart_in", delay).Msgf("error from start bot function: %+v", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
 		}
 	}
 }
 
+// openStorage returns address store chosen in config.
+// If the store is backed by SQL database, it's returned as the second value too.
+func openStorage(cfg config.Values) (db.AddressStore, *db.GormStore, error) {
+	var (
+		store *db.GormStore
+		err   error
+	)
+
+	switch cfg.Storage {
+	case "memory":
+		return db.NewMemoryStore(), nil, nil
+	case "sqlite":
+		store, err = db.NewSQLiteStore(cfg.SQLitePath)
+	default:
+		store, err = db.NewPostgresStore(cfg.PostgresURL)
+	}
+
+	if err != nil {
+		return nil, nil, xerrors.Errorf("opening %s storage: %w", cfg.Storage, err)
+	}
+
+	if len(cfg.EncryptionKeys) > 0 {
+		c, err := pii.NewCipher(cfg.EncryptionKeys, cfg.BlindIndexKey)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("creating cipher: %w", err)
+		}
+
+		store.Encrypt(c)
+	}
+
+	return store, store, nil
+}
+
+func openSyncSource(ctx context.Context, cfg config.Values) (db.SyncSource, error) {
+	if cfg.SyncSource == "none" {
+		return db.NoSync(), nil
+	}
+
+	names, err := db.ParseColumnNames(cfg.SyncColumns)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing column names: %w", err)
+	}
+
+	if cfg.SyncSource == "file" {
+		return db.NewFileSource(cfg.SyncFile, names)
+	}
+
+	return db.NewGoogleSheetsSource(ctx, cfg.SpreadsheetID, googleAuth(cfg), names, cfg.SyncFlushInterval)
+}
+
+func googleAuth(cfg config.Values) db.GoogleAuth {
+	return db.GoogleAuth{
+		Mode:            cfg.GoogleAuth,
+		CredentialsFile: cfg.GoogleCredentials,
+		CredentialsJSON: cfg.GoogleCredentialsJSON,
+		TokenFile:       cfg.GoogleToken,
+		Endpoint:        cfg.SheetsEndpoint,
+	}
+}
+
 func configureLogging(cfg config.Values) error {
+	pii.RedactLogs()
+
 	log.Logger = log.
 		With().Timestamp().
 		Logger()
@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/postal"
+	"github.com/grbit/post_bot/internal/repo"
+)
+
+// parts of the structured address are kept in flow data by these keys
+const (
+	keyCountry       = "country"
+	keyPostalCode    = "postal_code"
+	keyCity          = "city"
+	keyRegion        = "region"
+	keyStreet        = "street"
+	keyRecipientLine = "recipient_line"
+	// keyAddressMode is how the address is entered, it's set by the country step and read by its Next
+	keyAddressMode = "address_mode"
+)
+
+const (
+	lineButton = "Одной строкой"
+
+	modeLine   = "line"
+	modePostal = "postal"
+)
+
+// addressSteps ask the address: the country first, then its parts in the country's order, or the whole address
+// in one line if the participant prefers. The step after the address is after.
+// If the address is already in the draft, the country step can be skipped to keep it.
+func addressSteps(after string) []*fsm.Step {
+	hasAddress := func(c *fsm.Conv) bool { return c.Get(keyAddress) != "" }
+
+	return []*fsm.Step{
+		{
+			Name: keyCountry,
+			Prompt: func(c *fsm.Conv) string {
+				prompt := "В какой стране тебя ждут открытки? Напиши страну, например «Германия» или «DE», " +
+					"а потом я спрошу адрес по частям. Если удобнее написать адрес целиком, нажми «" + lineButton + "»."
+				if v := c.Get(keyAddress); v != "" {
+					prompt += "\n\nСейчас: " + v
+				}
+
+				return prompt
+			},
+			Buttons:  []string{lineButton},
+			Optional: hasAddress,
+			Validate: fsm.NotEmpty(noTextMsg + " Напиши страну или нажми «" + lineButton + "»."),
+			Set: func(_ context.Context, c *fsm.Conv, text string) error {
+				if text == lineButton {
+					c.Put(keyAddressMode, modeLine)
+					putParts(c, &model.Address{})
+
+					return nil
+				}
+
+				country := postal.Find(text)
+				if country == nil {
+					return fsm.Invalid("Не знаю такую страну. Напиши её название или двухбуквенный код, например DE, " +
+						"или нажми «" + lineButton + "».")
+				}
+
+				if country.Code != c.Get(keyCountry) {
+					// postal codes of another country don't fit
+					c.Put(keyPostalCode, "")
+				}
+
+				c.Put(keyAddressMode, modePostal)
+				c.Put(keyCountry, country.Code)
+
+				return nil
+			},
+			Next: func(c *fsm.Conv) string {
+				mode := c.Get(keyAddressMode)
+				c.Put(keyAddressMode, "")
+
+				switch {
+				case mode == modeLine:
+					return keyAddress
+				case mode != modePostal:
+					// skipped, the address stays as it is
+					return after
+				case !postal.Lookup(c.Get(keyCountry)).HasPostalCodes():
+					c.Put(keyPostalCode, "")
+
+					return keyCity
+				default:
+					return keyPostalCode
+				}
+			},
+		},
+		{
+			Name: keyPostalCode,
+			Prompt: func(c *fsm.Conv) string {
+				prompt := "Какой у тебя индекс? Например, " + postal.Lookup(c.Get(keyCountry)).Example + "."
+				if v := c.Get(keyPostalCode); v != "" {
+					prompt += "\n\nСейчас: " + v
+				}
+
+				return prompt
+			},
+			Optional: func(c *fsm.Conv) bool {
+				return c.Get(keyPostalCode) != "" || postal.Lookup(c.Get(keyCountry)).PostalOptional
+			},
+			Validate: fsm.NotEmpty(noTextMsg + " Напиши индекс."),
+			Set: func(_ context.Context, c *fsm.Conv, text string) error {
+				country := postal.Lookup(c.Get(keyCountry))
+
+				code, err := country.PostalCode(text)
+				if err != nil {
+					return fsm.Invalid("Это не похоже на индекс. В стране " + country.Name() + " он выглядит так: " +
+						country.Example + ". Попробуй ещё раз.")
+				}
+
+				c.Put(keyPostalCode, code)
+
+				return nil
+			},
+		},
+		draftStep(keyCity, "Город, посёлок или деревня?", hasValue(keyCity),
+			fsm.NotEmpty(noTextMsg+" Напиши город.")),
+		draftStep(keyRegion, "Область, штат или провинция, если их пишут в адресе.", fsm.Always,
+			fsm.NotEmpty(noTextMsg+" Напиши регион или пропусти этот шаг.")),
+		draftStep(keyStreet, "Улица, дом и квартира.", hasValue(keyStreet),
+			fsm.NotEmpty(noTextMsg+" Напиши улицу, дом и квартиру.")),
+		withNext(draftStep(keyRecipientLine, "Кому писать на конверте? Если пропустить, напишут твоё имя. "+
+			"Здесь можно указать, например, «c/o» или название компании.", fsm.Always,
+			fsm.NotEmpty(noTextMsg+" Напиши, кому писать на конверте, или пропусти этот шаг.")), after),
+		withNext(draftStep(keyAddress, "Напиши адрес полностью: страна, город, улица, дом, квартира и индекс.",
+			hasAddress, validateAddress), after),
+	}
+}
+
+func hasValue(key string) func(c *fsm.Conv) bool {
+	return func(c *fsm.Conv) bool { return c.Get(key) != "" }
+}
+
+func withNext(s *fsm.Step, next string) *fsm.Step {
+	s.Next = func(*fsm.Conv) string { return next }
+
+	return s
+}
+
+// putParts puts parts of the address into the draft.
+func putParts(c *fsm.Conv, a *model.Address) {
+	for k, v := range map[string]string{
+		keyCountry:       a.Country,
+		keyPostalCode:    a.PostalCode,
+		keyCity:          a.City,
+		keyRegion:        a.Region,
+		keyStreet:        a.Street,
+		keyRecipientLine: a.RecipientLine,
+	} {
+		c.Put(k, v)
+	}
+}
+
+// draftParts returns the address from the draft, structured address is rendered to the line.
+func draftParts(c *fsm.Conv) *model.Address {
+	a := &model.Address{
+		Address:       c.Get(keyAddress),
+		RecipientLine: c.Get(keyRecipientLine),
+		Street:        c.Get(keyStreet),
+		City:          c.Get(keyCity),
+		Region:        c.Get(keyRegion),
+		PostalCode:    c.Get(keyPostalCode),
+		Country:       c.Get(keyCountry),
+	}
+	a.Address = db.AddressLine(a)
+
+	return a
+}
+
+// addressText is the address as it's given to the sender: structured address is written like on the envelope.
+func addressText(a *model.Address) string {
+	if !a.Structured() {
+		return a.String()
+	}
+
+	recipient := a.RecipientLine
+	if recipient == "" {
+		recipient = a.PersonName
+	}
+
+	text := strings.Join(postal.Format(db.PostalParts(a, recipient)), "\n")
+	if a.Wishes != "" {
+		text += "\nПожелания: " + a.Wishes + "."
+	}
+
+	return text
+}
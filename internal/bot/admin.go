@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/repo"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+// broadcastInterval is a pause between broadcast messages, telegram allows about 30 messages a second
+const broadcastInterval = 50 * time.Millisecond
+
+const broadcastButton = "Разослать"
+
+// reloader is the address store which can sync with the table on demand
+type reloader interface {
+	Reload(ctx context.Context) (*db.SyncReport, error)
+}
+
+func (b *MyBot) adminHandlers() []*commandHandler {
+	return []*commandHandler{
+		{
+			name:       model.CmdPending,
+			desc:       "Анкеты на проверку",
+			role:       model.RoleModerator,
+			handleFunc: b.pendingHandler(),
+		},
+		{
+			name:       model.CmdStats,
+			desc:       "Статистика",
+			role:       model.RoleModerator,
+			handleFunc: b.statsHandler(),
+		},
+		{
+			name:       model.CmdLookup,
+			desc:       "Всё об участнике: /lookup @nick",
+			role:       model.RoleModerator,
+			handleFunc: b.lookupHandler(),
+		},
+		{
+			name:       model.CmdBan,
+			desc:       "Заблокировать: /ban @nick",
+			role:       model.RoleModerator,
+			handleFunc: b.banHandler(true),
+		},
+		{
+			name:       model.CmdUnban,
+			desc:       "Разблокировать: /unban @nick",
+			role:       model.RoleModerator,
+			handleFunc: b.banHandler(false),
+		},
+		{
+			name:       model.CmdReload,
+			desc:       "Синхронизировать с таблицей",
+			role:       model.RoleModerator,
+			handleFunc: b.reloadHandler(),
+		},
+		{
+			name:       model.CmdExport,
+			desc:       "Выгрузить адреса в CSV",
+			role:       model.RoleModerator,
+			handleFunc: b.exportHandler(),
+		},
+		{
+			name:       model.CmdRole,
+			desc:       "Назначить роль: /role @nick moderator",
+			role:       model.RoleOwner,
+			handleFunc: b.roleHandler(),
+		},
+		{
+			name: model.CmdBroadcast,
+			desc: "Разослать сообщение всем",
+			role: model.RoleOwner,
+			flow: b.broadcastFlow(),
+		},
+	}
+}
+
+func (b *MyBot) statsHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		all, approved, pending, err := db.Count(ctx, b.Addresses)
+		if err != nil {
+			return nil, xerrors.Errorf("counting addresses: %w", err)
+		}
+
+		uu, err := b.Users.List(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf("listing users: %w", err)
+		}
+
+		roles := make(map[string]int)
+		for _, u := range uu {
+			roles[b.role(u)]++
+		}
+
+		return tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+			"Адресов: %d, одобрено: %d, ждут проверки: %d.\n"+
+				"Писали боту: %d, модераторов: %d, заблокировано: %d.",
+			all, approved, pending,
+			len(uu), roles[model.RoleModerator]+roles[model.RoleOwner], roles[model.RoleBanned])), nil
+	}
+}
+
+func (b *MyBot) lookupHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		u, text, err := b.targetUser(ctx, update)
+		if err != nil || u == nil {
+			return tgbotapi.NewMessage(chatID, text), err
+		}
+
+		text = fmt.Sprintf("@%s, ID %d, роль: %s, пишет боту с %s.",
+			u.Telegram, u.ChatID, b.role(u), u.CreatedAt.Format("02.01.2006"))
+
+		a, err := db.FindByTg(ctx, b.Addresses, u.Telegram)
+		if err != nil {
+			return nil, xerrors.Errorf("finding by telegram '%s': %w", u.Telegram, err)
+		}
+
+		if a.IsEmpty() {
+			return tgbotapi.NewMessage(chatID, text+"\n\nДанных нет."), nil
+		}
+
+		return tgbotapi.NewMessage(chatID, text+"\n\n"+decisionText(a, "")), nil
+	}
+}
+
+// banHandler bans or unbans, users can't ban those who have the same role or higher.
+func (b *MyBot) banHandler(ban bool) updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		u, text, err := b.targetUser(ctx, update)
+		if err != nil || u == nil {
+			return tgbotapi.NewMessage(chatID, text), err
+		}
+
+		actor, err := b.user(ctx, update.Message.From)
+		if err != nil {
+			return nil, err
+		}
+
+		role := b.role(u)
+
+		switch {
+		case allowed(role, b.role(actor)):
+			return tgbotapi.NewMessage(chatID, "Роль @"+u.Telegram+" не ниже твоей, тут я не помогу."), nil
+		case ban == (role == model.RoleBanned):
+			return tgbotapi.NewMessage(chatID, "Ничего не поменялось, роль @"+u.Telegram+": "+role+"."), nil
+		}
+
+		u.Role = model.RoleBanned
+		text = "@" + u.Telegram + " заблокирован."
+
+		if !ban {
+			u.Role = ""
+			text = "@" + u.Telegram + " разблокирован."
+		}
+
+		return tgbotapi.NewMessage(chatID, text), b.saveRole(ctx, u)
+	}
+}
+
+func (b *MyBot) roleHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+		usage := "Напиши ник и роль: /" + model.CmdRole + " @nick " + model.RoleModerator + "|" + model.RoleParticipant
+
+		args := strings.Fields(update.Message.CommandArguments())
+		if len(args) != 2 || (args[1] != model.RoleModerator && args[1] != model.RoleParticipant) {
+			return tgbotapi.NewMessage(chatID, usage), nil
+		}
+
+		u, text, err := b.targetUser(ctx, update)
+		if err != nil || u == nil {
+			return tgbotapi.NewMessage(chatID, text), err
+		}
+
+		if b.role(u) == model.RoleOwner {
+			return tgbotapi.NewMessage(chatID, "@"+u.Telegram+" владелец, это задаётся в конфиге."), nil
+		}
+
+		u.Role = args[1]
+
+		return tgbotapi.NewMessage(chatID, "Теперь @"+u.Telegram+": "+u.Role+"."), b.saveRole(ctx, u)
+	}
+}
+
+// saveRole saves the user and updates the commands they see.
+func (b *MyBot) saveRole(ctx context.Context, u *model.User) error {
+	if err := b.Users.Save(ctx, u); err != nil {
+		return xerrors.Errorf("saving role of %d: %w", u.ChatID, err)
+	}
+
+	if err := b.setCommands(u.ChatID, b.role(u)); err != nil {
+		log.Warn().Err(err).Int64("chat_id", u.ChatID).Msg("updating commands after role change")
+	}
+
+	return nil
+}
+
+func (b *MyBot) reloadHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		noSync := tgbotapi.NewMessage(chatID, "Синхронизация с таблицей не настроена.")
+
+		r, ok := b.Addresses.(reloader)
+		if !ok {
+			return noSync, nil
+		}
+
+		rep, err := r.Reload(ctx)
+		if xerrors.Is(err, db.ErrNoSync) {
+			return noSync, nil
+		}
+
+		if err != nil {
+			return nil, xerrors.Errorf("reloading: %w", err)
+		}
+
+		return tgbotapi.NewMessage(chatID, fmt.Sprintf(
+			"Готово. Новых из таблицы: %d, изменено в таблице: %d, отправлено в таблицу: %d, "+
+				"конфликтов: %d, удалено: %d, стёрто участниками: %d, ошибок: %d.",
+			rep.Created, rep.FromTable, rep.Pushed, rep.Conflicts, rep.Deleted, rep.Erased, rep.Failed)), nil
+	}
+}
+
+func (b *MyBot) exportHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		names, err := db.ParseColumnNames(b.cfg.SyncColumns)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing column names: %w", err)
+		}
+
+		var buf bytes.Buffer
+		if err := db.ExportCSV(ctx, b.Addresses, names, &buf); err != nil {
+			return nil, xerrors.Errorf("exporting addresses: %w", err)
+		}
+
+		return tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
+			Name:  "addresses-" + time.Now().Format("2006-01-02") + ".csv",
+			Bytes: buf.Bytes(),
+		}), nil
+	}
+}
+
+func (b *MyBot) broadcastFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: model.CmdBroadcast,
+		Steps: []*fsm.Step{
+			{
+				Name:     "text",
+				Prompt:   fsm.Say("Что разослать? Сообщение получат все, кто писал боту, кроме заблокированных."),
+				Validate: fsm.NotEmpty(noTextMsg + " Напиши текст рассылки."),
+				Set: func(_ context.Context, c *fsm.Conv, text string) error {
+					c.Put("text", text)
+
+					return nil
+				},
+			},
+			{
+				Name:    "confirm",
+				Prompt:  func(c *fsm.Conv) string { return "Разослать всем?\n\n" + c.Get("text") },
+				Buttons: []string{broadcastButton, fsm.CancelButton},
+				Validate: func(text string) error {
+					if text != broadcastButton {
+						return fsm.Invalid("Нажми «" + broadcastButton + "» или «" + fsm.CancelButton + "».")
+					}
+
+					return nil
+				},
+			},
+		},
+		Done: func(ctx context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			uu, err := b.Users.List(ctx)
+			if err != nil {
+				return fsm.Reply{}, xerrors.Errorf("listing users: %w", err)
+			}
+
+			var to []int64
+
+			for _, u := range uu {
+				if b.role(u) != model.RoleBanned {
+					to = append(to, u.ChatID)
+				}
+			}
+
+			if !b.broadcast(c.ChatID, c.Get("text"), to) {
+				return fsm.Reply{Text: "Бот перезапускается, повтори рассылку чуть позже."}, nil
+			}
+
+			return fsm.Reply{Text: "Рассылаю " + strconv.Itoa(len(to)) + " пользователям, напишу, когда закончу."}, nil
+		},
+	}
+}
+
+// broadcast sends the text in background, it's stopped on shutdown if it doesn't finish in time.
+// The author is told how it went. It's false if the broadcast isn't started, because the bot is shutting down.
+func (b *MyBot) broadcast(author int64, text string, to []int64) bool {
+	if !b.track() {
+		return false
+	}
+
+	go func() {
+		defer b.inFlight.Done()
+
+		ctx := b.handlersCtx
+		sent, failed := 0, 0
+
+		for _, chatID := range to {
+			// the pause goes first, so the author gets the reply to the command before the broadcast
+			select {
+			case <-ctx.Done():
+				log.Warn().Int("sent", sent).Int("left", len(to)-sent-failed).Msg("broadcast is stopped")
+
+				return
+			case <-time.After(broadcastInterval):
+			}
+
+			if _, err := b.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+				failed++
+
+				log.Warn().Err(err).Int64("chat_id", chatID).Msg("sending broadcast message")
+			} else {
+				sent++
+			}
+		}
+
+		b.notify(ctx, tgbotapi.NewMessage(author, fmt.Sprintf("Разослано: %d, не получилось: %d.", sent, failed)))
+	}()
+
+	return true
+}
@@ -1,73 +1,298 @@
 package bot
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/consents"
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/fsm"
 	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/postcards"
+	"github.com/grbit/post_bot/internal/repo"
+	"github.com/grbit/post_bot/internal/requests"
 	"github.com/grbit/post_bot/internal/state"
+	"github.com/grbit/post_bot/internal/users"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/xerrors"
 )
 
 const (
 	sendMsgRetries = 10
+	handlerTimeout = 10 * time.Second
+	// configureTimeout limits requests to DB made on start
+	configureTimeout = 10 * time.Second
+
+	errorMsg = "Тут какая-то ошибка произошла... Напишите прогеру t.me/grbit, пусть починит."
 )
 
 type MyBot struct {
 	*tgbotapi.BotAPI
-	Retries  int
-	Handlers map[string]*commandHandler
+	Retries   int
+	Handlers  map[string]*commandHandler
+	flows     *fsm.Machine
+	callbacks map[string]callbackHandleFunc
+	States    state.StateStore
+	Users     users.UserStore
+	Requests  requests.RequestStore
+	Postcards postcards.PostcardStore
+	Consents  consents.ConsentStore
+	Addresses db.AddressStore
+	// InTx runs changes of the stores in one transaction, if they share the database
+	InTx dbtx.Func
+
+	// handlerList keeps the order of commands for the menu
+	handlerList []*commandHandler
+	// draw picks random addresses
+	draw db.Strategy
+
+	cfg config.Values
+	// callbackKey signs callback data of inline buttons
+	callbackKey []byte
+
+	// handlers limits number of concurrently handled updates
+	handlers chan struct{}
+	inFlight sync.WaitGroup
+	// closing is set when shutdown begins, no handlers are started after that
+	closing     bool
+	closingLock sync.Mutex
+	// chats makes updates from the same chat handled one by one
+	chats chatLocks
+
+	// handlersCtx is a parent for every handler context,
+	// it's cancelled only if handlers didn't finish in time on shutdown
+	handlersCtx    context.Context
+	cancelHandlers context.CancelFunc
 }
 
-func New(cfg config.Values) (*MyBot, error) {
-	tgBot, err := tgbotapi.NewBotAPI(cfg.BotToken)
+func New(cfg config.Values, states state.StateStore, uu users.UserStore, rs requests.RequestStore,
+	pc postcards.PostcardStore, cs consents.ConsentStore, addresses db.AddressStore,
+) (*MyBot, error) {
+	endpoint := cfg.TelegramAPIEndpoint
+	if endpoint == "" {
+		endpoint = tgbotapi.APIEndpoint
+	}
+
+	tgBot, err := tgbotapi.NewBotAPIWithAPIEndpoint(cfg.BotToken, endpoint)
 	if err != nil {
 		return nil, xerrors.Errorf("creating Bot API: %w", err)
 	}
 
 	tgBot.Debug = cfg.Debug
 
+	draw, err := db.NewStrategy(cfg.DrawStrategy, rs)
+	if err != nil {
+		return nil, xerrors.Errorf("creating draw strategy: %w", err)
+	}
+
+	handlersCtx, cancel := context.WithCancel(context.Background())
+
+	limit := cfg.MaxConcurrentUpdates
+	if limit < 1 {
+		limit = 1
+	}
+
+	key := sha256.Sum256([]byte("callback data " + cfg.BotToken))
+
 	b := &MyBot{
-		BotAPI:  tgBot,
-		Retries: sendMsgRetries,
+		BotAPI:         tgBot,
+		Retries:        sendMsgRetries,
+		States:         states,
+		Users:          uu,
+		Requests:       rs,
+		Postcards:      pc,
+		Consents:       cs,
+		Addresses:      addresses,
+		InTx:           dbtx.None,
+		draw:           draw,
+		cfg:            cfg,
+		callbackKey:    key[:],
+		handlers:       make(chan struct{}, limit),
+		handlersCtx:    handlersCtx,
+		cancelHandlers: cancel,
 	}
 
-	b.configure(makeHandlers())
+	b.callbacks = b.makeCallbacks()
+
+	if err := b.configure(b.makeHandlers()); err != nil {
+		return nil, err
+	}
 
 	return b, nil
 }
 
-func (b *MyBot) StartBot() error {
+// fatalError is an error which restarting the bot doesn't fix, like a bad config or an address it can't listen on
+type fatalError struct{ error }
+
+func (e fatalError) Unwrap() error { return e.error }
+
+// IsFatal reports whether StartBot failed in a way restarting it doesn't fix.
+func IsFatal(err error) bool {
+	return errors.As(err, new(fatalError))
+}
+
+// StartBot receives updates until ctx is done or receiving fails.
+// It returns nil only if ctx is done.
+func (b *MyBot) StartBot(ctx context.Context) error {
 	log.Info().Msgf("Authorized on account %s", b.Self.UserName)
 
+	if b.cfg.UpdatesMode == updatesModeWebhook {
+		return b.startWebhook(ctx)
+	}
+
+	return b.startPolling(ctx)
+}
+
+// Shutdown waits for in-flight updates to be handled.
+// If ctx is done earlier, contexts of the handlers are cancelled.
+func (b *MyBot) Shutdown(ctx context.Context) error {
+	b.closingLock.Lock()
+	b.closing = true
+	b.closingLock.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		b.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Msg("all updates handled")
+
+		return nil
+	case <-ctx.Done():
+		b.cancelHandlers()
+
+		return xerrors.Errorf("waiting for handlers: %w", ctx.Err())
+	}
+}
+
+func (b *MyBot) startPolling(ctx context.Context) error {
+	// telegram doesn't give updates via getUpdates while webhook is set
+	if _, err := b.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return xerrors.Errorf("deleting webhook: %w", err)
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := b.GetUpdatesChan(u)
 
-	for update := range updates {
-		log.Debug().
-			Interface("update", update).
-			Interface("update.Message", update.Message).
-			Msg("log")
-
-		go func(update tgbotapi.Update) {
-			if err := b.handleUpdate(update); err != nil {
-				log.Error().
-					Interface("update", update).
-					Err(err).
-					Msg("handling update")
+	for {
+		select {
+		case <-ctx.Done():
+			// updates which were received but not handled are not confirmed by offset,
+			// so telegram sends them again after restart
+			b.StopReceivingUpdates()
+			log.Info().Msg("polling stopped")
+
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return xerrors.Errorf("something went wrong, out of cycle")
 			}
-		}(update)
+
+			b.processUpdate(update)
+		}
+	}
+}
+
+// track counts a new handler in flight, so Shutdown waits for it.
+// It's false once shutdown began, then the handler mustn't be started.
+func (b *MyBot) track() bool {
+	b.closingLock.Lock()
+	defer b.closingLock.Unlock()
+
+	if b.closing {
+		return false
+	}
+
+	b.inFlight.Add(1)
+
+	return true
+}
+
+// processUpdate handles update in a separate goroutine.
+// It blocks if there are too many updates being handled already.
+// It's false if the update is refused, because the bot is shutting down.
+func (b *MyBot) processUpdate(update tgbotapi.Update) bool {
+	updateLog(log.Debug(), update).Msg("got update")
+
+	if !b.track() {
+		updateLog(log.Warn(), update).Msg("update is refused on shutdown")
+
+		return false
+	}
+
+	b.handlers <- struct{}{}
+
+	go func(update tgbotapi.Update) {
+		defer func() {
+			<-b.handlers
+			b.inFlight.Done()
+		}()
+
+		ctx, cancel := context.WithTimeout(b.handlersCtx, handlerTimeout)
+		defer cancel()
+
+		if err := b.handleUpdate(ctx, update); err != nil {
+			updateLog(log.Error(), update).
+				Err(err).
+				Msg("handling update")
+		}
+	}(update)
+
+	return true
+}
+
+// updateLog adds what identifies the update to the log event, texts and user profiles aren't logged as they are personal.
+func updateLog(e *zerolog.Event, update tgbotapi.Update) *zerolog.Event {
+	e = e.Int("update_id", update.UpdateID)
+
+	if chat := update.FromChat(); chat != nil {
+		e = e.Int64("chat_id", chat.ID)
+	}
+
+	switch {
+	case update.Message != nil && update.Message.IsCommand():
+		e = e.Str("command", update.Message.Command())
+	case update.CallbackQuery != nil:
+		e = e.Bool("callback", true)
 	}
 
-	return xerrors.Errorf("something went wrong, out of cycle")
+	return e
 }
 
-func (b *MyBot) handleUpdate(update tgbotapi.Update) error {
+// messageLog adds to e the chat and the type of the message, but not its text, which may have addresses.
+func messageLog(e *zerolog.Event, message tgbotapi.Chattable) *zerolog.Event {
+	e = e.Str("message_type", fmt.Sprintf("%T", message))
+
+	switch m := message.(type) {
+	case tgbotapi.MessageConfig:
+		e = e.Int64("chat_id", m.ChatID)
+	case tgbotapi.DocumentConfig:
+		e = e.Int64("chat_id", m.ChatID)
+	case tgbotapi.EditMessageTextConfig:
+		e = e.Int64("chat_id", m.ChatID)
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		e = e.Int64("chat_id", m.ChatID)
+	}
+
+	return e
+}
+
+func (b *MyBot) handleUpdate(ctx context.Context, update tgbotapi.Update) error {
 	defer func() {
 		if rec := recover(); rec != nil {
 			switch err := rec.(type) {
@@ -84,53 +309,31 @@ func (b *MyBot) handleUpdate(update tgbotapi.Update) error {
 		}
 	}()
 
-	if update.Message == nil { // ignore any non-Message updates
-		return nil
+	if chat := update.FromChat(); chat != nil {
+		unlock := b.chats.lock(chat.ID)
+		defer unlock()
 	}
 
-	st := state.Get(update.Message.Chat.ID)
-	st.Telegram = update.Message.From.UserName
-
-	// Create a new MessageConfig. We don't have text yet, so we leave it empty.
-	var msg tgbotapi.Chattable
-	cmd := update.Message.Command()
-	chatID := update.Message.Chat.ID
-	var err error
-
-	switch {
-	case !update.Message.IsCommand():
-
-		switch st.PreviousCmd {
-		case model.CmdGiveMeSome:
-			msg, err = giveMeHandler()(update, st)
-		case model.CmdAddAddress:
-			msg, err = addAddressHandler()(update, st)
-		case model.CmdAddInstagram:
-			msg, err = addInstagramHandler()(update, st)
-		case model.CmdAddWishes:
-			msg, err = addWishesHandler()(update, st)
-		case model.CmdAddPersonName:
-			msg, err = addPersonNameHandler()(update, st)
-		default: // ignore any other non-command Messages
-		}
+	if update.CallbackQuery != nil {
+		return b.handleCallback(ctx, update.CallbackQuery)
+	}
 
-	case update.Message == nil: // ignore any non-Message updates
-	default:
-		if h, ok := b.Handlers[cmd]; ok && h != nil {
-			msg, err = h.handleFunc(update, st)
-		}
+	if update.Message == nil { // ignore any other updates
+		return nil
 	}
 
-	if update.Message.IsCommand() {
-		st.PreviousCmd = cmd
-	} else {
-		st.PreviousCmd = ""
+	st, err := b.States.Get(ctx, update.Message.Chat.ID)
+	if err != nil {
+		return xerrors.Errorf("getting state: %w", err)
 	}
 
+	st.Telegram = update.Message.From.UserName
+	chatID := update.Message.Chat.ID
+
+	msg, err := b.reply(ctx, update, st)
 	if err != nil {
 		log.Error().Err(err).Msg("handler error")
-		msg = tgbotapi.NewMessage(chatID,
-			"Тут какая-то ошибка произошла... Напишите прогеру t.me/grbit, пусть починит.")
+		msg = tgbotapi.NewMessage(chatID, errorMsg)
 	}
 
 	if msg == nil {
@@ -138,44 +341,123 @@ func (b *MyBot) handleUpdate(update tgbotapi.Update) error {
 			"Ну я хз. Не понимаю что от меня хотят. Может `/help`?")
 	}
 
-	m, err := b.sendWithRetries(msg)
+	// state is saved before sending, so the user's answer to the message is understood,
+	// and even if sending failed
+	if err := b.States.Save(ctx, st); err != nil {
+		return xerrors.Errorf("saving state: %w", err)
+	}
+
+	m, err := b.sendWithRetries(ctx, msg)
 	if err != nil {
-		return xerrors.Errorf("sending a message %+v: %w", msg, err)
+		return xerrors.Errorf("sending a message: %w", err)
 	}
 
 	if m.Document != nil {
 		st.FileIDs[m.Document.FileName] = m.Document.FileID
+
+		if err := b.States.Save(ctx, st); err != nil {
+			return xerrors.Errorf("saving file ID: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func (b *MyBot) configure(handlers []*commandHandler) {
+// reply returns the answer to the message, it's nil if the message isn't understood.
+func (b *MyBot) reply(ctx context.Context, update tgbotapi.Update, st *model.State) (tgbotapi.Chattable, error) {
+	var (
+		r   fsm.Reply
+		err error
+	)
+
+	if msg, err := b.authorize(ctx, update); msg != nil || err != nil {
+		return msg, err
+	}
+
+	cmd := update.Message.Command()
+
+	switch h, ok := b.Handlers[cmd]; {
+	case !update.Message.IsCommand():
+		// not a command is an answer in a flow, other messages are not understood
+		if !b.flows.Active(st) {
+			return nil, nil
+		}
+
+		r, err = b.flows.Handle(ctx, st, update.Message.Text)
+	case !ok: // unknown command
+		return nil, nil
+	case h.flow != nil:
+		r, err = b.flows.Start(ctx, st, cmd, update.Message.CommandArguments())
+	default:
+		return h.handleFunc(ctx, update, st)
+	}
+
+	if err != nil || r.Text == "" {
+		return nil, err
+	}
+
+	return b.flowMessage(update.Message.Chat.ID, st, r), nil
+}
+
+func (b *MyBot) configure(handlers []*commandHandler) error {
 	b.Handlers = make(map[string]*commandHandler)
-	cmds := make([]tgbotapi.BotCommand, len(handlers))
-	for i, h := range handlers {
-		cmds[i] = tgbotapi.BotCommand{Command: h.name, Description: h.desc}
+	b.handlerList = handlers
+
+	// flows which are started by buttons
+	flows := []*fsm.Flow{b.reasonFlow()}
+
+	for _, h := range handlers {
 		b.Handlers[h.name] = h
+
+		if h.flow != nil {
+			flows = append(flows, h.flow)
+		}
+	}
+
+	var err error
+	if b.flows, err = fsm.New(flows...); err != nil {
+		return xerrors.Errorf("declaring flows: %w", err)
 	}
 
-	commands := tgbotapi.NewSetMyCommands(cmds...)
+	// everyone sees participant commands, moderators and owners get their own lists
+	commands := tgbotapi.NewSetMyCommands(b.commandsFor(model.RoleParticipant)...)
 
 	msg, err := b.Send(commands)
 	log.Info().Err(err).Interface("msg", msg).Msg("set cmds")
 
 	cc, err := b.BotAPI.GetMyCommands()
 	log.Info().Err(err).Interface("commands", cc).Msg("got cmds")
+
+	ctx, cancel := context.WithTimeout(context.Background(), configureTimeout)
+	defer cancel()
+
+	privileged, err := b.privileged(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting moderators: %w", err)
+	}
+
+	for chatID, role := range privileged {
+		if err := b.setCommands(chatID, role); err != nil {
+			log.Warn().Err(err).Int64("chat_id", chatID).Msg("setting commands of moderator")
+		}
+	}
+
+	return nil
 }
 
-func (b *MyBot) sendWithRetries(message tgbotapi.Chattable) (m tgbotapi.Message, err error) {
+func (b *MyBot) sendWithRetries(ctx context.Context, message tgbotapi.Chattable) (m tgbotapi.Message, err error) {
 	for i := 1; i < b.Retries; i++ {
+		if ctx.Err() != nil {
+			return m, xerrors.Errorf("sending a message: %w", ctx.Err())
+		}
+
 		m, err = b.Send(message)
 		if err != nil {
-			log.Warn().Err(err).Interface("message_config", message).Msg("failed to send a message")
+			messageLog(log.Warn().Err(err), message).Msg("failed to send a message")
 		} else {
 			return m, nil
 		}
 	}
 
-	return m, xerrors.Errorf("sending a message %+v: %w", message, err)
+	return m, xerrors.Errorf("sending a message: %w", err)
 }
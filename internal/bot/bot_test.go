@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestNoWorkAfterShutdown(t *testing.T) {
+	b := &MyBot{}
+
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutting down: %v", err)
+	}
+
+	if b.processUpdate(tgbotapi.Update{UpdateID: 1}) {
+		t.Fatal("update is taken after shutdown")
+	}
+
+	if b.broadcast(1, "hi", []int64{2}) {
+		t.Fatal("broadcast is started after shutdown")
+	}
+}
+
+func TestWebhookConfigErrorsAreFatal(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	for _, tt := range []struct {
+		name string
+		cfg  config.Values
+	}{
+		{"bad url", config.Values{WebhookURL: "http://[::1"}},
+		{"missing certificate", config.Values{WebhookURL: "https://example.com/", WebhookCert: "nowhere.pem", WebhookKey: "nowhere.key"}},
+		{"busy address", config.Values{WebhookURL: "https://example.com/", WebhookListen: busy.Addr().String()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &MyBot{cfg: tt.cfg}
+
+			if err := b.startWebhook(context.Background()); !IsFatal(err) {
+				t.Fatalf("error is %v, want fatal", err)
+			}
+		})
+	}
+}
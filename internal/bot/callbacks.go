@@ -0,0 +1,262 @@
+package bot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+// Callback data of inline buttons is "route|arg|...|signature". Telegram limits it by 64 bytes,
+// so routes are one letter and the signature is a truncated HMAC of the chat ID and the rest of the data.
+// The signature makes sure the data was made by the bot for this chat, so it's safe to act on it.
+const (
+	maxCallbackData = 64
+	callbackSep     = "|"
+	signatureLen    = 8
+
+	routeAnswer  = "a"
+	routeCommand = "c"
+	routePage    = "p"
+	// routeModerate is "m|decision|telegram"
+	routeModerate = "m"
+	// routeConsent is "k|ask|telegram" for the requester and "k|yes or no|consent ID" for the recipient
+	routeConsent = "k"
+)
+
+const staleButton = "Эта кнопка уже не работает."
+
+// callbackHandleFunc handles a pressed button, answer is shown to the user as a notification
+// and msgs are sent to the chat after it, e.g. editing the message with the button.
+type callbackHandleFunc func(ctx context.Context, q *tgbotapi.CallbackQuery, st *model.State, args []string) (
+	answer string, msgs []tgbotapi.Chattable, err error)
+
+func (b *MyBot) makeCallbacks() map[string]callbackHandleFunc {
+	return map[string]callbackHandleFunc{
+		routeAnswer:   b.answerCallback,
+		routeCommand:  b.commandCallback,
+		routePage:     b.pageCallback,
+		routeModerate: b.moderateCallback,
+		routeConsent:  b.consentCallback,
+	}
+}
+
+// route returns callback data for fsm.Button, it's signed when the button is sent.
+func route(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), callbackSep)
+}
+
+func (b *MyBot) sign(chatID int64, data string) string {
+	mac := hmac.New(sha256.New, b.callbackKey)
+	mac.Write([]byte(strconv.FormatInt(chatID, 10) + callbackSep + data))
+
+	return data + callbackSep + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:signatureLen]
+}
+
+// parseCallback checks the signature and splits the data into route and args, ok is false for forged data.
+func (b *MyBot) parseCallback(chatID int64, signed string) (name string, args []string, ok bool) {
+	i := strings.LastIndex(signed, callbackSep)
+	if i < 0 || !hmac.Equal([]byte(b.sign(chatID, signed[:i])), []byte(signed)) {
+		return "", nil, false
+	}
+
+	parts := strings.Split(signed[:i], callbackSep)
+
+	return parts[0], parts[1:], true
+}
+
+func (b *MyBot) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) error {
+	if q.Message == nil { // the bot doesn't work in inline mode, so there are no such buttons
+		return b.answer(q, "")
+	}
+
+	chatID := q.Message.Chat.ID
+
+	st, err := b.States.Get(ctx, chatID)
+	if err != nil {
+		return xerrors.Errorf("getting state: %w", err)
+	}
+
+	st.Telegram = q.From.UserName
+
+	var (
+		answer string
+		msgs   []tgbotapi.Chattable
+	)
+
+	u, err := b.user(ctx, q.From)
+	if err != nil {
+		return err
+	}
+
+	name, args, ok := b.parseCallback(chatID, q.Data)
+	h := b.callbacks[name]
+
+	switch {
+	case b.role(u) == model.RoleBanned:
+		answer = bannedMsg
+	case !ok || h == nil:
+		log.Warn().Str("data", q.Data).Int64("chat_id", chatID).Msg("unknown callback data")
+
+		answer, msgs = staleButton, []tgbotapi.Chattable{removeButtons(q)}
+	default:
+		answer, msgs, err = h(ctx, q, st, args)
+		if err != nil {
+			log.Error().Err(err).Str("data", q.Data).Msg("callback handler error")
+
+			answer, msgs = "", []tgbotapi.Chattable{tgbotapi.NewMessage(chatID, errorMsg)}
+		}
+	}
+
+	if err := b.States.Save(ctx, st); err != nil {
+		return xerrors.Errorf("saving state: %w", err)
+	}
+
+	// the button shows a spinner until the callback is answered, so it's done before anything else
+	if err := b.answer(q, answer); err != nil {
+		log.Warn().Err(err).Str("callback_query_id", q.ID).Msg("answering callback query")
+	}
+
+	for _, msg := range msgs {
+		if _, err := b.sendWithRetries(ctx, msg); err != nil {
+			return xerrors.Errorf("sending a message: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *MyBot) answer(q *tgbotapi.CallbackQuery, text string) error {
+	if _, err := b.Request(tgbotapi.NewCallback(q.ID, text)); err != nil {
+		return xerrors.Errorf("answering callback query: %w", err)
+	}
+
+	return nil
+}
+
+// answerCallback answers the flow step with the pressed button, the buttons under the prompt are replaced by the answer.
+func (b *MyBot) answerCallback(ctx context.Context, q *tgbotapi.CallbackQuery, st *model.State, args []string) (
+	string, []tgbotapi.Chattable, error,
+) {
+	if len(args) != 2 {
+		return staleButton, []tgbotapi.Chattable{removeButtons(q)}, nil
+	}
+
+	i, _ := strconv.Atoi(args[1])
+
+	text, ok := b.flows.Answer(st, args[0], i)
+	if !ok {
+		return staleButton, []tgbotapi.Chattable{removeButtons(q)}, nil
+	}
+
+	r, err := b.flows.Handle(ctx, st, text)
+	if err != nil {
+		return "", nil, xerrors.Errorf("answering %q: %w", text, err)
+	}
+
+	chatID := q.Message.Chat.ID
+	edit := tgbotapi.NewEditMessageText(chatID, q.Message.MessageID, q.Message.Text+"\n\n→ "+text)
+
+	return "", []tgbotapi.Chattable{edit, b.flowMessage(chatID, st, r)}, nil
+}
+
+// commandCallback runs the command as if it was sent, e.g. from the main menu.
+func (b *MyBot) commandCallback(ctx context.Context, q *tgbotapi.CallbackQuery, st *model.State, args []string) (
+	string, []tgbotapi.Chattable, error,
+) {
+	if len(args) != 1 {
+		return staleButton, nil, nil
+	}
+
+	cmd := "/" + args[0]
+	update := tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: q.Message.MessageID,
+		From:      q.From,
+		Chat:      q.Message.Chat,
+		Date:      q.Message.Date,
+		Text:      cmd,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Length: len(cmd)}},
+	}}
+
+	msg, err := b.reply(ctx, update, st)
+	if err != nil {
+		return "", nil, xerrors.Errorf("running command %q: %w", cmd, err)
+	}
+
+	if msg == nil {
+		return staleButton, nil, nil
+	}
+
+	return "", []tgbotapi.Chattable{msg}, nil
+}
+
+// pageCallback shows another page of search results in place of the current one.
+func (b *MyBot) pageCallback(ctx context.Context, q *tgbotapi.CallbackQuery, st *model.State, args []string) (
+	string, []tgbotapi.Chattable, error,
+) {
+	if len(args) < 2 {
+		return staleButton, nil, nil
+	}
+
+	page, _ := strconv.Atoi(args[0])
+
+	// the request itself could contain the separator
+	r, err := b.searchPage(ctx, st, strings.Join(args[1:], callbackSep), page)
+	if err != nil {
+		return "", nil, err
+	}
+
+	chatID := q.Message.Chat.ID
+	edit := tgbotapi.NewEditMessageText(chatID, q.Message.MessageID, r.Text)
+
+	if kb := b.inlineKeyboard(chatID, st, r.Buttons); kb != nil {
+		edit.ReplyMarkup = kb
+	}
+
+	return "", []tgbotapi.Chattable{edit}, nil
+}
+
+// inlineKeyboard makes a button for every reply button, nil if there are none.
+// Buttons without data answer the current flow step.
+func (b *MyBot) inlineKeyboard(chatID int64, st *model.State, buttons []fsm.Button) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for i, btn := range buttons {
+		data := btn.Data
+		if data == "" {
+			data = route(routeAnswer, st.Step, strconv.Itoa(i))
+		}
+
+		data = b.sign(chatID, data)
+		if len(data) > maxCallbackData {
+			log.Warn().Str("data", data).Str("button", btn.Text).Msg("callback data is too long, button is dropped")
+
+			continue
+		}
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btn.Text, data)))
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	kb := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return &kb
+}
+
+// removeButtons removes the keyboard under the message with the pressed button.
+func removeButtons(q *tgbotapi.CallbackQuery) tgbotapi.Chattable {
+	return tgbotapi.NewEditMessageReplyMarkup(q.Message.Chat.ID, q.Message.MessageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+}
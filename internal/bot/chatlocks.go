@@ -0,0 +1,47 @@
+package bot
+
+import "sync"
+
+// chatLocks serializes handling of updates from the same chat: a handler reads the chat state, changes it
+// and saves it, so concurrent handlers would overwrite each other's changes.
+type chatLocks struct {
+	locks map[int64]*chatLock
+	sync.Mutex
+}
+
+type chatLock struct {
+	// users is how many handlers hold or wait for the lock, it's forgotten when there are none
+	users int
+	sync.Mutex
+}
+
+// lock waits until no other handler holds the lock of the chat.
+func (l *chatLocks) lock(chatID int64) (unlock func()) {
+	l.Lock()
+
+	if l.locks == nil {
+		l.locks = make(map[int64]*chatLock)
+	}
+
+	cl := l.locks[chatID]
+	if cl == nil {
+		cl = &chatLock{}
+		l.locks[chatID] = cl
+	}
+
+	cl.users++
+	l.Unlock()
+
+	cl.Lock()
+
+	return func() {
+		cl.Unlock()
+
+		l.Lock()
+		defer l.Unlock()
+
+		if cl.users--; cl.users == 0 {
+			delete(l.locks, chatID)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChatLocks(t *testing.T) {
+	var (
+		l     chatLocks
+		wg    sync.WaitGroup
+		count = map[int64]int{}
+	)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+
+		go func(chatID int64) {
+			defer wg.Done()
+
+			unlock := l.lock(chatID)
+			defer unlock()
+
+			// the race detector reports concurrent handlers of the same chat
+			count[chatID]++
+		}(int64(i % 2))
+	}
+
+	wg.Wait()
+
+	if count[0] != 50 || count[1] != 50 {
+		t.Fatalf("counts are %v", count)
+	}
+
+	if len(l.locks) != 0 {
+		t.Fatalf("locks of %d chats are kept", len(l.locks))
+	}
+}
@@ -4,39 +4,49 @@ import (
 	"context"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/grbit/post_bot/internal/fsm"
 	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/postal"
 	"github.com/grbit/post_bot/internal/repo"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/samber/lo"
 	"golang.org/x/xerrors"
 )
 
-const (
-	// my personal chat id for debug purposes
-	myChatID = 805666391
-)
-
-type updateHandleFunc func(update tgbotapi.Update, state *model.State) (tgbotapi.Chattable, error)
+type updateHandleFunc func(ctx context.Context, update tgbotapi.Update, state *model.State) (tgbotapi.Chattable, error)
 
+// commandHandler either handles the command at once or, if flow is set, starts the flow.
 type commandHandler struct {
-	name       string
-	desc       string
+	name string
+	desc string
+	// role is the lowest role allowed to run the command, empty means any participant
+	role       string
 	handleFunc updateHandleFunc
+	flow       *fsm.Flow
 }
 
-func makeHandlers() (handlers []*commandHandler) {
+func (b *MyBot) makeHandlers() (handlers []*commandHandler) {
 	help := "Добро пожаловать домой! Этот бот создан для посткроссинга бёрнеров по всему миру. " +
 		"Здесь ты можешь оставить свой адрес для писем, а можешь получить адрес кого-нибудь из друзей. " +
 		"Отправь команду /" + model.CmdGiveMeSome + " чтобы получить рандомный адрес получателя. " +
 		"Если ты хочешь получить адрес кого-то особенного, то можешь добавить его ник в телеграме после команды.\n\n" +
+		"Чтобы получать адреса только из некоторых стран, напиши фильтр после команды, например /" +
+		model.CmdGiveMeSome + " country:DE, или задай его один раз командой /" + model.CmdFilter + "\n\n" +
+		"Чтобы заполнить все свои данные по шагам, напиши /" + model.CmdRegister + "\n\n" +
 		"Чтобы добавить свои адрес, ник в инстаграме, ФИО или пожелания для отправителя, напиши соответствующие команды:\n\n" +
 		"/" + model.CmdAddAddress + " - добавить адрес\n" +
 		"/" + model.CmdAddInstagram + " - добавить инстаграм\n" +
 		"/" + model.CmdAddPersonName + " - добавить ФИО\n" +
 		"/" + model.CmdAddWishes + " - добавить пожелания (что ты хочешь получить по почте)\n\n" +
-		"Если хочешь посмотреть свои данные, то отправь команду /" + model.CmdMyData
+		"Если хочешь посмотреть свои данные, то отправь команду /" + model.CmdMyData + "\n\n" +
+		"Кому выдавать твой адрес, можно выбрать командой /" + model.CmdPrivacy + "\n\n" +
+		"Всё, что бот о тебе знает, можно выгрузить командой /" + model.CmdExportMe +
+		", а удалить свои данные — командой /" + model.CmdDeleteMe + "\n\n" +
+		"Когда отправишь открытку, напиши /" + model.CmdSent + " и код открытки, " +
+		"а когда получишь свою, напиши /" + model.CmdReceived + " и код с неё.\n\n" +
+		"Всё это можно сделать и кнопками: /" + model.CmdMenu
 
 	handlers = append(handlers,
 		&commandHandler{
@@ -47,246 +57,450 @@ func makeHandlers() (handlers []*commandHandler) {
 		&commandHandler{
 			name:       "start",
 			desc:       "Что почём",
-			handleFunc: stringHandler(help),
+			handleFunc: b.startHandler(help),
+		},
+		&commandHandler{
+			name:       model.CmdMenu,
+			desc:       "Меню",
+			handleFunc: b.menuHandler("Что будем делать?"),
+		},
+		&commandHandler{
+			name: model.CmdRegister,
+			desc: "Заполнить данные по шагам",
+			flow: b.registerFlow(),
 		},
 		&commandHandler{
-			name:       model.CmdGiveMeSome,
-			desc:       "Взять адрес",
-			handleFunc: giveMeHandler(),
+			name: model.CmdGiveMeSome,
+			desc: "Взять адрес",
+			flow: b.giveMeFlow(),
 		},
 		&commandHandler{
-			name:       model.CmdAddAddress,
-			desc:       "Добавить адрес",
-			handleFunc: addAddressHandler(),
+			name: model.CmdAddAddress,
+			desc: "Добавить адрес",
+			flow: b.addAddressFlow(),
 		},
 		&commandHandler{
-			name:       model.CmdAddInstagram,
-			desc:       "Добавить Instagram",
-			handleFunc: addInstagramHandler(),
+			name: model.CmdAddInstagram,
+			desc: "Добавить Instagram",
+			flow: b.fieldFlow(model.CmdAddInstagram,
+				"Давай добавим Instagram! Просто напиши свой ник в следующем сообщении.",
+				"Instagram добавлен!", db.AddInstagram),
 		},
 		&commandHandler{
-			name:       model.CmdAddWishes,
-			desc:       "Добавить пожелания",
-			handleFunc: addWishesHandler(),
+			name: model.CmdAddWishes,
+			desc: "Добавить пожелания",
+			flow: b.fieldFlow(model.CmdAddWishes,
+				"Давай добавим пожелания! Просто напиши их в следующем сообщении.",
+				"Пожелания добавлены!", db.AddWishes),
 		},
 		&commandHandler{
-			name:       model.CmdAddPersonName,
-			desc:       "Добавить ФИО",
-			handleFunc: addPersonNameHandler(),
+			name: model.CmdAddPersonName,
+			desc: "Добавить ФИО",
+			flow: b.fieldFlow(model.CmdAddPersonName,
+				"Давай добавим ФИО! Просто напиши их в следующем сообщении.",
+				"ФИО добавлены!", db.AddPersonName),
 		},
 		&commandHandler{
 			name:       model.CmdMyData,
 			desc:       "Посмотреть свои данные",
-			handleFunc: myDataHandler(),
+			handleFunc: b.myDataHandler(),
+		},
+		&commandHandler{
+			name: model.CmdPrivacy,
+			desc: "Кому выдавать мой адрес",
+			flow: b.privacyFlow(),
+		},
+		&commandHandler{
+			name: model.CmdFilter,
+			desc: "Из каких стран выдавать адреса",
+			flow: b.filterFlow(),
+		},
+		&commandHandler{
+			name:       model.CmdExportMe,
+			desc:       "Выгрузить мои данные",
+			handleFunc: b.exportMeHandler(),
+		},
+		&commandHandler{
+			name: model.CmdDeleteMe,
+			desc: "Удалить мои данные",
+			flow: b.deleteMeFlow(),
+		},
+		&commandHandler{
+			name:       model.CmdSent,
+			desc:       "Я отправил открытку",
+			handleFunc: b.sentHandler(),
+		},
+		&commandHandler{
+			name:       model.CmdReceived,
+			desc:       "Я получил открытку",
+			handleFunc: b.receivedHandler(),
+		},
+		&commandHandler{
+			name:       fsm.CmdBack,
+			desc:       "Вернуться на шаг назад",
+			handleFunc: b.backHandler(),
+		},
+		&commandHandler{
+			name:       fsm.CmdCancel,
+			desc:       "Отменить",
+			handleFunc: b.cancelHandler(),
 		},
 	)
 
-	return handlers
+	return append(handlers, b.adminHandlers()...)
 }
 
 func stringHandler(s string) updateHandleFunc {
-	return func(upd tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+	return func(_ context.Context, upd tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
 		return tgbotapi.NewMessage(upd.Message.Chat.ID, s), nil
 	}
 }
 
-func giveMeHandler() updateHandleFunc {
-	return func(update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
-
-		a, err := db.FindByTg(defaultCtx(), s.Telegram)
+// startHandler greets newcomers with the registration, the others get help.
+func (b *MyBot) startHandler(help string) updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+		a, err := db.FindByTg(ctx, b.Addresses, s.Telegram)
 		if err != nil {
 			return nil, xerrors.Errorf("finding by telegram '%s': %w", s.Telegram, err)
 		}
 
-		switch {
-		case a.Address == "":
-			msg.Text = "Ты не добавил адрес. Напиши /" + model.CmdAddAddress + " чтобы добавить."
+		if !a.IsEmpty() {
+			return b.menuHandler(help)(ctx, update, s)
+		}
 
-			return msg, nil
-		case !a.Approved:
-			msg.Text = "Модераторы ещё не одобрили твои данные. " +
-				"Подожди немного или напиши модераторам: @rain_aroma или @OneTone." +
-				"Мы стараемся давать доступ только проверенным людям."
+		r, err := b.flows.Start(ctx, s, model.CmdRegister, "")
+		if err != nil {
+			return nil, err
+		}
 
-			return msg, nil
-		case update.Message.Text == "/"+model.CmdGiveMeSome:
-			msg.Text = "Отлично! Теперь напиши ник в Telegram/Instagram чтобы я мог найти адрес."
-			msg.Text += `Или, если хочешь случайный адрес, то просто напиши "ok".`
+		r.Text = "Добро пожаловать домой! Этот бот создан для посткроссинга бёрнеров по всему миру. " +
+			"Давай заполним твои данные, чтобы тебе могли отправлять письма и открытки. " +
+			"На любом шаге можно вернуться /" + fsm.CmdBack + " или всё отменить /" + fsm.CmdCancel + ".\n\n" + r.Text
 
-			return msg, nil
-		case update.Message.Text == "":
-			msg.Text = "Ты не написал ничего. Я понимаю только текст. Напиши ник в Telegram например."
+		return b.flowMessage(update.Message.Chat.ID, s, r), nil
+	}
+}
 
-			return msg, nil
-		}
+// menuHandler sends the text with the main menu buttons.
+func (b *MyBot) menuHandler(text string) updateHandleFunc {
+	return func(_ context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+		return b.flowMessage(update.Message.Chat.ID, s, fsm.Reply{
+			Text: text,
+			Buttons: []fsm.Button{
+				{Text: "Взять адрес", Data: route(routeCommand, model.CmdGiveMeSome)},
+				{Text: "Мои данные", Data: route(routeCommand, model.CmdMyData)},
+				{Text: "Заполнить данные", Data: route(routeCommand, model.CmdRegister)},
+				{Text: "Помощь", Data: route(routeCommand, "help")},
+			},
+		}), nil
+	}
+}
 
-		searchReq := strings.Replace(update.Message.Text, "/"+model.CmdGiveMeSome, "", 1)
-		searchReq = strings.TrimSpace(searchReq)
+// flowMessage shows reply buttons as an inline keyboard.
+// Without buttons the reply keyboard is removed, it could be left by the bot's older versions.
+func (b *MyBot) flowMessage(chatID int64, st *model.State, r fsm.Reply) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(chatID, r.Text)
 
-		if strings.EqualFold(searchReq, "ok") || strings.EqualFold(searchReq, "ок") {
-			msg.Text = "Корейский рандом сказал дать тебе это:\n" + db.Random().String()
-			s.GivenAddressesCtr++
+	if kb := b.inlineKeyboard(chatID, st, r.Buttons); kb != nil {
+		msg.ReplyMarkup = kb
+	} else {
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	}
 
-			return msg, nil
-		}
+	return msg
+}
 
-		res, err := db.Search(searchReq)
-		if err != nil {
-			return nil, xerrors.Errorf("searching (req=%q): %w", searchReq, err)
-		}
+const noTextMsg = "Ты не написал ничего. Я понимаю только текст."
 
-		s.GivenAddressesCtr += len(res)
+const (
+	randomButton = "Случайный адрес"
+	// searchPageSize is how many found addresses are shown at once
+	searchPageSize = 3
+)
 
-		switch len(res) {
-		case 0:
-			msg.Text = "Я ничего не нашёл =("
-		case 1:
-			msg.Text = "Я нашёл!\n" + res[0]
-		default:
-			msg.Text = "Ого, да тут много адресов...\n"
+func (b *MyBot) giveMeFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: model.CmdGiveMeSome,
+		Check: func(ctx context.Context, c *fsm.Conv) (string, error) {
+			return b.receiveText(ctx, c.State)
+		},
+		Steps: []*fsm.Step{{
+			Name: "query",
+			Prompt: fsm.Say("Отлично! Теперь напиши ник в Telegram/Instagram чтобы я мог найти адрес. " +
+				`Или, если хочешь случайный адрес, нажми кнопку или просто напиши "ok". ` +
+				"Чтобы выбрать страну, добавь фильтр, например country:DE, подробнее: /" + model.CmdFilter),
+			Buttons:  []string{randomButton},
+			Validate: fsm.NotEmpty(noTextMsg + " Напиши ник в Telegram например."),
+			Set: func(_ context.Context, c *fsm.Conv, text string) error {
+				if _, _, err := postal.ParseFilter(text); err != nil {
+					return filterInvalid(err)
+				}
+
+				c.Put("query", text)
+
+				return nil
+			},
+		}},
+		Done: func(ctx context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			searchReq := c.Get("query")
+
+			f, rest, err := postal.ParseFilter(searchReq)
+			if err != nil {
+				return fsm.Reply{}, xerrors.Errorf("parsing filter of %q: %w", searchReq, err)
+			}
 
-			for i, s := range res {
-				msg.Text += "Номер " + strconv.Itoa(i) + ":\n" + s
+			// the filter alone asks for a random address
+			if rest == "" || rest == randomButton || strings.EqualFold(rest, "ok") || strings.EqualFold(rest, "ок") {
+				if f.IsEmpty() {
+					if f, err = b.savedFilter(ctx, c.State); err != nil {
+						return fsm.Reply{}, err
+					}
+				}
+
+				exclude, err := b.exclusions(ctx, c.State)
+				if err != nil {
+					return fsm.Reply{}, err
+				}
+
+				r, err := db.Random(ctx, b.Addresses, b.draw, exclude, f.Codes())
+				if err != nil {
+					return fsm.Reply{}, xerrors.Errorf("getting random address: %w", err)
+				}
+
+				if r == nil {
+					return fsm.Reply{Text: "Пока что здесь нет новых адресов для тебя =(" + filterNote(f)}, nil
+				}
+
+				pp, err := b.given(ctx, c.State, model.MethodRandom, r)
+				if err != nil {
+					return fsm.Reply{}, err
+				}
+
+				return fsm.Reply{
+					Text: "Корейский рандом сказал дать тебе это:\n" + addressText(r) + postcardText(pp[r.Telegram]) +
+						filterNote(f),
+				}, nil
 			}
-		}
 
-		return msg, nil
+			return b.searchPage(ctx, c.State, searchReq, 0)
+		},
 	}
 }
 
-func addAddressHandler() updateHandleFunc {
-	return func(update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
-
-		if update.Message.Text == "/"+model.CmdAddAddress {
-			msg.Text = "Давай добавим адрес! Просто напиши его в следующем сообщении."
+// searchResults returns texts of found addresses, addresses of participants who don't give them on search are
+// hidden, there are buttons to ask those who want to be asked. Shown addresses are recorded as given.
+func (b *MyBot) searchResults(ctx context.Context, st *model.State, query string, aa []*model.Address) (
+	[]string, []fsm.Button, error,
+) {
+	shown := lo.Filter(aa, func(a *model.Address, _ int) bool { return revealed(a, st) })
 
-			return msg, nil
-		}
+	pp, err := b.given(ctx, st, model.MethodSearch, shown...)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		addr := strings.Replace(update.Message.Text, "/"+model.CmdAddAddress, "", 1)
-		addr = strings.TrimSpace(addr)
-		addr = strings.ReplaceAll(addr, "  ", " ")
+	texts := make([]string, len(aa))
 
-		if len(addr) < 10 {
-			msg.Text = "Сомневаюсь что это твой адрес, какой-то он короткий. Попробуй ещё раз."
+	var buttons []fsm.Button
 
-			return msg, nil
-		}
+	for i, a := range aa {
+		if revealed(a, st) {
+			texts[i] = addressText(a) + postcardText(pp[a.Telegram])
 
-		if err := db.AddAddress(defaultCtx(), s.Telegram, addr); err != nil {
-			return nil, xerrors.Errorf("adding address (req=%q): %w", addr, err)
+			continue
 		}
 
-		msg.Text = "Адрес добавлен!"
+		text, button := hiddenResult(a, query)
+		texts[i] = text
 
-		return msg, nil
+		if button != nil {
+			buttons = append(buttons, *button)
+		}
 	}
+
+	return texts, buttons, nil
 }
 
-func addInstagramHandler() updateHandleFunc {
-	return func(update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+// searchPage returns the page of search results, there are buttons to the other pages if there are many.
+func (b *MyBot) searchPage(ctx context.Context, st *model.State, req string, page int) (fsm.Reply, error) {
+	f, rest, err := postal.ParseFilter(req)
+	if err != nil {
+		return fsm.Reply{}, xerrors.Errorf("parsing filter of %q: %w", req, err)
+	}
 
-		if update.Message.Text == "/"+model.CmdAddInstagram {
-			msg.Text = "Давай добавим Instagram! Просто напиши свой ник в следующем сообщении."
+	res, err := db.Search(ctx, b.Addresses, rest, f.Codes())
+	if err != nil {
+		return fsm.Reply{}, xerrors.Errorf("searching (req=%q): %w", rest, err)
+	}
 
-			return msg, nil
+	// the default filter doesn't hide the one who is looked up by nick, phone or email
+	if f.IsEmpty() && !db.Exact(res, rest) {
+		if f, err = b.savedFilter(ctx, st); err != nil {
+			return fsm.Reply{}, err
 		}
 
-		inst := strings.Replace(update.Message.Text, "/"+model.CmdAddInstagram, "", 1)
-		inst = strings.TrimSpace(inst)
+		res = lo.Filter(res, func(a *model.Address, _ int) bool { return f.Match(a.Country) })
+	}
 
-		if err := db.AddInstagram(defaultCtx(), s.Telegram, inst); err != nil {
-			return nil, xerrors.Errorf("adding instagram (req=%q): %w", inst, err)
+	switch {
+	case len(res) == 0:
+		return fsm.Reply{Text: "Я ничего не нашёл =(" + filterNote(f)}, nil
+	case len(res) == 1:
+		texts, buttons, err := b.searchResults(ctx, st, rest, res)
+		if err != nil {
+			return fsm.Reply{}, err
 		}
 
-		msg.Text = "Instagram добавлен!"
+		return fsm.Reply{Text: "Я нашёл!\n" + texts[0] + filterNote(f), Buttons: buttons}, nil
+	}
 
-		return msg, nil
+	pages := (len(res) + searchPageSize - 1) / searchPageSize
+	if page < 0 || page >= pages {
+		page = pages - 1
 	}
-}
 
-func addWishesHandler() updateHandleFunc {
-	return func(update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+	from := page * searchPageSize
+	to := from + searchPageSize
 
-		if update.Message.Text == "/"+model.CmdAddWishes {
-			msg.Text = "Давай добавим пожелания! Просто напиши их в следующем сообщении."
+	if to > len(res) {
+		to = len(res)
+	}
 
-			return msg, nil
-		}
+	texts, buttons, err := b.searchResults(ctx, st, rest, res[from:to])
+	if err != nil {
+		return fsm.Reply{}, err
+	}
 
-		wishes := strings.Replace(update.Message.Text, "/"+model.CmdAddWishes, "", 1)
-		wishes = strings.TrimSpace(wishes)
+	r := fsm.Reply{Text: "Ого, да тут много адресов...", Buttons: buttons}
+	if pages > 1 {
+		r.Text += " Страница " + strconv.Itoa(page+1) + " из " + strconv.Itoa(pages) + "."
+	}
 
-		if err := db.AddWishes(defaultCtx(), s.Telegram, wishes); err != nil {
-			return nil, xerrors.Errorf("adding wishes (req=%q): %w", wishes, err)
-		}
+	for i := from; i < to; i++ {
+		r.Text += "\n\nНомер " + strconv.Itoa(i+1) + ":\n" + texts[i-from]
+	}
 
-		msg.Text = "Пожелания добавлены!"
+	r.Text += filterNote(f)
+	// the filter goes to the buttons, so the pages don't change if the default one is changed meanwhile
+	req = strings.TrimSpace(f.String() + " " + rest)
 
-		return msg, nil
+	// the request is kept in the buttons, if it doesn't fit there, only the first page is shown
+	if page > 0 {
+		r.Buttons = append(r.Buttons, fsm.Button{Text: "← Назад", Data: route(routePage, strconv.Itoa(page-1), req)})
 	}
+
+	if page+1 < pages {
+		r.Buttons = append(r.Buttons, fsm.Button{Text: "Дальше →", Data: route(routePage, strconv.Itoa(page+1), req)})
+	}
+
+	return r, nil
 }
 
-func addPersonNameHandler() updateHandleFunc {
-	return func(update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+func (b *MyBot) addAddressFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name:  model.CmdAddAddress,
+		Steps: addressSteps(fsm.End),
+		Done: func(ctx context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			a := draftParts(c)
 
-		if update.Message.Text == "/"+model.CmdAddPersonName {
-			msg.Text = "Давай добавим ФИО! Просто напиши их в следующем сообщении."
+			old, err := db.FindByTg(ctx, b.Addresses, ownTelegram(c.State))
+			if err != nil {
+				return fsm.Reply{}, xerrors.Errorf("getting address of '%s': %w", c.Telegram, err)
+			}
 
-			return msg, nil
-		}
+			if a.Structured() {
+				if err := db.AddPostalAddress(ctx, b.Addresses, c.Telegram, a); err != nil {
+					return fsm.Reply{}, xerrors.Errorf("adding postal address (req=%q): %w", a.Address, err)
+				}
+			} else if err := db.AddAddress(ctx, b.Addresses, c.Telegram, a.Address); err != nil {
+				return fsm.Reply{}, xerrors.Errorf("adding address (req=%q): %w", a.Address, err)
+			}
+
+			if old.ID != 0 {
+				return fsm.Reply{Text: "Адрес добавлен!"}, nil
+			}
 
-		name := strings.Replace(update.Message.Text, "/"+model.CmdAddPersonName, "", 1)
-		name = strings.TrimSpace(name)
+			// the participant has skipped registration, the new address is checked all the same
+			saved, err := db.Submit(ctx, b.Addresses, c.Telegram, c.ChatID)
+			if err != nil {
+				return fsm.Reply{}, xerrors.Errorf("sending '%s' to moderators: %w", c.Telegram, err)
+			}
+
+			b.Announce(ctx, saved)
 
-		if err := db.AddPersonName(defaultCtx(), s.Telegram, name); err != nil {
-			return nil, xerrors.Errorf("adding name (req=%q): %w", name, err)
+			return fsm.Reply{Text: "Адрес добавлен! Теперь его проверят модераторы. Имя и остальное можно дописать: /" +
+				model.CmdRegister}, nil
+		},
+	}
+}
+
+// fieldFlow asks one address field and saves it with set.
+func (b *MyBot) fieldFlow(
+	name, prompt, done string,
+	set func(ctx context.Context, s db.AddressStore, tg, v string) error,
+) *fsm.Flow {
+	return &fsm.Flow{
+		Name: name,
+		Steps: []*fsm.Step{{
+			Name:     "value",
+			Prompt:   fsm.Say(prompt),
+			Validate: fsm.NotEmpty(noTextMsg + " " + prompt),
+			Set: func(ctx context.Context, c *fsm.Conv, text string) error {
+				if err := set(ctx, b.Addresses, c.Telegram, text); err != nil {
+					return xerrors.Errorf("saving %s (req=%q): %w", name, text, err)
+				}
+
+				return nil
+			},
+		}},
+		Done: fsm.Text(done),
+	}
+}
+
+func (b *MyBot) backHandler() updateHandleFunc {
+	return func(_ context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+		r, ok := b.flows.Back(s)
+		if !ok {
+			return tgbotapi.NewMessage(update.Message.Chat.ID, "Назад некуда. Чтобы отменить, напиши /"+fsm.CmdCancel), nil
 		}
 
-		msg.Text = "ФИО добавлены!"
+		return b.flowMessage(update.Message.Chat.ID, s, r), nil
+	}
+}
 
-		return msg, nil
+func (b *MyBot) cancelHandler() updateHandleFunc {
+	return func(_ context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+		text := "Нечего отменять."
+		if b.flows.Cancel(s) {
+			text = fsm.Canceled
+		}
+
+		return b.flowMessage(update.Message.Chat.ID, s, fsm.Reply{Text: text}), nil
 	}
 }
 
-func myDataHandler() updateHandleFunc {
-	return func(update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+func (b *MyBot) myDataHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
 
-		addr, err := db.FindByTg(defaultCtx(), s.Telegram)
+		addr, err := db.FindByTg(ctx, b.Addresses, s.Telegram)
 		if err != nil {
 			return nil, xerrors.Errorf("searching address by (tg=%q): %w", s.Telegram, err)
 		}
 
 		if addr.IsEmpty() {
-			msg.Text = "Ты ещё не добавил свои данные. Начни с адреса /" + model.CmdAddAddress
+			msg.Text = "Ты ещё не добавил свои данные. Заполни их по шагам: /" + model.CmdRegister
 
 			return msg, nil
 		}
 
-		msg.Text = "Вот твои данные:\n" + addr.String()
+		msg.Text = "Вот твои данные:\n" + addressText(addr)
 		if addr.Instagram != "" {
 			msg.Text += "\nInstagram: " + addr.Instagram + "."
 		}
 
+		msg.Text += "\n\n" + statusText(addr) + "\n\n" + privacyText(addr.Privacy) + " Поменять: /" + model.CmdPrivacy
+
 		return msg, nil
 	}
 }
-
-const defaultCtxTimeout = 10 * time.Second
-
-func defaultCtx() context.Context {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultCtxTimeout)
-
-	go func() {
-		<-ctx.Done()
-		cancel()
-	}()
-
-	return ctx
-}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/postal"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+const noFilterButton = "Любые страны"
+
+const filterHelp = "Фильтр пишется так: country:DE,AT — страны, их можно писать и по-русски; " +
+	"region:europe — часть света: europe, asia, americas или oceania; lang:de — язык страны. " +
+	"Условия можно сочетать, например region:europe lang:de. " +
+	"Адреса, у которых не указана страна, под фильтр не попадают."
+
+// filterText describes the filter to the participant.
+func filterText(f postal.Filter) string {
+	if f.IsEmpty() {
+		return "Адреса выдаются из любых стран."
+	}
+
+	return "Адреса выдаются только по фильтру " + f.String() + "."
+}
+
+// filterNote is added to given addresses, so it's seen why some addresses don't show up.
+func filterNote(f postal.Filter) string {
+	if f.IsEmpty() {
+		return ""
+	}
+
+	return "\n\nФильтр: " + f.String() + ". Поменять: /" + model.CmdFilter
+}
+
+// filterInvalid turns errors of postal.ParseFilter into messages for the participant.
+func filterInvalid(err error) error {
+	var fe *postal.FilterError
+
+	switch {
+	case xerrors.As(err, &fe):
+		return fsm.Invalid("Не знаю «" + fe.Value + "». " + filterHelp)
+	case xerrors.Is(err, postal.ErrNoCountries):
+		return fsm.Invalid("Под такой фильтр не подходит ни одна страна. Попробуй другой.")
+	}
+
+	return err
+}
+
+// savedFilter returns the participant's default filter, it's applied to random draws and name searches,
+// but not to exact lookups, e.g. by nick.
+func (b *MyBot) savedFilter(ctx context.Context, st *model.State) (postal.Filter, error) {
+	u, err := b.Users.Get(ctx, st.ChatID)
+	if err != nil {
+		return postal.Filter{}, xerrors.Errorf("getting user %d: %w", st.ChatID, err)
+	}
+
+	if u.DrawFilter == "" {
+		return postal.Filter{}, nil
+	}
+
+	f, _, err := postal.ParseFilter(u.DrawFilter)
+	if err != nil {
+		// countries data has changed since the filter was saved
+		log.Warn().Err(err).Int64("chat_id", st.ChatID).Str("filter", u.DrawFilter).Msg("default filter is ignored")
+
+		return postal.Filter{}, nil
+	}
+
+	return f, nil
+}
+
+func (b *MyBot) filterFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: model.CmdFilter,
+		Check: func(ctx context.Context, c *fsm.Conv) (string, error) {
+			u, err := b.Users.Get(ctx, c.ChatID)
+			if err != nil {
+				return "", xerrors.Errorf("getting user %d: %w", c.ChatID, err)
+			}
+
+			c.Put("filter", u.DrawFilter)
+
+			return "", nil
+		},
+		Steps: []*fsm.Step{{
+			Name: "filter",
+			Prompt: func(c *fsm.Conv) string {
+				f, _, _ := postal.ParseFilter(c.Get("filter"))
+
+				return filterText(f) + "\n\n" + filterHelp + "\n\nНапиши новый фильтр или нажми «" + noFilterButton + "». " +
+					"Для одного запроса фильтр можно написать и после /" + model.CmdGiveMeSome + "."
+			},
+			Buttons:  []string{noFilterButton},
+			Validate: fsm.NotEmpty(noTextMsg + " Напиши фильтр, например country:DE."),
+			Set: func(ctx context.Context, c *fsm.Conv, text string) error {
+				var f postal.Filter
+
+				if text != noFilterButton {
+					var (
+						rest string
+						err  error
+					)
+
+					if f, rest, err = postal.ParseFilter(text); err != nil {
+						return filterInvalid(err)
+					}
+
+					if rest != "" || f.IsEmpty() {
+						return fsm.Invalid("Не понимаю «" + text + "». " + filterHelp)
+					}
+				}
+
+				u, err := b.Users.Get(ctx, c.ChatID)
+				if err != nil {
+					return xerrors.Errorf("getting user %d: %w", c.ChatID, err)
+				}
+
+				u.DrawFilter = f.String()
+
+				if err := b.Users.Save(ctx, u); err != nil {
+					return xerrors.Errorf("saving filter of %d: %w", c.ChatID, err)
+				}
+
+				c.Put("filter", u.DrawFilter)
+
+				return nil
+			},
+		}},
+		Done: func(_ context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			f, _, _ := postal.ParseFilter(c.Get("filter"))
+
+			return fsm.Reply{Text: "Сохранил! " + filterText(f)}, nil
+		},
+	}
+}
@@ -0,0 +1,330 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/repo"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+// flowReason asks a moderator why an address is rejected or what to change in it,
+// it's started by the buttons under the address card.
+const flowReason = "moderation_reason"
+
+// reason flow data keys
+const (
+	keyTarget   = "target"
+	keyDecision = "decision"
+	keyReason   = "reason"
+	keyCardChat = "card_chat"
+	keyCardMsg  = "card_msg"
+)
+
+// pendingHandler shows the oldest address waiting for moderators.
+func (b *MyBot) pendingHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		pending, err := db.Pending(ctx, b.Addresses)
+		if err != nil {
+			return nil, xerrors.Errorf("getting pending addresses: %w", err)
+		}
+
+		if len(pending) == 0 {
+			return tgbotapi.NewMessage(chatID, "Никто не ждёт проверки."), nil
+		}
+
+		r := moderationCard(pending[0])
+		if len(pending) > 1 {
+			r.Text += "\n\nЭто самая давняя анкета, /" + model.CmdPending + " показывает её, пока её не проверят. " +
+				"Ещё ждут проверки: " + strconv.Itoa(len(pending)-1) + "."
+		}
+
+		return b.flowMessage(chatID, s, r), nil
+	}
+}
+
+func moderationCard(a *model.Address) fsm.Reply {
+	return fsm.Reply{
+		Text: cardText(a),
+		Buttons: []fsm.Button{
+			{Text: "Одобрить", Data: route(routeModerate, db.DecisionApprove, a.Telegram)},
+			{Text: "Отклонить", Data: route(routeModerate, db.DecisionReject, a.Telegram)},
+			{Text: "Попросить исправить", Data: route(routeModerate, db.DecisionChanges, a.Telegram)},
+		},
+	}
+}
+
+func cardText(a *model.Address) string {
+	return "Анкета @" + a.Telegram + "\n\n" + addressSummary(a)
+}
+
+// Announce sends the address card to the moderators chat or, if there is none, to every moderator.
+func (b *MyBot) Announce(ctx context.Context, a *model.Address) {
+	chats := []int64{b.cfg.ModeratorsChat}
+
+	if b.cfg.ModeratorsChat == 0 {
+		privileged, err := b.privileged(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("telegram", a.Telegram).Msg("getting moderators to check the address")
+
+			return
+		}
+
+		chats = lo.Keys(privileged)
+	}
+
+	if len(chats) == 0 {
+		log.Warn().Str("telegram", a.Telegram).Msg("there are no moderators to check the address")
+
+		return
+	}
+
+	r := moderationCard(a)
+	r.Text = "Новая анкета на проверку!\n\n" + r.Text
+
+	for _, chatID := range chats {
+		// the card has no answer buttons, so there is no flow state to make them
+		b.notify(ctx, b.flowMessage(chatID, &model.State{ChatID: chatID}, r))
+	}
+}
+
+// moderateCallback handles the buttons under the address card. Approval is done at once,
+// rejection and changes need a reason, so the moderator is asked for it in the private chat.
+func (b *MyBot) moderateCallback(ctx context.Context, q *tgbotapi.CallbackQuery, st *model.State, args []string) (
+	string, []tgbotapi.Chattable, error,
+) {
+	if len(args) != 2 {
+		return staleButton, nil, nil
+	}
+
+	u, err := b.user(ctx, q.From)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !allowed(b.role(u), model.RoleModerator) {
+		return "Это могут только модераторы.", nil, nil
+	}
+
+	decision, tg := args[0], args[1]
+
+	a, err := b.Addresses.Get(ctx, tg)
+	if err != nil {
+		return "", nil, xerrors.Errorf("getting address of '%s': %w", tg, err)
+	}
+
+	if a.ID == 0 || a.Approved || a.Moderation != model.ModerationPending {
+		card := tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID, decisionText(a, ""))
+
+		return "Эту анкету уже проверили.", []tgbotapi.Chattable{card}, nil
+	}
+
+	if decision == db.DecisionApprove {
+		if a, err = db.Moderate(ctx, b.Addresses, tg, decision, ""); err != nil {
+			return "", nil, xerrors.Errorf("approving '%s': %w", tg, err)
+		}
+
+		b.notify(ctx, b.statusMessage(a))
+
+		card := tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID, decisionText(a, q.From.UserName))
+
+		return "Одобрено.", []tgbotapi.Chattable{card}, nil
+	}
+
+	// the reason is asked in the private chat, so in a group chat the moderator's own state is used
+	mst := st
+	if q.From.ID != q.Message.Chat.ID {
+		// the card's chat is locked already, private chats are never locked before group ones
+		unlock := b.chats.lock(q.From.ID)
+		defer unlock()
+
+		if mst, err = b.States.Get(ctx, q.From.ID); err != nil {
+			return "", nil, xerrors.Errorf("getting state of moderator's chat: %w", err)
+		}
+
+		mst.Telegram = q.From.UserName
+	}
+
+	r, err := b.flows.StartWith(ctx, mst, flowReason, map[string]string{
+		keyTarget:   tg,
+		keyDecision: decision,
+		keyCardChat: strconv.FormatInt(q.Message.Chat.ID, 10),
+		keyCardMsg:  strconv.Itoa(q.Message.MessageID),
+	})
+	if err != nil {
+		return "", nil, xerrors.Errorf("starting reason flow: %w", err)
+	}
+
+	if mst == st {
+		return "", []tgbotapi.Chattable{b.flowMessage(q.From.ID, mst, r)}, nil
+	}
+
+	if err := b.States.Save(ctx, mst); err != nil {
+		return "", nil, xerrors.Errorf("saving state of moderator's chat: %w", err)
+	}
+
+	if _, err := b.sendWithRetries(ctx, b.flowMessage(q.From.ID, mst, r)); err != nil {
+		return "Напиши боту в личку и нажми кнопку ещё раз.", nil, nil
+	}
+
+	return "Напиши причину в личке с ботом.", nil, nil
+}
+
+func (b *MyBot) reasonFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: flowReason,
+		Steps: []*fsm.Step{{
+			Name: keyReason,
+			Prompt: func(c *fsm.Conv) string {
+				if c.Get(keyDecision) == db.DecisionReject {
+					return "Почему отклоняем @" + c.Get(keyTarget) + "? Причину я покажу участнику."
+				}
+
+				return "Что нужно исправить @" + c.Get(keyTarget) + "? Это я покажу участнику."
+			},
+			// rejection can be without a reason, but it must be clear what to change
+			Optional: func(c *fsm.Conv) bool { return c.Get(keyDecision) == db.DecisionReject },
+			Validate: fsm.NotEmpty(noTextMsg + " Напиши причину."),
+			Set: func(_ context.Context, c *fsm.Conv, text string) error {
+				c.Put(keyReason, text)
+
+				return nil
+			},
+		}},
+		Done: func(ctx context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			tg := c.Get(keyTarget)
+
+			a, err := b.Addresses.Get(ctx, tg)
+			if err != nil {
+				return fsm.Reply{}, xerrors.Errorf("getting address of '%s': %w", tg, err)
+			}
+
+			if a.ID == 0 || a.Approved || a.Moderation != model.ModerationPending {
+				return fsm.Reply{Text: "Пока ты писал, анкету @" + tg + " уже проверили."}, nil
+			}
+
+			if a, err = db.Moderate(ctx, b.Addresses, tg, c.Get(keyDecision), c.Get(keyReason)); err != nil {
+				return fsm.Reply{}, xerrors.Errorf("moderating '%s': %w", tg, err)
+			}
+
+			b.notify(ctx, b.statusMessage(a))
+
+			cardChat, _ := strconv.ParseInt(c.Get(keyCardChat), 10, 64)
+			cardMsg, _ := strconv.Atoi(c.Get(keyCardMsg))
+			b.notify(ctx, tgbotapi.NewEditMessageText(cardChat, cardMsg, decisionText(a, c.Telegram)))
+
+			return fsm.Reply{Text: "Готово, я написал @" + tg + "."}, nil
+		},
+	}
+}
+
+// decisionText is the card with the moderator's decision, by is who decided, if it's known.
+func decisionText(a *model.Address, by string) string {
+	if a.ID == 0 {
+		return "Анкеты @" + a.Telegram + " больше нет."
+	}
+
+	var status string
+
+	switch {
+	case a.Approved:
+		status = "✅ Одобрено"
+	case a.Moderation == model.ModerationRejected:
+		status = "❌ Отклонено"
+	case a.Moderation == model.ModerationChanges:
+		status = "✏️ Попросили исправить"
+	default:
+		status = "Ждёт проверки"
+	}
+
+	if by != "" {
+		status += ", проверил @" + by
+	}
+
+	if a.ModerationReason != "" {
+		status += ": " + a.ModerationReason
+	}
+
+	return cardText(a) + "\n\n" + status
+}
+
+// statusText tells the participant about the moderation of their address.
+func statusText(a *model.Address) string {
+	switch {
+	case a.Approved:
+		return "Твои данные одобрены! Теперь команда /" + model.CmdGiveMeSome + " выдаёт адреса."
+	case a.Moderation == model.ModerationPending:
+		return "Модераторы ещё не проверили твои данные, я напишу, как только проверят. " +
+			"Мы стараемся давать адреса только проверенным людям."
+	case a.Moderation == model.ModerationChanges:
+		return "Модераторы просят исправить данные: " + a.ModerationReason + "\n\n" +
+			"Исправить и снова отправить на проверку можно командой /" + model.CmdRegister
+	case a.Moderation == model.ModerationRejected:
+		text := "Модераторы отклонили твои данные."
+		if a.ModerationReason != "" {
+			text += " Причина: " + a.ModerationReason
+		}
+
+		return text
+	}
+
+	return "Твои данные ещё не проверены. Проверь их и отправь модераторам: /" + model.CmdRegister
+}
+
+// statusMessage tells the participant about the moderation, it's nil if there is no chat with them.
+func (b *MyBot) statusMessage(a *model.Address) tgbotapi.Chattable {
+	if a.ChatID == 0 {
+		log.Info().Str("telegram", a.Telegram).Msg("participant hasn't talked to the bot, status isn't sent")
+
+		return nil
+	}
+
+	return tgbotapi.NewMessage(a.ChatID, statusText(a))
+}
+
+// NotifyApproved tells the participant their address is approved, e.g. in the table.
+func (b *MyBot) NotifyApproved(ctx context.Context, a *model.Address) {
+	b.notify(ctx, b.statusMessage(a))
+}
+
+// notify sends a message not in reply to the update, e.g. to another chat, errors are only logged.
+func (b *MyBot) notify(ctx context.Context, msg tgbotapi.Chattable) {
+	if msg == nil {
+		return
+	}
+
+	if _, err := b.sendWithRetries(ctx, msg); err != nil {
+		log.Error().Err(err).Msg("sending notification")
+	}
+}
+
+// addressSummary lists the fields of the address, empty ones as dashes.
+func addressSummary(a *model.Address) string {
+	lines := make([]string, 0, 6)
+
+	for _, f := range []struct{ name, value string }{
+		{"Имя", a.PersonName},
+		{"Адрес", a.Address},
+		{"Instagram", a.Instagram},
+		{"Пожелания", a.Wishes},
+		{"Почта", a.Email},
+		{"Телефон", a.Phone},
+	} {
+		if f.value == "" {
+			f.value = "—"
+		}
+
+		lines = append(lines, f.name+": "+f.value)
+	}
+
+	return strings.Join(lines, "\n")
+}
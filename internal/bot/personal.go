@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/repo"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/xerrors"
+)
+
+const deleteButton = "Удалить"
+
+// personalData is everything the bot keeps about the participant, it's sent by /export_me
+type personalData struct {
+	ExportedAt time.Time
+	User       *model.User
+	// Address is nil if the participant hasn't added their data
+	Address *model.Address
+	// Requests are addresses given to the participant
+	Requests []*model.AddressRequest
+	// Postcards are sent by the participant or to them, senders of postcards to them aren't shown
+	Postcards []*model.Postcard
+	// Consents are requests for addresses made by the participant or to them
+	Consents []*model.Consent
+}
+
+func (b *MyBot) exportMeHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, st *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		data, err := b.personalData(ctx, chatID, st.Telegram)
+		if err != nil {
+			return nil, err
+		}
+
+		bb, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return nil, xerrors.Errorf("marshalling personal data: %w", err)
+		}
+
+		return tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+			Name:  "my-data-" + data.ExportedAt.Format("2006-01-02") + ".json",
+			Bytes: bb,
+		}), nil
+	}
+}
+
+func (b *MyBot) personalData(ctx context.Context, chatID int64, tg string) (*personalData, error) {
+	data := &personalData{ExportedAt: time.Now()}
+
+	var err error
+
+	if data.User, err = b.Users.Get(ctx, chatID); err != nil {
+		return nil, xerrors.Errorf("getting user %d: %w", chatID, err)
+	}
+
+	a, err := db.FindByTg(ctx, b.Addresses, tg)
+	if err != nil {
+		return nil, xerrors.Errorf("finding by telegram '%s': %w", tg, err)
+	}
+
+	if a.ID != 0 {
+		data.Address = a
+	}
+
+	if data.Requests, err = b.Requests.History(ctx, chatID); err != nil {
+		return nil, xerrors.Errorf("getting requests of %d: %w", chatID, err)
+	}
+
+	if data.Postcards, err = b.Postcards.Involving(ctx, chatID, tg); err != nil {
+		return nil, xerrors.Errorf("getting postcards of %d: %w", chatID, err)
+	}
+
+	for _, p := range data.Postcards {
+		if p.SenderID != chatID {
+			p.SenderID, p.Sender = 0, ""
+		}
+	}
+
+	if data.Consents, err = b.Consents.Involving(ctx, chatID, tg); err != nil {
+		return nil, xerrors.Errorf("getting consent requests of %d: %w", chatID, err)
+	}
+
+	return data, nil
+}
+
+func (b *MyBot) deleteMeFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: model.CmdDeleteMe,
+		Check: func(ctx context.Context, c *fsm.Conv) (string, error) {
+			a, err := db.FindByTg(ctx, b.Addresses, c.Telegram)
+			if err != nil {
+				return "", xerrors.Errorf("finding by telegram '%s': %w", c.Telegram, err)
+			}
+
+			if a.ID == 0 {
+				return "Твоих данных у меня нет, удалять нечего.", nil
+			}
+
+			c.Put("telegram", a.Telegram)
+
+			return "", nil
+		},
+		Steps: []*fsm.Step{{
+			Name: "confirm",
+			Prompt: fsm.Say("Точно удалить твои данные? Адрес, ФИО, телефон, почта, инстаграм и пожелания " +
+				"сотрутся из бота и из таблицы, твой адрес больше никому не выдадут. " +
+				"Вернуть их не получится, только заполнить заново."),
+			Buttons: []string{deleteButton, fsm.CancelButton},
+			Validate: func(text string) error {
+				if text != deleteButton {
+					return fsm.Invalid("Нажми «" + deleteButton + "» или «" + fsm.CancelButton + "».")
+				}
+
+				return nil
+			},
+		}},
+		Done: func(ctx context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			err := b.InTx(ctx, func(ctx context.Context) error {
+				return b.forget(ctx, c.ChatID, c.Get("telegram"))
+			})
+			if err != nil {
+				return fsm.Reply{}, err
+			}
+
+			return fsm.Reply{Text: "Твои данные удалены, а ник стёрт из истории выданных адресов, открыток и " +
+				"запросов разрешения.\n\n" +
+				"Остались номер этого чата и роль: по ним считается дневной лимит и работают запреты модераторов, " +
+				"а открытки, которые ты отправил, ещё можно получить. Ник остаётся только в списке удалённых, " +
+				"чтобы твоя строка не вернулась из таблицы участников.\n\n" +
+				"Если захочешь вернуться, заполни данные заново: /" + model.CmdRegister}, nil
+		},
+	}
+}
+
+// forget erases the participant's address and replaces their nick in the history with model.ErasedTelegram.
+// The address is erased last, as it's removed from the sync source at once, which isn't undone with the transaction.
+func (b *MyBot) forget(ctx context.Context, chatID int64, tg string) error {
+	if err := b.Users.Forget(ctx, chatID); err != nil {
+		return xerrors.Errorf("forgetting user %d: %w", chatID, err)
+	}
+
+	if err := b.Requests.Forget(ctx, tg); err != nil {
+		return xerrors.Errorf("forgetting requests of %q: %w", tg, err)
+	}
+
+	if err := b.Postcards.Forget(ctx, chatID, tg); err != nil {
+		return xerrors.Errorf("forgetting postcards of %q: %w", tg, err)
+	}
+
+	if err := b.Consents.Forget(ctx, chatID, tg); err != nil {
+		return xerrors.Errorf("forgetting consent requests of %q: %w", tg, err)
+	}
+
+	if err := b.Addresses.Erase(ctx, tg); err != nil {
+		return xerrors.Errorf("erasing address of %q: %w", tg, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/postcards"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+// expiryInterval is how often lost postcards are looked for
+const expiryInterval = time.Hour
+
+// postcardText tells the sender what to do with the postcard, it's added to the given address.
+func postcardText(p *model.Postcard) string {
+	if p == nil {
+		return ""
+	}
+
+	return "\n\nКод открытки: " + p.Code + ", напиши его на открытке. " +
+		"Когда отправишь, напиши /" + model.CmdSent + " " + p.Code
+}
+
+// postcard returns the postcard by the code in the command arguments, the reply is set if there is no such postcard.
+func (b *MyBot) postcard(ctx context.Context, update tgbotapi.Update) (*model.Postcard, string, error) {
+	arg := strings.TrimSpace(update.Message.CommandArguments())
+	if arg == "" {
+		return nil, "Напиши код с открытки после команды: /" + update.Message.Command() + " PB-12345", nil
+	}
+
+	code := postcards.ParseCode(arg)
+
+	p, err := b.Postcards.Get(ctx, code)
+	if err != nil {
+		return nil, "", xerrors.Errorf("getting postcard %q: %w", code, err)
+	}
+
+	if p == nil {
+		return nil, "Не знаю открытку " + code + ", проверь код.", nil
+	}
+
+	return p, "", nil
+}
+
+func (b *MyBot) sentHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, _ *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		if update.Message.CommandArguments() == "" {
+			return b.activePostcards(ctx, chatID)
+		}
+
+		p, text, err := b.postcard(ctx, update)
+		if err != nil || p == nil {
+			return tgbotapi.NewMessage(chatID, text), err
+		}
+
+		switch {
+		case p.SenderID != chatID:
+			return tgbotapi.NewMessage(chatID, "Это не твоя открытка, проверь код."), nil
+		case p.Status == model.PostcardExpired:
+			return tgbotapi.NewMessage(chatID, "Срок открытки "+p.Code+" уже истёк. Возьми новый адрес: /"+
+				model.CmdGiveMeSome), nil
+		case p.Status != model.PostcardAssigned:
+			return tgbotapi.NewMessage(chatID, "Открытку "+p.Code+" уже отметили."), nil
+		}
+
+		now := time.Now()
+		p.Status = model.PostcardSent
+		p.SentAt = &now
+
+		if err := b.Postcards.Save(ctx, p); err != nil {
+			return nil, xerrors.Errorf("saving postcard %q: %w", p.Code, err)
+		}
+
+		return tgbotapi.NewMessage(chatID, "Отлично, открытка "+p.Code+" в пути! Я напишу, когда @"+
+			p.Recipient+" её получит."), nil
+	}
+}
+
+// activePostcards lists postcards the sender hasn't sent or which haven't arrived yet.
+func (b *MyBot) activePostcards(ctx context.Context, chatID int64) (tgbotapi.Chattable, error) {
+	pp, err := b.Postcards.Active(ctx, chatID)
+	if err != nil {
+		return nil, xerrors.Errorf("listing postcards of %d: %w", chatID, err)
+	}
+
+	if len(pp) == 0 {
+		return tgbotapi.NewMessage(chatID, "У тебя нет неотправленных открыток. Взять адрес: /"+
+			model.CmdGiveMeSome), nil
+	}
+
+	lines := make([]string, 0, len(pp))
+
+	for _, p := range pp {
+		line := p.Code + " для @" + p.Recipient
+		if p.Status == model.PostcardSent {
+			line += ", в пути"
+		}
+
+		lines = append(lines, line)
+	}
+
+	return tgbotapi.NewMessage(chatID, "Напиши код открытки, которую отправил: /"+model.CmdSent+" PB-12345\n\n"+
+		"Твои открытки:\n"+strings.Join(lines, "\n")), nil
+}
+
+func (b *MyBot) receivedHandler() updateHandleFunc {
+	return func(ctx context.Context, update tgbotapi.Update, s *model.State) (tgbotapi.Chattable, error) {
+		chatID := update.Message.Chat.ID
+
+		p, text, err := b.postcard(ctx, update)
+		if err != nil || p == nil {
+			return tgbotapi.NewMessage(chatID, text), err
+		}
+
+		switch {
+		case !strings.EqualFold(p.Recipient, s.Telegram):
+			return tgbotapi.NewMessage(chatID, "Эта открытка не тебе, проверь код."), nil
+		case p.Status == model.PostcardReceived:
+			return tgbotapi.NewMessage(chatID, "Открытку "+p.Code+" уже отметили."), nil
+		}
+
+		// late postcards are received too, even if they were considered lost
+		now := time.Now()
+		p.Status = model.PostcardReceived
+		p.ReceivedAt = &now
+
+		if err := b.Postcards.Save(ctx, p); err != nil {
+			return nil, xerrors.Errorf("saving postcard %q: %w", p.Code, err)
+		}
+
+		b.notify(ctx, tgbotapi.NewMessage(p.SenderID, "Твою открытку "+p.Code+" получили! @"+p.Recipient+
+			" говорит спасибо. Ещё адрес: /"+model.CmdGiveMeSome))
+
+		return tgbotapi.NewMessage(chatID, "Ура, открытка "+p.Code+" дошла! Я сказал отправителю."), nil
+	}
+}
+
+// RunExpiry marks postcards which haven't arrived in time as lost and expires unanswered consent requests
+// until ctx is done.
+func (b *MyBot) RunExpiry(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		b.expirePostcards(ctx)
+		b.expireConsents(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expirePostcards tells senders of sent postcards they are lost, postcards which weren't sent expire silently.
+func (b *MyBot) expirePostcards(ctx context.Context) {
+	days := b.cfg.PostcardExpiry
+	if days <= 0 {
+		return
+	}
+
+	pp, err := b.Postcards.Expire(ctx, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Error().Err(err).Msg("expiring postcards")
+
+		return
+	}
+
+	for _, p := range pp {
+		if p.SentAt != nil {
+			b.notify(ctx, tgbotapi.NewMessage(p.SenderID, "Открытка "+p.Code+" для @"+p.Recipient+
+				" не дошла за "+strconv.Itoa(days)+" дней, видимо, потерялась. "+
+				"Если она всё же дойдёт, получатель сможет её отметить."))
+		}
+	}
+
+	if len(pp) > 0 {
+		log.Info().Int("postcards", len(pp)).Msg("postcards expired")
+	}
+}
@@ -0,0 +1,300 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/repo"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+// consent decisions in callback data
+const (
+	consentAsk  = "ask"
+	consentYes  = "yes"
+	consentDeny = "no"
+)
+
+// privacyButtons are answers of the privacy flow by mode
+var privacyButtons = map[string]string{
+	model.PrivacyOpen:   "Всем проверенным",
+	model.PrivacyRandom: "Только случайным",
+	model.PrivacyAsk:    "Спрашивать меня",
+}
+
+// privacyText describes the mode to the participant.
+func privacyText(privacy string) string {
+	switch privacy {
+	case model.PrivacyRandom:
+		return "Твой адрес выдаётся только в случайном выборе, по поиску его не найти."
+	case model.PrivacyAsk:
+		return "По поиску твой адрес выдаётся, только если ты разрешишь, а в случайном выборе — всегда."
+	}
+
+	return "Твой адрес выдаётся всем проверенным участникам: и в случайном выборе, и по поиску."
+}
+
+func (b *MyBot) privacyFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: model.CmdPrivacy,
+		Check: func(ctx context.Context, c *fsm.Conv) (string, error) {
+			a, err := db.FindByTg(ctx, b.Addresses, c.Telegram)
+			if err != nil {
+				return "", xerrors.Errorf("finding by telegram '%s': %w", c.Telegram, err)
+			}
+
+			if a.ID == 0 {
+				return "Ты ещё не добавил свои данные. Заполни их по шагам: /" + model.CmdRegister, nil
+			}
+
+			c.Put("privacy", a.Privacy)
+
+			return "", nil
+		},
+		Steps: []*fsm.Step{{
+			Name: "mode",
+			Prompt: func(c *fsm.Conv) string {
+				return privacyText(c.Get("privacy")) + "\n\nКому выдавать твой адрес?"
+			},
+			Buttons: []string{
+				privacyButtons[model.PrivacyOpen], privacyButtons[model.PrivacyRandom], privacyButtons[model.PrivacyAsk],
+			},
+			Validate: func(text string) error {
+				if privacyMode(text) == "" {
+					return fsm.Invalid("Выбери одну из кнопок.")
+				}
+
+				return nil
+			},
+			Set: func(ctx context.Context, c *fsm.Conv, text string) error {
+				c.Put("privacy", privacyMode(text))
+
+				return db.SetPrivacy(ctx, b.Addresses, c.Telegram, privacyMode(text))
+			},
+		}},
+		Done: func(_ context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			return fsm.Reply{Text: "Сохранил! " + privacyText(c.Get("privacy"))}, nil
+		},
+	}
+}
+
+func privacyMode(button string) string {
+	for mode, text := range privacyButtons {
+		if text == button {
+			return mode
+		}
+	}
+
+	return ""
+}
+
+// revealed reports whether the address is shown to the participant on search.
+func revealed(a *model.Address, st *model.State) bool {
+	return a.Telegram == ownTelegram(st) || a.Privacy == "" || a.Privacy == model.PrivacyOpen
+}
+
+// hiddenResult is shown on search instead of the address which isn't revealed,
+// participants who want to be asked get the button to ask them. The nick is shown only if it's what was searched,
+// otherwise the search by phone or name would tell whose they are.
+func hiddenResult(a *model.Address, query string) (string, *fsm.Button) {
+	if db.PrepareTelegram(query) != a.Telegram {
+		return "Нашёл адрес, но по поиску его не выдают.", nil
+	}
+
+	if a.Privacy == model.PrivacyAsk {
+		return "@" + a.Telegram + " просит сначала спросить разрешения.",
+			&fsm.Button{Text: "Спросить @" + a.Telegram, Data: route(routeConsent, consentAsk, a.Telegram)}
+	}
+
+	return "@" + a.Telegram + " получает открытки только через случайный выбор.", nil
+}
+
+// consentCallback handles asking for the address and the recipient's answer.
+func (b *MyBot) consentCallback(ctx context.Context, q *tgbotapi.CallbackQuery, st *model.State, args []string) (
+	string, []tgbotapi.Chattable, error,
+) {
+	if len(args) != 2 {
+		return staleButton, nil, nil
+	}
+
+	if args[0] == consentAsk {
+		return b.askConsent(ctx, st, args[1])
+	}
+
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return staleButton, nil, nil
+	}
+
+	return b.decideConsent(ctx, q, id, args[0] == consentYes)
+}
+
+// askConsent sends the recipient the request for the address.
+func (b *MyBot) askConsent(ctx context.Context, st *model.State, tg string) (string, []tgbotapi.Chattable, error) {
+	a, err := b.Addresses.Get(ctx, tg)
+	if err != nil {
+		return "", nil, xerrors.Errorf("getting address of '%s': %w", tg, err)
+	}
+
+	if a.ID == 0 || a.Privacy != model.PrivacyAsk {
+		return "@" + tg + " поменял настройки, поищи ещё раз.", nil, nil
+	}
+
+	chatID := a.ChatID
+	if chatID == 0 {
+		u, err := b.Users.FindByTelegram(ctx, tg)
+		if err != nil {
+			return "", nil, xerrors.Errorf("finding user '%s': %w", tg, err)
+		}
+
+		if u == nil {
+			return "Не могу спросить @" + tg + ", он ещё не писал боту.", nil, nil
+		}
+
+		chatID = u.ChatID
+	}
+
+	c, err := b.Consents.Pending(ctx, st.ChatID, tg)
+	if err != nil {
+		return "", nil, xerrors.Errorf("getting pending consent request to '%s': %w", tg, err)
+	}
+
+	if c != nil {
+		return "Я уже спросил @" + tg + ", жду ответа.", nil, nil
+	}
+
+	c = &model.Consent{
+		RequesterID:     st.ChatID,
+		Requester:       st.Telegram,
+		Recipient:       tg,
+		RecipientChatID: chatID,
+		Status:          model.ConsentPending,
+	}
+	if err := b.Consents.Create(ctx, c); err != nil {
+		return "", nil, xerrors.Errorf("creating consent request to '%s': %w", tg, err)
+	}
+
+	id := strconv.FormatInt(c.ID, 10)
+	prompt := b.flowMessage(chatID, &model.State{ChatID: chatID}, fsm.Reply{
+		Text: "@" + st.Telegram + " хочет отправить тебе открытку. Дать адрес?\n\n" +
+			"Если не ответишь за " + strconv.Itoa(int(b.cfg.ConsentTTL.Hours())) + " ч., адрес не дам. " +
+			"Настроить, кому выдавать адрес: /" + model.CmdPrivacy,
+		Buttons: []fsm.Button{
+			{Text: "Дать адрес", Data: route(routeConsent, consentYes, id)},
+			{Text: "Не давать", Data: route(routeConsent, consentDeny, id)},
+		},
+	})
+
+	if _, err := b.sendWithRetries(ctx, prompt); err != nil {
+		log.Warn().Err(err).Str("telegram", tg).Msg("asking for consent")
+
+		return "Не получилось спросить @" + tg + ", попробуй позже.", nil, nil
+	}
+
+	return "Спросил @" + tg + ", напишу, когда ответит.", nil, nil
+}
+
+// decideConsent saves the recipient's answer and tells it to the requester, giving the address if it's allowed.
+func (b *MyBot) decideConsent(ctx context.Context, q *tgbotapi.CallbackQuery, id int64, yes bool) (
+	string, []tgbotapi.Chattable, error,
+) {
+	c, err := b.Consents.Get(ctx, id)
+	if err != nil {
+		return "", nil, xerrors.Errorf("getting consent request %d: %w", id, err)
+	}
+
+	if c == nil || c.RecipientChatID != q.Message.Chat.ID {
+		return staleButton, []tgbotapi.Chattable{removeButtons(q)}, nil
+	}
+
+	switch c.Status {
+	case model.ConsentPending:
+	case model.ConsentExpired:
+		return "Запрос устарел.", []tgbotapi.Chattable{removeButtons(q)}, nil
+	default:
+		return "Ты уже ответил.", []tgbotapi.Chattable{removeButtons(q)}, nil
+	}
+
+	c.Status = model.ConsentDenied
+	answer, toRequester := "→ Не даём", "@"+c.Recipient+" не готов дать адрес. Возьми случайный: /"+model.CmdGiveMeSome
+
+	if yes {
+		c.Status = model.ConsentApproved
+		answer = "→ Разрешено"
+
+		var sent bool
+		if toRequester, sent, err = b.consentedAddress(ctx, c); err != nil {
+			return "", nil, err
+		}
+
+		if sent {
+			answer = "→ Адрес отправлен"
+		}
+	}
+
+	if err := b.Consents.Save(ctx, c); err != nil {
+		return "", nil, xerrors.Errorf("saving consent request %d: %w", c.ID, err)
+	}
+
+	b.notify(ctx, tgbotapi.NewMessage(c.RequesterID, toRequester))
+
+	return "", []tgbotapi.Chattable{tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID,
+		q.Message.Text+"\n\n"+answer)}, nil
+}
+
+// consentedAddress gives the address to the requester, it returns the message with it.
+// The address isn't given if it's gone or the requester can't get addresses now, then sent is false.
+func (b *MyBot) consentedAddress(ctx context.Context, c *model.Consent) (text string, sent bool, err error) {
+	a, err := b.Addresses.Get(ctx, c.Recipient)
+	if err != nil {
+		return "", false, xerrors.Errorf("getting address of '%s': %w", c.Recipient, err)
+	}
+
+	if a.ID == 0 {
+		return "@" + c.Recipient + " разрешил, но его адреса больше нет.", false, nil
+	}
+
+	// the requester could lose the approval or get enough addresses while the recipient was deciding
+	st := &model.State{ChatID: c.RequesterID, Telegram: c.Requester}
+
+	refusal, err := b.receiveText(ctx, st)
+	if err != nil {
+		return "", false, err
+	}
+
+	if refusal != "" {
+		return "@" + c.Recipient + " разрешил, но сейчас я не могу дать тебе адрес. " + refusal, false, nil
+	}
+
+	pp, err := b.given(ctx, st, model.MethodSearch, a)
+	if err != nil {
+		return "", false, err
+	}
+
+	return "@" + c.Recipient + " разрешил! Вот адрес:\n" + addressText(a) + postcardText(pp[a.Telegram]), true, nil
+}
+
+// expireConsents tells requesters the recipients haven't answered in time.
+func (b *MyBot) expireConsents(ctx context.Context) {
+	if b.cfg.ConsentTTL <= 0 {
+		return
+	}
+
+	cc, err := b.Consents.Expire(ctx, time.Now().Add(-b.cfg.ConsentTTL))
+	if err != nil {
+		log.Error().Err(err).Msg("expiring consent requests")
+
+		return
+	}
+
+	for _, c := range cc {
+		b.notify(ctx, tgbotapi.NewMessage(c.RequesterID, "@"+c.Recipient+" не ответил вовремя, адрес я не дам. "+
+			"Возьми случайный: /"+model.CmdGiveMeSome))
+	}
+}
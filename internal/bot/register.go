@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/repo"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+// registration draft is kept in flow data by these keys until it's confirmed
+const (
+	keyPersonName = "person_name"
+	keyAddress    = "address"
+	keyInstagram  = "instagram"
+	keyWishes     = "wishes"
+	keyEmail      = "email"
+	keyPhone      = "phone"
+)
+
+const (
+	confirmYes   = "Всё верно"
+	confirmAgain = "Заполнить заново"
+)
+
+// registerFlow walks through all the address fields and saves them at once after confirmation.
+func (b *MyBot) registerFlow() *fsm.Flow {
+	return &fsm.Flow{
+		Name: model.CmdRegister,
+		// the draft starts with what the user has already filled in, so skipping a step keeps the value
+		Check: func(ctx context.Context, c *fsm.Conv) (string, error) {
+			a, err := db.FindByTg(ctx, b.Addresses, c.Telegram)
+			if err != nil {
+				return "", xerrors.Errorf("finding by telegram '%s': %w", c.Telegram, err)
+			}
+
+			for k, v := range map[string]string{
+				keyPersonName: a.PersonName,
+				keyAddress:    a.Address,
+				keyInstagram:  a.Instagram,
+				keyWishes:     a.Wishes,
+				keyEmail:      a.Email,
+				keyPhone:      a.Phone,
+			} {
+				if v != "" {
+					c.Put(k, v)
+				}
+			}
+
+			putParts(c, a)
+
+			return "", nil
+		},
+		Steps: lo.Flatten([][]*fsm.Step{{
+			draftStep(keyPersonName, "Как тебя зовут? Напиши имя и фамилию, их напишут на конверте.", fsm.Always,
+				fsm.NotEmpty(noTextMsg+" Напиши имя и фамилию.")),
+		}, addressSteps(keyInstagram), {
+			draftStep(keyInstagram, "Ник в Instagram, чтобы отправитель мог тебя найти.", fsm.Always,
+				fsm.NotEmpty(noTextMsg+" Напиши ник или пропусти этот шаг.")),
+			draftStep(keyWishes, "Что ты хочешь получить по почте? Напиши пожелания для отправителя.", fsm.Always,
+				fsm.NotEmpty(noTextMsg+" Напиши пожелания или пропусти этот шаг.")),
+			draftStep(keyEmail, "Почта, чтобы модераторы могли с тобой связаться.", fsm.Always,
+				validateEmail),
+			draftStep(keyPhone, "Телефон, иногда его просят указать при отправке посылки.", fsm.Always,
+				validatePhone),
+			{
+				Name:    "confirm",
+				Prompt:  registerSummary,
+				Buttons: []string{confirmYes, confirmAgain, fsm.CancelButton},
+				Validate: func(text string) error {
+					if text != confirmYes && text != confirmAgain {
+						return fsm.Invalid("Нажми «" + confirmYes + "», «" + confirmAgain + "» или «" + fsm.CancelButton + "».")
+					}
+
+					return nil
+				},
+				Set: func(_ context.Context, c *fsm.Conv, text string) error {
+					c.Put("confirm", text)
+
+					return nil
+				},
+				Next: func(c *fsm.Conv) string {
+					if c.Get("confirm") == confirmAgain {
+						return keyPersonName
+					}
+
+					return fsm.End
+				},
+			},
+		}}),
+		Done: func(ctx context.Context, c *fsm.Conv) (fsm.Reply, error) {
+			a := draftAddress(c)
+
+			if err := db.AddFields(ctx, b.Addresses, c.Telegram, a); err != nil {
+				return fsm.Reply{}, xerrors.Errorf("saving registration: %w", err)
+			}
+
+			saved, err := db.Submit(ctx, b.Addresses, c.Telegram, c.ChatID)
+			if err != nil {
+				return fsm.Reply{}, xerrors.Errorf("sending '%s' to moderators: %w", c.Telegram, err)
+			}
+
+			if saved.Approved {
+				return fsm.Reply{Text: "Данные сохранены!"}, nil
+			}
+
+			b.Announce(ctx, saved)
+
+			return fsm.Reply{Text: "Спасибо, данные сохранены! Теперь их проверят модераторы: " +
+				"мы стараемся давать адреса только проверенным людям. " +
+				"Как только данные проверят, я напишу, и команда /" + model.CmdGiveMeSome + " начнёт выдавать адреса."}, nil
+		},
+	}
+}
+
+// draftStep asks a field and keeps the answer in the draft.
+func draftStep(key, prompt string, optional func(c *fsm.Conv) bool, validate func(text string) error) *fsm.Step {
+	return &fsm.Step{
+		Name: key,
+		Prompt: func(c *fsm.Conv) string {
+			if v := c.Get(key); v != "" {
+				return prompt + "\n\nСейчас: " + v
+			}
+
+			return prompt
+		},
+		Optional: optional,
+		Validate: validate,
+		Set: func(_ context.Context, c *fsm.Conv, text string) error {
+			c.Put(key, strings.Join(strings.Fields(text), " "))
+
+			return nil
+		},
+	}
+}
+
+func draftAddress(c *fsm.Conv) *model.Address {
+	a := draftParts(c)
+	a.PersonName = c.Get(keyPersonName)
+	a.Instagram = c.Get(keyInstagram)
+	a.Wishes = c.Get(keyWishes)
+	a.Email = c.Get(keyEmail)
+	a.Phone = c.Get(keyPhone)
+
+	return a
+}
+
+func registerSummary(c *fsm.Conv) string {
+	return "Проверь, всё ли верно:\n\n" + addressSummary(draftAddress(c))
+}
+
+func validateAddress(text string) error {
+	if len(text) < 10 {
+		return fsm.Invalid("Сомневаюсь что это твой адрес, какой-то он короткий. Попробуй ещё раз.")
+	}
+
+	return nil
+}
+
+func validateEmail(text string) error {
+	at := strings.Index(text, "@")
+	if at < 1 || !strings.Contains(text[at:], ".") || strings.ContainsAny(text, " \n") {
+		return fsm.Invalid("Это не похоже на почту. Напиши её как name@example.com или пропусти этот шаг.")
+	}
+
+	return nil
+}
+
+func validatePhone(text string) error {
+	digits := 0
+
+	for _, r := range text {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case !strings.ContainsRune("+-() ", r):
+			return fsm.Invalid("В телефоне должны быть только цифры. Попробуй ещё раз или пропусти этот шаг.")
+		}
+	}
+
+	if digits < 7 {
+		return fsm.Invalid("Какой-то короткий телефон. Попробуй ещё раз или пропусти этот шаг.")
+	}
+
+	return nil
+}
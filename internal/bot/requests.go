@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/repo"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+// quotaPeriod is the period DailyQuota is counted in
+const quotaPeriod = 24 * time.Hour
+
+// receiveText returns the reply for the participant who can't get addresses now: they have no approved address
+// or have got enough for today. It's empty if they can.
+func (b *MyBot) receiveText(ctx context.Context, st *model.State) (string, error) {
+	a, err := db.FindByTg(ctx, b.Addresses, st.Telegram)
+	if err != nil {
+		return "", xerrors.Errorf("finding by telegram '%s': %w", st.Telegram, err)
+	}
+
+	switch {
+	case a.Address == "":
+		return "Ты не добавил адрес. Напиши /" + model.CmdRegister + " чтобы заполнить свои данные.", nil
+	case !a.Approved:
+		return statusText(a), nil
+	}
+
+	return b.quotaText(ctx, st)
+}
+
+// quotaText returns the reply for the participant who has got enough addresses for today, empty if they haven't.
+func (b *MyBot) quotaText(ctx context.Context, st *model.State) (string, error) {
+	if b.cfg.DailyQuota <= 0 {
+		return "", nil
+	}
+
+	n, err := b.Requests.CountSince(ctx, st.ChatID, time.Now().Add(-quotaPeriod))
+	if err != nil {
+		return "", xerrors.Errorf("counting requests of %d: %w", st.ChatID, err)
+	}
+
+	if n < b.cfg.DailyQuota {
+		return "", nil
+	}
+
+	return "На сегодня хватит: за сутки можно получить адресов не больше " + strconv.Itoa(b.cfg.DailyQuota) +
+		". Отправь открытки тем, кого уже получил, и приходи завтра!", nil
+}
+
+// ownTelegram is the participant's nick the way addresses are kept, Telegram gives it in the case it was typed.
+func ownTelegram(st *model.State) string {
+	return db.PrepareTelegram(st.Telegram)
+}
+
+// exclusions returns telegram nicks random draw mustn't give to the participant: their own and already received.
+func (b *MyBot) exclusions(ctx context.Context, st *model.State) ([]string, error) {
+	tgs, err := b.Requests.Recipients(ctx, st.ChatID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting recipients of %d: %w", st.ChatID, err)
+	}
+
+	return append(tgs, ownTelegram(st)), nil
+}
+
+// given records addresses given to the participant and returns postcards to them by telegram nick.
+// Repeated addresses aren't recorded, e.g. when the search page is shown again, the postcard given before
+// is returned for them. There is no postcard to the participant's own address.
+func (b *MyBot) given(ctx context.Context, st *model.State, method string, aa ...*model.Address) (
+	map[string]*model.Postcard, error,
+) {
+	received, err := b.exclusions(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+
+	own := ownTelegram(st)
+	pp := make(map[string]*model.Postcard)
+
+	for _, a := range aa {
+		switch {
+		case a.Telegram == "" || a.Telegram == own:
+			continue
+		case lo.Contains(received, a.Telegram):
+			p, err := b.Postcards.Last(ctx, st.ChatID, a.Telegram)
+			if err != nil {
+				return nil, xerrors.Errorf("getting postcard from %d to '%s': %w", st.ChatID, a.Telegram, err)
+			}
+
+			if p != nil {
+				pp[a.Telegram] = p
+			}
+
+			continue
+		}
+
+		r := &model.AddressRequest{RequesterID: st.ChatID, Recipient: a.Telegram, Method: method}
+		if err := b.Requests.Add(ctx, r); err != nil {
+			return nil, xerrors.Errorf("recording address given to %d: %w", st.ChatID, err)
+		}
+
+		received = append(received, a.Telegram)
+
+		p := &model.Postcard{
+			SenderID:  st.ChatID,
+			Sender:    st.Telegram,
+			Recipient: a.Telegram,
+			Status:    model.PostcardAssigned,
+		}
+		if err := b.Postcards.Create(ctx, p); err != nil {
+			return nil, xerrors.Errorf("creating postcard from %d to '%s': %w", st.ChatID, a.Telegram, err)
+		}
+
+		pp[a.Telegram] = p
+	}
+
+	return pp, nil
+}
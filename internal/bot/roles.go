@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+const (
+	bannedMsg    = "Доступ к боту для тебя закрыт. Если это ошибка, напиши модераторам."
+	forbiddenMsg = "Эта команда не для тебя."
+)
+
+// roleRank orders roles, a command is allowed to users whose role is at least the command's one
+var roleRank = map[string]int{
+	model.RoleBanned:      0,
+	model.RoleParticipant: 1,
+	model.RoleModerator:   2,
+	model.RoleOwner:       3,
+}
+
+func allowed(role, required string) bool {
+	if required == "" {
+		required = model.RoleParticipant
+	}
+
+	return roleRank[role] >= roleRank[required]
+}
+
+// user returns the user who sent the update, the nick is kept up to date, so the user can be found by it.
+func (b *MyBot) user(ctx context.Context, from *tgbotapi.User) (*model.User, error) {
+	u, err := b.Users.Get(ctx, from.ID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting user %d: %w", from.ID, err)
+	}
+
+	if u.ID == 0 || u.Telegram != from.UserName {
+		u.Telegram = from.UserName
+
+		if err := b.Users.Save(ctx, u); err != nil {
+			return nil, xerrors.Errorf("saving user %d: %w", from.ID, err)
+		}
+	}
+
+	return u, nil
+}
+
+// role returns the user's role. Owners from config are always owners,
+// moderators from config are moderators unless their role is set via bot, e.g. they are banned.
+func (b *MyBot) role(u *model.User) string {
+	switch {
+	case lo.Contains(b.cfg.Owners, u.ChatID):
+		return model.RoleOwner
+	case u.Role != "":
+		return u.Role
+	case lo.Contains(b.cfg.Moderators, u.ChatID):
+		return model.RoleModerator
+	}
+
+	return model.RoleParticipant
+}
+
+// authorize is the middleware every command goes through, it returns the reply for users
+// who can't run the command, nil if they can.
+func (b *MyBot) authorize(ctx context.Context, update tgbotapi.Update) (tgbotapi.Chattable, error) {
+	u, err := b.user(ctx, update.Message.From)
+	if err != nil {
+		return nil, err
+	}
+
+	role := b.role(u)
+	if role == model.RoleBanned {
+		return tgbotapi.NewMessage(update.Message.Chat.ID, bannedMsg), nil
+	}
+
+	if h, ok := b.Handlers[update.Message.Command()]; ok && update.Message.IsCommand() && !allowed(role, h.role) {
+		return tgbotapi.NewMessage(update.Message.Chat.ID, forbiddenMsg), nil
+	}
+
+	return nil, nil
+}
+
+// privileged returns chats of moderators and owners, both from config and from DB.
+func (b *MyBot) privileged(ctx context.Context) (map[int64]string, error) {
+	uu, err := b.Users.List(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing users: %w", err)
+	}
+
+	for _, id := range append(append([]int64(nil), b.cfg.Owners...), b.cfg.Moderators...) {
+		if _, ok := lo.Find(uu, func(u *model.User) bool { return u.ChatID == id }); !ok {
+			uu = append(uu, &model.User{ChatID: id})
+		}
+	}
+
+	res := make(map[int64]string)
+
+	for _, u := range uu {
+		if role := b.role(u); allowed(role, model.RoleModerator) {
+			res[u.ChatID] = role
+		}
+	}
+
+	return res, nil
+}
+
+// commandsFor returns commands shown in the menu to users with the role.
+func (b *MyBot) commandsFor(role string) []tgbotapi.BotCommand {
+	var cmds []tgbotapi.BotCommand
+
+	for _, h := range b.handlerList {
+		if allowed(role, h.role) {
+			cmds = append(cmds, tgbotapi.BotCommand{Command: h.name, Description: h.desc})
+		}
+	}
+
+	return cmds
+}
+
+// setCommands shows the user commands of their role, participants and banned users see the default list.
+func (b *MyBot) setCommands(chatID int64, role string) error {
+	scope := tgbotapi.NewBotCommandScopeChat(chatID)
+
+	var req tgbotapi.Chattable = tgbotapi.NewDeleteMyCommandsWithScope(scope)
+	if allowed(role, model.RoleModerator) {
+		req = tgbotapi.NewSetMyCommandsWithScope(scope, b.commandsFor(role)...)
+	}
+
+	if _, err := b.Request(req); err != nil {
+		return xerrors.Errorf("setting commands of chat %d: %w", chatID, err)
+	}
+
+	return nil
+}
+
+// targetUser parses `@nick` argument of an admin command, the reply is set if the user isn't found.
+func (b *MyBot) targetUser(ctx context.Context, update tgbotapi.Update) (*model.User, string, error) {
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) == 0 {
+		return nil, "Напиши ник после команды: /" + update.Message.Command() + " @nick", nil
+	}
+
+	tg := strings.TrimPrefix(args[0], "@")
+
+	u, err := b.Users.FindByTelegram(ctx, tg)
+	if err != nil {
+		return nil, "", xerrors.Errorf("finding user '%s': %w", tg, err)
+	}
+
+	if u == nil {
+		return nil, "Не знаю @" + tg + ", он ещё не писал боту.", nil
+	}
+
+	return u, "", nil
+}
@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+const (
+	updatesModeWebhook = "webhook"
+	secretTokenHeader  = "X-Telegram-Bot-Api-Secret-Token"
+	webhookReadTimeout = 10 * time.Second
+
+	webhookShutdownTimeout = 5 * time.Second
+)
+
+func (b *MyBot) startWebhook(ctx context.Context) error {
+	u, err := url.Parse(b.cfg.WebhookURL)
+	if err != nil {
+		return fatalError{xerrors.Errorf("parsing webhook url %q: %w", b.cfg.WebhookURL, err)}
+	}
+
+	// bad certificate and busy address are found before telegram is told to send updates here
+	var certs []tls.Certificate
+
+	if b.cfg.WebhookCert != "" {
+		cert, err := tls.LoadX509KeyPair(b.cfg.WebhookCert, b.cfg.WebhookKey)
+		if err != nil {
+			return fatalError{xerrors.Errorf("loading webhook certificate: %w", err)}
+		}
+
+		certs = append(certs, cert)
+	}
+
+	ln, err := net.Listen("tcp", b.cfg.WebhookListen)
+	if err != nil {
+		return fatalError{xerrors.Errorf("listening on %q: %w", b.cfg.WebhookListen, err)}
+	}
+
+	if err := b.setWebhook(u); err != nil {
+		ln.Close()
+
+		return xerrors.Errorf("setting webhook: %w", err)
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, b.webhookHandler)
+
+	srv := &http.Server{
+		Addr:              b.cfg.WebhookListen,
+		Handler:           mux,
+		ReadHeaderTimeout: webhookReadTimeout,
+		TLSConfig:         &tls.Config{Certificates: certs, MinVersion: tls.VersionTLS12},
+	}
+
+	log.Info().
+		Str("listen", b.cfg.WebhookListen).
+		Str("path", path).
+		Bool("tls", b.cfg.WebhookCert != "").
+		Msg("starting webhook server")
+
+	served := make(chan struct{})
+	shutDown := make(chan struct{})
+
+	go func() {
+		defer close(shutDown)
+
+		select {
+		case <-served:
+			return
+		case <-ctx.Done():
+		}
+
+		shCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(shCtx); err != nil {
+			log.Error().Err(err).Msg("shutting down webhook server")
+		}
+	}()
+
+	if len(certs) > 0 {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+
+	// Serve returns as soon as shutdown begins, but requests being handled may still start handlers,
+	// so the bot isn't stopped until Shutdown waits for them
+	close(served)
+	<-shutDown
+
+	if errors.Is(err, http.ErrServerClosed) && ctx.Err() != nil {
+		log.Info().Msg("webhook server stopped")
+
+		return nil
+	}
+
+	return xerrors.Errorf("webhook server stopped: %w", err)
+}
+
+// setWebhook is done with raw request, because tgbotapi.WebhookConfig doesn't know about secret_token.
+func (b *MyBot) setWebhook(u *url.URL) error {
+	params := tgbotapi.Params{"url": u.String()}
+	params.AddNonEmpty("secret_token", b.cfg.WebhookSecret)
+
+	if b.cfg.WebhookSecret == "" {
+		log.Warn().Msg("webhook secret is not set, anyone who knows webhook url can send updates")
+	}
+
+	var err error
+	if b.cfg.WebhookCert != "" {
+		_, err = b.UploadFiles("setWebhook", params, []tgbotapi.RequestFile{{
+			Name: "certificate",
+			Data: tgbotapi.FilePath(b.cfg.WebhookCert),
+		}})
+	} else {
+		_, err = b.MakeRequest("setWebhook", params)
+	}
+
+	if err != nil {
+		return xerrors.Errorf("requesting setWebhook: %w", err)
+	}
+
+	info, err := b.GetWebhookInfo()
+	log.Info().Err(err).Interface("info", info).Msg("webhook set")
+
+	return nil
+}
+
+func (b *MyBot) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if b.cfg.WebhookSecret != "" {
+		token := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(b.cfg.WebhookSecret)) != 1 {
+			log.Warn().Str("remote", r.RemoteAddr).Msg("webhook request with wrong secret token")
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+	}
+
+	update, err := b.HandleUpdate(r)
+	if err != nil {
+		log.Warn().Err(err).Str("remote", r.RemoteAddr).Msg("parsing webhook update")
+		http.Error(w, "bad request", http.StatusBadRequest)
+
+		return
+	}
+
+	// telegram sends the update again later, if it's not accepted
+	if !b.processUpdate(*update) {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -14,20 +14,59 @@ var (
 )
 
 type Values struct {
-	BotToken          string        `long:"bot-token" env:"BOT_TOKEN"`
-	LogLevel          string        `long:"log-level" default:"info" env:"LOG_LEVEL"`
-	Debug             bool          `long:"debug" env:"DEBUG"`
-	JSON              bool          `long:"json" env:"JSON" description:"write logs in json format"`
-	DataReloadTimeout time.Duration `long:"data-reload-timeout" default:"30s" env:"DATA_RELOAD_TIMEOUT" description:"time between data reloads from google table"`
-	PostgresURL       string        `long:"postgres-url" env:"POSTGRES_URL"`
-	SpreadsheetID     string        `long:"spreadsheet-id" env:"SPREADSHEET_ID"`
+	BotToken              string            `long:"bot-token" env:"BOT_TOKEN"`
+	TelegramAPIEndpoint   string            `long:"telegram-api-endpoint" default:"https://api.telegram.org/bot%s/%s" env:"TELEGRAM_API_ENDPOINT" description:"Bot API endpoint, token and method name are put into it, e.g. for a local Bot API server"`
+	LogLevel              string            `long:"log-level" default:"info" env:"LOG_LEVEL"`
+	Debug                 bool              `long:"debug" env:"DEBUG"`
+	JSON                  bool              `long:"json" env:"JSON" description:"write logs in json format"`
+	DataReloadTimeout     time.Duration     `long:"data-reload-timeout" default:"30s" env:"DATA_RELOAD_TIMEOUT" description:"time between data reloads from sync source"`
+	Storage               string            `long:"storage" default:"postgres" choice:"postgres" choice:"sqlite" choice:"memory" env:"STORAGE" description:"where to keep addresses"`
+	PostgresURL           string            `long:"postgres-url" env:"POSTGRES_URL"`
+	SQLitePath            string            `long:"sqlite-path" default:"post_bot.db" env:"SQLITE_PATH"`
+	SyncSource            string            `long:"sync-source" default:"google" choice:"google" choice:"file" choice:"none" env:"SYNC_SOURCE" description:"table addresses are loaded from and mirrored to"`
+	SpreadsheetID         string            `long:"spreadsheet-id" env:"SPREADSHEET_ID"`
+	GoogleAuth            string            `long:"google-auth" default:"oauth" choice:"oauth" choice:"service-account" choice:"default" choice:"none" env:"GOOGLE_AUTH" description:"how to authorize in Google Sheets"`
+	GoogleCredentials     string            `long:"google-credentials" default:"credentials.json" env:"GOOGLE_CREDENTIALS" description:"OAuth client secret or service account key file"`
+	GoogleCredentialsJSON string            `long:"google-credentials-json" env:"GOOGLE_CREDENTIALS_JSON" description:"content of credentials file, used instead of the file if set"`
+	GoogleToken           string            `long:"google-token" default:"token.json" env:"GOOGLE_TOKEN" description:"OAuth token file, created by auth command"`
+	SheetsEndpoint        string            `long:"sheets-endpoint" env:"SHEETS_ENDPOINT" description:"Google Sheets API URL, e.g. a local stand-in, use with google-auth=none"`
+	SyncFile              string            `long:"sync-file" default:"addresses.csv" env:"SYNC_FILE" description:"CSV or XLSX file for file sync source"`
+	SyncPolicy            string            `long:"sync-policy" default:"db-wins-user-fields" choice:"db-wins-user-fields" choice:"last-writer-wins" env:"SYNC_POLICY" description:"how to resolve an address changed both in DB and in the table"`
+	SyncFlushInterval     time.Duration     `long:"sync-flush-interval" default:"5s" env:"SYNC_FLUSH_INTERVAL" description:"how often queued address changes are written to the spreadsheet"`
+	SyncColumns           map[string]string `long:"sync-column" env:"SYNC_COLUMNS" env-delim:";" description:"header names for an address field, e.g. telegram:Ник,Telegram"`
+	StateStorage          string            `long:"state-storage" default:"db" choice:"db" choice:"memory" env:"STATE_STORAGE" description:"where to keep chat conversation state, db means the same database addresses are kept in"`
+	StateTTL              time.Duration     `long:"state-ttl" default:"1h" env:"STATE_TTL" description:"time after which an unanswered command is forgotten"`
+	UpdatesMode           string            `long:"updates-mode" default:"polling" choice:"polling" choice:"webhook" env:"UPDATES_MODE" description:"how to receive updates from telegram"`
+	WebhookListen         string            `long:"webhook-listen" default:":8443" env:"WEBHOOK_LISTEN" description:"address webhook HTTP server listens on"`
+	WebhookURL            string            `long:"webhook-url" env:"WEBHOOK_URL" description:"public URL telegram sends updates to"`
+	WebhookSecret         string            `long:"webhook-secret" env:"WEBHOOK_SECRET" description:"secret token telegram puts into X-Telegram-Bot-Api-Secret-Token header"`
+	WebhookCert           string            `long:"webhook-cert" env:"WEBHOOK_CERT" description:"TLS certificate path, it's also uploaded to telegram"`
+	WebhookKey            string            `long:"webhook-key" env:"WEBHOOK_KEY" description:"TLS private key path"`
+	MaxConcurrentUpdates  int               `long:"max-concurrent-updates" default:"32" env:"MAX_CONCURRENT_UPDATES" description:"how many updates can be handled at the same time"`
+	ShutdownTimeout       time.Duration     `long:"shutdown-timeout" default:"30s" env:"SHUTDOWN_TIMEOUT" description:"time to finish handling updates on shutdown"`
+	SkipMigrations        bool              `long:"skip-migrations" env:"SKIP_MIGRATIONS" description:"don't apply DB migrations at start"`
+	Owners                []int64           `long:"owner" env:"OWNERS" env-delim:"," description:"telegram IDs of users who can do everything, their role can't be changed via bot"`
+	Moderators            []int64           `long:"moderator" env:"MODERATORS" env-delim:"," description:"telegram IDs of users who approve participants, unless their role is changed via bot"`
+	ModeratorsChat        int64             `long:"moderators-chat" env:"MODERATORS_CHAT" description:"chat new participants are announced in, by default moderators are told privately"`
+	DailyQuota            int               `long:"daily-quota" default:"5" env:"DAILY_QUOTA" description:"how many addresses a participant can get in 24 hours, 0 means no limit"`
+	PostcardExpiry        int               `long:"postcard-expiry" default:"60" env:"POSTCARD_EXPIRY" description:"days after which a postcard which isn't received is considered lost"`
+	ConsentTTL            time.Duration     `long:"consent-ttl" default:"72h" env:"CONSENT_TTL" description:"time participants who want to be asked have to allow giving their address"`
+	DrawStrategy          string            `long:"draw-strategy" default:"least-received" choice:"uniform" choice:"least-received" choice:"round-robin" env:"DRAW_STRATEGY" description:"how random addresses are drawn: uniform, preferring those who got fewer postcards lately, or in turn"`
+	EncryptionKeys        []string          `long:"encryption-key" env:"ENCRYPTION_KEYS" env-delim:"," description:"keys personal data is encrypted with in DB as id:base64 of 32 bytes, the first one encrypts, the others only decrypt what was encrypted before rotation"`
+	BlindIndexKey         string            `long:"blind-index-key" env:"BLIND_INDEX_KEY" description:"base64 key of hashes phones and emails are searched by when encrypted, it's not rotated"`
+
+	// Args are positional arguments left after flags, e.g. a subcommand.
+	Args []string `no-flag:"true"`
 }
 
 func Get() Values {
 	parse.Do(func() {
-		if _, err := flags.Parse(&cfg); err != nil {
+		args, err := flags.Parse(&cfg)
+		if err != nil {
 			panic(xerrors.Errorf("parsing Config: %w", err))
 		}
+
+		cfg.Args = args
 	})
 
 	return cfg
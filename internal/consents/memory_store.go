@@ -0,0 +1,131 @@
+package consents
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+type memoryStore struct {
+	// consents are ordered by ID
+	consents []*model.Consent
+	sync.Mutex
+}
+
+// NewMemoryStore returns process-local ConsentStore, requests are lost on restart.
+func NewMemoryStore() ConsentStore {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Create(_ context.Context, c *model.Consent) error {
+	m.Lock()
+	defer m.Unlock()
+
+	c.ID = int64(len(m.consents) + 1)
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+
+	cc := *c
+	m.consents = append(m.consents, &cc)
+
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, id int64) (*model.Consent, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if id < 1 || id > int64(len(m.consents)) {
+		return nil, nil
+	}
+
+	c := *m.consents[id-1]
+
+	return &c, nil
+}
+
+func (m *memoryStore) Pending(_ context.Context, requesterID int64, recipient string) (*model.Consent, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, c := range m.consents {
+		if c.RequesterID == requesterID && c.Recipient == recipient && c.Status == model.ConsentPending {
+			cc := *c
+
+			return &cc, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *memoryStore) Involving(_ context.Context, requesterID int64, recipient string) ([]*model.Consent, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var cc []*model.Consent
+
+	for _, c := range m.consents {
+		if c.RequesterID == requesterID || c.Recipient == recipient {
+			copied := *c
+			cc = append(cc, &copied)
+		}
+	}
+
+	return cc, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, c *model.Consent) error {
+	m.Lock()
+	defer m.Unlock()
+
+	c.UpdatedAt = time.Now()
+	cc := *c
+	m.consents[c.ID-1] = &cc
+
+	return nil
+}
+
+func (m *memoryStore) Expire(_ context.Context, before time.Time) ([]*model.Consent, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var expired []*model.Consent
+
+	for _, c := range m.consents {
+		if c.Status == model.ConsentPending && c.CreatedAt.Before(before) {
+			c.Status = model.ConsentExpired
+			c.UpdatedAt = time.Now()
+
+			cc := *c
+			expired = append(expired, &cc)
+		}
+	}
+
+	return expired, nil
+}
+
+func (m *memoryStore) Forget(_ context.Context, requesterID int64, recipient string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, c := range m.consents {
+		if c.RequesterID == requesterID {
+			c.Requester = model.ErasedTelegram
+			c.UpdatedAt = time.Now()
+		}
+
+		if c.Recipient == recipient {
+			if c.Status == model.ConsentPending {
+				c.Status = model.ConsentDenied
+			}
+
+			c.Recipient = model.ErasedTelegram
+			c.UpdatedAt = time.Now()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package consents
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore returns ConsentStore backed by the `consents` table.
+func NewPostgresStore(db *gorm.DB) ConsentStore {
+	return &postgresStore{db: db}
+}
+
+func (p *postgresStore) Create(ctx context.Context, c *model.Consent) error {
+	if err := p.db.WithContext(ctx).Create(c).Error; err != nil {
+		return xerrors.Errorf("creating consent request (requester_id=%d, recipient=%q): %w",
+			c.RequesterID, c.Recipient, err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Get(ctx context.Context, id int64) (*model.Consent, error) {
+	return p.first(ctx, "id = ?", id)
+}
+
+func (p *postgresStore) Pending(ctx context.Context, requesterID int64, recipient string) (*model.Consent, error) {
+	return p.first(ctx, "requester_id = ? AND recipient = ? AND status = ?", requesterID, recipient,
+		model.ConsentPending)
+}
+
+func (p *postgresStore) first(ctx context.Context, query string, args ...interface{}) (*model.Consent, error) {
+	cc := []*model.Consent{}
+	if err := p.db.WithContext(ctx).Where(query, args...).Order("id").Limit(1).Find(&cc).Error; err != nil {
+		return nil, xerrors.Errorf("searching consent request: %w", err)
+	}
+
+	if len(cc) == 0 {
+		return nil, nil
+	}
+
+	return cc[0], nil
+}
+
+func (p *postgresStore) Involving(ctx context.Context, requesterID int64, recipient string) ([]*model.Consent, error) {
+	cc := []*model.Consent{}
+
+	err := p.db.WithContext(ctx).Where("requester_id = ? OR recipient = ?", requesterID, recipient).Order("id").Find(&cc).Error
+	if err != nil {
+		return nil, xerrors.Errorf("listing consent requests (requester_id=%d, recipient=%q): %w", requesterID, recipient, err)
+	}
+
+	return cc, nil
+}
+
+func (p *postgresStore) Save(ctx context.Context, c *model.Consent) error {
+	if err := p.db.WithContext(ctx).Save(c).Error; err != nil {
+		return xerrors.Errorf("saving consent request %d: %w", c.ID, err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Expire(ctx context.Context, before time.Time) ([]*model.Consent, error) {
+	cc := []*model.Consent{}
+
+	err := p.db.WithContext(ctx).Model(&cc).Clauses(clause.Returning{}).
+		Where("status = ? AND created_at < ?", model.ConsentPending, before).
+		Updates(map[string]interface{}{"status": model.ConsentExpired, "updated_at": time.Now()}).Error
+	if err != nil {
+		return nil, xerrors.Errorf("expiring consent requests: %w", err)
+	}
+
+	return cc, nil
+}
+
+func (p *postgresStore) Forget(ctx context.Context, requesterID int64, recipient string) error {
+	db := dbtx.DB(ctx, p.db)
+	now := time.Now()
+
+	err := db.Model(&model.Consent{}).Where("requester_id = ?", requesterID).
+		Updates(map[string]interface{}{"requester": model.ErasedTelegram, "updated_at": now}).Error
+	if err != nil {
+		return xerrors.Errorf("forgetting requester (requester_id=%d): %w", requesterID, err)
+	}
+
+	err = db.Model(&model.Consent{}).Where("recipient = ? AND status = ?", recipient, model.ConsentPending).
+		Updates(map[string]interface{}{"status": model.ConsentDenied, "updated_at": now}).Error
+	if err != nil {
+		return xerrors.Errorf("denying requests to %q: %w", recipient, err)
+	}
+
+	err = db.Model(&model.Consent{}).Where("recipient = ?", recipient).
+		Updates(map[string]interface{}{"recipient": model.ErasedTelegram, "updated_at": now}).Error
+	if err != nil {
+		return xerrors.Errorf("forgetting recipient (recipient=%q): %w", recipient, err)
+	}
+
+	return nil
+}
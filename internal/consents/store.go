@@ -0,0 +1,26 @@
+// Package consents keeps requests for addresses of participants who want to be asked first.
+package consents
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// ConsentStore keeps consent requests by ID.
+type ConsentStore interface {
+	Create(ctx context.Context, c *model.Consent) error
+	// Get returns the request by ID, nil if there is no such request.
+	Get(ctx context.Context, id int64) (*model.Consent, error)
+	// Pending returns the request from the requester to the recipient waiting for the answer, nil if there is none.
+	Pending(ctx context.Context, requesterID int64, recipient string) (*model.Consent, error)
+	// Involving returns requests from the requester or to the recipient, the oldest first.
+	Involving(ctx context.Context, requesterID int64, recipient string) ([]*model.Consent, error)
+	Save(ctx context.Context, c *model.Consent) error
+	// Expire marks requests created before the time and not answered yet as expired and returns them.
+	Expire(ctx context.Context, before time.Time) ([]*model.Consent, error)
+	// Forget replaces the nick of the participant with model.ErasedTelegram in requests from the requester's chat
+	// and to the recipient, requests to the recipient which aren't answered yet are denied.
+	Forget(ctx context.Context, requesterID int64, recipient string) error
+}
@@ -0,0 +1,45 @@
+// Package dbtest opens SQLite databases with all migrations applied, so stores are tested against the real schema.
+package dbtest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/migrate"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open returns a migrated database in a temporary directory, it's closed when the test ends.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("getting sql.DB: %v", err)
+	}
+
+	// sqlite doesn't like concurrent writers
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	m, err := migrate.New(db)
+	if err != nil {
+		t.Fatalf("creating migrator: %v", err)
+	}
+
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("applying migrations: %v", err)
+	}
+
+	return db
+}
@@ -0,0 +1,36 @@
+// Package dbtx runs changes of several stores in one transaction, if the stores share the database.
+package dbtx
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Func runs f in a transaction, stores which get their DB by DB from the context f is given join it.
+type Func func(ctx context.Context, f func(ctx context.Context) error) error
+
+type txKey struct{}
+
+// None runs f as is, it's for stores which don't share a database.
+func None(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+// Over returns Func running transactions of the database.
+func Over(db *gorm.DB) Func {
+	return func(ctx context.Context, f func(ctx context.Context) error) error {
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return f(context.WithValue(ctx, txKey{}, tx))
+		})
+	}
+}
+
+// DB returns the transaction of ctx if there is one, otherwise db, either is bound to ctx.
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+
+	return db.WithContext(ctx)
+}
@@ -0,0 +1,74 @@
+package dbtx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/dbtest"
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/requests"
+	"github.com/grbit/post_bot/internal/users"
+)
+
+func TestOver(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	for _, tt := range []struct {
+		name string
+		// fail makes the transaction fail after both stores are changed
+		fail bool
+		want string
+	}{
+		{"committed", false, model.ErasedTelegram},
+		{"rolled back", true, "alice"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := dbtest.Open(t)
+			uu, rs := users.NewPostgresStore(db), requests.NewPostgresStore(db)
+
+			if err := uu.Save(ctx, &model.User{ChatID: 1, Telegram: "alice"}); err != nil {
+				t.Fatalf("saving user: %v", err)
+			}
+
+			if err := rs.Add(ctx, &model.AddressRequest{RequesterID: 2, Recipient: "alice"}); err != nil {
+				t.Fatalf("adding request: %v", err)
+			}
+
+			err := dbtx.Over(db)(ctx, func(ctx context.Context) error {
+				if err := uu.Forget(ctx, 1); err != nil {
+					return err
+				}
+
+				if err := rs.Forget(ctx, "alice"); err != nil {
+					return err
+				}
+
+				if tt.fail {
+					return errFailed
+				}
+
+				return nil
+			})
+			if tt.fail != errors.Is(err, errFailed) || !tt.fail && err != nil {
+				t.Fatalf("transaction error is %v", err)
+			}
+
+			u, err := uu.Get(ctx, 1)
+			if err != nil {
+				t.Fatalf("getting user: %v", err)
+			}
+
+			tgs, err := rs.Recipients(ctx, 2)
+			if err != nil {
+				t.Fatalf("getting recipients: %v", err)
+			}
+
+			if u.Telegram != tt.want || len(tgs) != 1 || tgs[0] != tt.want {
+				t.Fatalf("user is %q, recipients are %v, want %q", u.Telegram, tgs, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/fsm"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/tgfake"
+
+	"github.com/rs/zerolog"
+)
+
+// testTimeout is how long a whole test may run
+const testTimeout = 30 * time.Second
+
+func TestMain(m *testing.M) {
+	// every update is logged at debug level, it buries the failures
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	os.Exit(m.Run())
+}
+
+func start(t *testing.T, opts ...Option) (context.Context, *Harness) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+
+	h, err := Start(ctx, opts...)
+	if err != nil {
+		t.Fatalf("starting harness: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := h.Close(); err != nil {
+			t.Errorf("closing harness: %v", err)
+		}
+	})
+
+	return ctx, h
+}
+
+// approved saves approved addresses as if they were registered and checked by moderators.
+func approved(ctx context.Context, t *testing.T, h *Harness, aa ...*model.Address) {
+	t.Helper()
+
+	for _, a := range aa {
+		a.Approved = true
+		if err := h.Addresses.Upsert(ctx, a); err != nil {
+			t.Fatalf("saving address of %q: %v", a.Telegram, err)
+		}
+	}
+}
+
+func TestAddAddress(t *testing.T) {
+	ctx, h := start(t)
+
+	err := h.As("alice").
+		Send("/add_address").
+		Expect("В какой стране").
+		Send("Нарния").
+		Expect("Не знаю такую страну").
+		Send("Германия").
+		Expect("индекс").
+		Send("123").
+		Expect("Это не похоже на индекс").
+		Send("10115").
+		Expect("Город").
+		Send("Berlin").
+		Expect("Область").
+		Send(fsm.SkipButton).
+		Expect("Улица").
+		Send("Invalidenstr.  117").
+		Expect("Кому писать на конверте").
+		Send(fsm.SkipButton).
+		Expect("Адрес добавлен!").
+		ExpectAddress(func(a *model.Address) error {
+			if a.ID == 0 || a.Country != "DE" || a.PostalCode != "10115" || a.City != "Berlin" ||
+				a.Street != "Invalidenstr. 117" || a.Address != "Invalidenstr. 117, 10115 Berlin, GERMANY" {
+				return fmt.Errorf("address is %+v", a)
+			}
+
+			return nil
+		}).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddAddressInOneLine(t *testing.T) {
+	ctx, h := start(t)
+
+	err := h.As("alice").
+		Send("/add_address").
+		Expect("В какой стране").
+		Press("Одной строкой").
+		Expect("→ Одной строкой").
+		Expect("Напиши адрес полностью").
+		Send("short").
+		Expect("какой-то он короткий").
+		Send("Moscow,  Red square 1").
+		Expect("Адрес добавлен!").
+		ExpectAddress(func(a *model.Address) error {
+			if a.Address != "Moscow, Red square 1" || a.Country != "" || a.Structured() {
+				return fmt.Errorf("address is %+v", a)
+			}
+
+			return nil
+		}).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGiveMeSome(t *testing.T) {
+	ctx, h := start(t)
+
+	approved(ctx, t, h,
+		&model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red square 1"},
+		&model.Address{Telegram: "bob", PersonName: "Bob", Address: "Berlin, Unter den Linden 1"},
+	)
+
+	err := h.As("alice").
+		Send("/give_me_some").
+		Expect("Отлично!").
+		Send("ok").
+		Expect("Bob. Адрес: Berlin, Unter den Linden 1.").
+		Send("/give_me_some ok").
+		Expect("нет новых адресов для тебя").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tgs, err := h.Bot.Requests.Recipients(ctx, h.User("alice").ID)
+	if err != nil {
+		t.Fatalf("getting recipients: %v", err)
+	}
+
+	if len(tgs) != 1 || tgs[0] != "bob" {
+		t.Fatalf("recipients of alice are %v, want [bob]", tgs)
+	}
+}
+
+func TestGiveMeSomeNeedsApprovedAddress(t *testing.T) {
+	ctx, h := start(t)
+
+	err := h.As("carol").
+		Send("/give_me_some").
+		Expect("Ты не добавил адрес").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelMidFlow(t *testing.T) {
+	ctx, h := start(t)
+
+	err := h.As("alice").
+		Send("/register").
+		Expect("Как тебя зовут?").
+		Send("Alice Liddell").
+		Expect("В какой стране").
+		Send("/cancel").
+		Expect(fsm.Canceled).
+		Send("Moscow, Red square 1").
+		Expect("Не понимаю").
+		ExpectAddress(func(a *model.Address) error {
+			if a.ID != 0 {
+				return fmt.Errorf("canceled registration is saved: %+v", a)
+			}
+
+			return nil
+		}).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := h.Bot.States.Get(ctx, h.User("alice").ID)
+	if err != nil {
+		t.Fatalf("getting state: %v", err)
+	}
+
+	if st.PreviousCmd != "" || st.Step != "" || len(st.FlowData) != 0 {
+		t.Fatalf("state isn't reset: %+v", st)
+	}
+}
+
+func TestBackInFlow(t *testing.T) {
+	ctx, h := start(t)
+
+	err := h.As("alice").
+		Send("/register").
+		Expect("Как тебя зовут?").
+		Send("Alice").
+		Expect("В какой стране").
+		Send("/back").
+		Expect("Сейчас: Alice").
+		Send("Alice Liddell").
+		Expect("В какой стране").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchOwnAddressWhateverCase(t *testing.T) {
+	ctx, h := start(t)
+
+	approved(ctx, t, h, &model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red square 1"})
+
+	// Telegram gives the nick as it was typed, addresses are kept in lower case
+	err := h.As("Alice").
+		Send("/give_me_some alice").
+		Expect("Moscow, Red square 1").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tgs, err := h.Bot.Requests.Recipients(ctx, h.User("Alice").ID)
+	if err != nil {
+		t.Fatalf("getting recipients: %v", err)
+	}
+
+	if len(tgs) != 0 {
+		t.Fatalf("own address is recorded as given: %v", tgs)
+	}
+}
+
+func TestSearchRevealsOwnHiddenAddress(t *testing.T) {
+	ctx, h := start(t)
+
+	approved(ctx, t, h, &model.Address{
+		Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red square 1", Privacy: model.PrivacyAsk,
+	})
+
+	err := h.As("Alice").
+		Send("/give_me_some alice").
+		Expect("Moscow, Red square 1").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddAddressIsAnnounced(t *testing.T) {
+	// the first user gets ID 1001
+	ctx, h := start(t, WithConfig(func(cfg *config.Values) { cfg.Moderators = []int64{1001} }))
+
+	mod := h.As("mod").
+		Expect("Новая анкета на проверку").
+		Send("/pending").
+		Expect("Анкета @alice")
+
+	err := h.As("alice").
+		Send("/add_address").
+		Expect("В какой стране").
+		Press("Одной строкой").
+		Expect("→ Одной строкой").
+		Expect("Напиши адрес полностью").
+		Send("Moscow, Red square 1").
+		Expect("модераторы").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mod.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// editing the address doesn't announce it again
+	err = h.As("alice").
+		Send("/add_address").
+		Expect("В какой стране").
+		Press("Одной строкой").
+		Expect("→ Одной строкой").
+		Expect("Напиши адрес полностью").
+		Send("Moscow, Red square 2").
+		Expect("Адрес добавлен!").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(h.TG.Sent(h.User("mod").ID)); n != 2 {
+		t.Fatalf("moderator got %d messages, want 2", n)
+	}
+}
+
+func TestSearchByNameHidesNick(t *testing.T) {
+	ctx, h := start(t)
+
+	approved(ctx, t, h,
+		&model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red square 1"},
+		&model.Address{Telegram: "bob", PersonName: "Bob Marley", Address: "Kingston 1", Privacy: model.PrivacyAsk},
+	)
+
+	err := h.As("alice").
+		Send("/give_me_some Marley").
+		ExpectSent(func(m tgfake.Sent) error {
+			if !strings.Contains(m.Text, "по поиску его не выдают") || strings.Contains(m.Text, "bob") ||
+				strings.Contains(m.ReplyMarkup, "Спросить") {
+				return fmt.Errorf("bot has sent %q with %s", m.Text, m.ReplyMarkup)
+			}
+
+			return nil
+		}).
+		Send("/give_me_some @Bob").
+		Expect("@bob просит сначала спросить разрешения").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsentChecksQuota(t *testing.T) {
+	ctx, h := start(t, WithConfig(func(cfg *config.Values) { cfg.DailyQuota = 1 }))
+
+	approved(ctx, t, h,
+		&model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red square 1"},
+		&model.Address{Telegram: "carol", PersonName: "Carol", Address: "Paris 1"},
+		&model.Address{
+			Telegram: "bob", PersonName: "Bob", Address: "Kingston 1", Privacy: model.PrivacyAsk,
+			ChatID: h.User("bob").ID,
+		},
+	)
+
+	bob := h.As("bob").
+		Expect("хочет отправить тебе открытку").
+		Press("Дать адрес").
+		Expect("→ Разрешено")
+
+	// alice gets her last address for today while bob is deciding
+	err := h.As("alice").
+		Send("/give_me_some @bob").
+		Expect("просит сначала спросить").
+		Press("Спросить @bob").
+		ExpectAnswer("Спросил @bob").
+		Send("/give_me_some @carol").
+		Expect("Paris 1").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := h.As("alice").Expect("@bob разрешил, но сейчас я не могу дать тебе адрес. На сегодня хватит")
+
+	if err := bob.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alice.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tgs, err := h.Bot.Requests.Recipients(ctx, h.User("alice").ID)
+	if err != nil {
+		t.Fatalf("getting recipients: %v", err)
+	}
+
+	if len(tgs) != 1 || tgs[0] != "carol" {
+		t.Fatalf("recipients of alice are %v, want [carol]", tgs)
+	}
+}
+
+func TestDeleteMeForgetsNick(t *testing.T) {
+	ctx, h := start(t)
+
+	approved(ctx, t, h,
+		&model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red square 1"},
+		&model.Address{Telegram: "bob", PersonName: "Bob", Address: "Berlin, Unter den Linden 1"},
+	)
+
+	err := h.As("bob").
+		Send("/give_me_some @alice").
+		Expect("Moscow, Red square 1").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.As("alice").
+		Send("/give_me_some @bob").
+		Expect("Berlin, Unter den Linden 1").
+		Send("/delete_me").
+		Expect("Точно удалить").
+		Press("Удалить").
+		Expect("→ Удалить").
+		Expect("Остались номер этого чата").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, bob := h.User("alice").ID, h.User("bob").ID
+
+	u, err := h.Bot.Users.Get(ctx, alice)
+	if err != nil {
+		t.Fatalf("getting user: %v", err)
+	}
+
+	if u.Telegram != model.ErasedTelegram {
+		t.Fatalf("user is %+v", u)
+	}
+
+	tgs, err := h.Bot.Requests.Recipients(ctx, bob)
+	if err != nil {
+		t.Fatalf("getting recipients: %v", err)
+	}
+
+	if len(tgs) != 1 || tgs[0] != model.ErasedTelegram {
+		t.Fatalf("recipients of bob are %v", tgs)
+	}
+
+	for _, chatID := range []int64{alice, bob} {
+		pp, err := h.Bot.Postcards.Involving(ctx, chatID, "")
+		if err != nil {
+			t.Fatalf("getting postcards: %v", err)
+		}
+
+		if len(pp) != 1 {
+			t.Fatalf("postcards of %d are %+v", chatID, pp)
+		}
+
+		for _, p := range pp {
+			if p.Sender == "alice" || p.Recipient == "alice" {
+				t.Fatalf("postcard %s keeps the nick: %+v", p.Code, p)
+			}
+		}
+	}
+}
+
+func TestSavedFilterSkipsNickLookup(t *testing.T) {
+	ctx, h := start(t)
+
+	approved(ctx, t, h,
+		&model.Address{Telegram: "alice", PersonName: "Alice", Country: "RU", Address: "Moscow, Red square 1"},
+		&model.Address{Telegram: "bob", PersonName: "Bob", Country: "DE", Address: "Berlin, Unter den Linden 1"},
+		&model.Address{Telegram: "carol", PersonName: "Carol King", Country: "RU", Address: "Kazan, Bauman st 1"},
+	)
+
+	err := h.As("alice").
+		Send("/filter country:DE").
+		Expect("Сохранил! Адреса выдаются только по фильтру country:DE.").
+		Send("/give_me_some King").
+		Expect("Я ничего не нашёл =(").
+		Send("/give_me_some @carol").
+		Expect("Я нашёл!\nCarol King. Адрес: Kazan, Bauman st 1.").
+		Send("/give_me_some ok").
+		Expect("Bob. Адрес: Berlin, Unter den Linden 1.").
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
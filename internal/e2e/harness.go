@@ -0,0 +1,182 @@
+// Package e2e runs the bot against fake Telegram Bot API with in-memory storage and describes
+// conversations as scenarios:
+//
+//	h, err := e2e.Start(ctx)
+//	...
+//	defer h.Close()
+//
+//	err = h.As("alice").
+//		Send("/add_address").
+//		Expect("В какой стране").
+//		Send("Одной строкой").
+//		Expect("Напиши адрес полностью").
+//		Send("Moscow, Red square 1").
+//		Expect("Адрес добавлен!").
+//		ExpectAddress(func(a *model.Address) error {
+//			if a.Address != "Moscow, Red square 1" {
+//				return fmt.Errorf("address is %q", a.Address)
+//			}
+//			return nil
+//		}).
+//		Run(ctx)
+package e2e
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/bot"
+	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/consents"
+	"github.com/grbit/post_bot/internal/postcards"
+	"github.com/grbit/post_bot/internal/repo"
+	"github.com/grbit/post_bot/internal/requests"
+	"github.com/grbit/post_bot/internal/state"
+	"github.com/grbit/post_bot/internal/tgfake"
+	"github.com/grbit/post_bot/internal/users"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	botToken = "42:fake-token"
+	// shutdownTimeout is how long Close waits for handlers
+	shutdownTimeout = 5 * time.Second
+)
+
+// Harness is a running bot talking to fake Telegram.
+type Harness struct {
+	TG        *tgfake.Server
+	Addresses db.AddressStore
+	Bot       *bot.MyBot
+
+	// users are fake telegram users by username, so the same user gets the same chat
+	users  map[string]tgfake.User
+	lastID int64
+	sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Option changes the harness before the bot is started.
+type Option func(cfg *config.Values, source *db.SyncSource)
+
+// WithConfig changes bot config, fake API endpoint and token are set anyway.
+func WithConfig(f func(cfg *config.Values)) Option {
+	return func(cfg *config.Values, _ *db.SyncSource) { f(cfg) }
+}
+
+// WithSyncSource mirrors addresses to the source, there is no sync source by default.
+func WithSyncSource(s db.SyncSource) Option {
+	return func(_ *config.Values, source *db.SyncSource) { *source = s }
+}
+
+// Start runs the bot in polling mode, it must be stopped with Close.
+func Start(ctx context.Context, opts ...Option) (*Harness, error) {
+	cfg := config.Values{
+		UpdatesMode:          "polling",
+		MaxConcurrentUpdates: 8,
+		StateTTL:             time.Hour,
+		DataReloadTimeout:    time.Minute,
+		SyncPolicy:           db.PolicyDBWinsUserFields,
+	}
+
+	source := db.NoSync()
+
+	for _, o := range opts {
+		o(&cfg, &source)
+	}
+
+	tg := tgfake.NewServer(botToken)
+	cfg.BotToken = botToken
+	cfg.TelegramAPIEndpoint = tg.Endpoint()
+
+	repo, err := db.InitDataUpdater(ctx, db.NewMemoryStore(), source, cfg.SyncPolicy)
+	if err != nil {
+		tg.Close()
+
+		return nil, xerrors.Errorf("initializing data updater: %w", err)
+	}
+
+	b, err := bot.New(cfg, state.NewMemoryStore(cfg.StateTTL), users.NewMemoryStore(), requests.NewMemoryStore(), postcards.NewMemoryStore(),
+		consents.NewMemoryStore(), repo)
+	if err != nil {
+		tg.Close()
+
+		return nil, xerrors.Errorf("creating bot: %w", err)
+	}
+
+	repo.OnApproved(b.NotifyApproved)
+	repo.OnCreated(b.Announce)
+
+	runCtx, cancel := context.WithCancel(context.Background())
+
+	h := &Harness{
+		TG:        tg,
+		Addresses: repo,
+		Bot:       b,
+		users:     make(map[string]tgfake.User),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		repo.RunDataUpdater(runCtx, cfg.DataReloadTimeout)
+	}()
+
+	go func() {
+		defer wg.Done()
+		_ = b.StartBot(runCtx)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(h.done)
+	}()
+
+	return h, nil
+}
+
+// Close stops the bot, waits for handlers and stops fake telegram.
+func (h *Harness) Close() error {
+	h.cancel()
+	<-h.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := h.Bot.Shutdown(ctx)
+
+	h.TG.Close()
+
+	return err
+}
+
+// User returns fake telegram user with the username, creating it on the first call.
+func (h *Harness) User(username string) tgfake.User {
+	h.Lock()
+	defer h.Unlock()
+
+	u, ok := h.users[username]
+	if !ok {
+		h.lastID++
+		u = tgfake.User{ID: 1000 + h.lastID, UserName: username}
+		h.users[username] = u
+	}
+
+	return u
+}
+
+// As starts a scenario of the user talking to the bot.
+func (h *Harness) As(username string) *Scenario {
+	u := h.User(username)
+
+	return &Scenario{h: h, user: u, from: len(h.TG.Sent(u.ID))}
+}
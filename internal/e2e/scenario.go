@@ -0,0 +1,207 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/tgfake"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/xerrors"
+)
+
+// stepTimeout is how long a step waits for the bot
+const stepTimeout = 5 * time.Second
+
+const methodAnswer = "answerCallbackQuery"
+
+// Scenario is a conversation of one user with the bot, steps are run in order by Run.
+// Updates are handled concurrently, so a message should be sent after the reply to the previous one is expected.
+type Scenario struct {
+	h     *Harness
+	user  tgfake.User
+	steps []step
+	// from is how many things the bot had sent to the chat when the scenario was made
+	from int
+}
+
+type step struct {
+	name string
+	run  func(ctx context.Context, r *run) error
+}
+
+// run is the scenario state while it's running.
+type run struct {
+	// seen is number of things the bot sent to the chat which were already expected
+	seen int
+	// last is the last thing the bot sent which was expected
+	last tgfake.Sent
+	// answered is like seen but for answers to callback queries, it's reset by every press,
+	// so the answer to the last pressed button is expected
+	answered int
+}
+
+// Send sends text to the bot, texts starting with "/" are commands.
+func (s *Scenario) Send(text string) *Scenario {
+	return s.add("send "+text, func(_ context.Context, _ *run) error {
+		s.h.TG.SendMessage(s.user, text)
+
+		return nil
+	})
+}
+
+// Press presses inline button with the text under the message expected last.
+func (s *Scenario) Press(button string) *Scenario {
+	return s.add("press "+button, func(_ context.Context, r *run) error {
+		if r.last.MessageID == 0 {
+			return xerrors.Errorf("no message to press a button under, expect it first")
+		}
+
+		var kb tgbotapi.InlineKeyboardMarkup
+		if r.last.ReplyMarkup != "" {
+			if err := json.Unmarshal([]byte(r.last.ReplyMarkup), &kb); err != nil {
+				return xerrors.Errorf("parsing reply markup %q: %w", r.last.ReplyMarkup, err)
+			}
+		}
+
+		for _, row := range kb.InlineKeyboard {
+			for _, b := range row {
+				if b.Text == button && b.CallbackData != nil {
+					r.answered = len(s.h.TG.Sent(s.user.ID))
+					s.h.TG.PressButton(s.user, r.last.MessageID, *b.CallbackData)
+
+					return nil
+				}
+			}
+		}
+
+		return xerrors.Errorf("no button %q under %s %q", button, r.last.Method, r.last.Text)
+	})
+}
+
+// PressData presses inline button with the callback data under the message expected last,
+// e.g. to press a button which is already removed or a forged one.
+func (s *Scenario) PressData(data string) *Scenario {
+	return s.add("press data "+data, func(_ context.Context, r *run) error {
+		if r.last.MessageID == 0 {
+			return xerrors.Errorf("no message to press a button under, expect it first")
+		}
+
+		r.answered = len(s.h.TG.Sent(s.user.ID))
+		s.h.TG.PressButton(s.user, r.last.MessageID, data)
+
+		return nil
+	})
+}
+
+// Expect waits for the next thing the bot sends to the chat and checks it contains the text.
+func (s *Scenario) Expect(text string) *Scenario {
+	return s.add("expect "+text, func(ctx context.Context, r *run) error {
+		m, err := s.next(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(m.Text, text) {
+			return xerrors.Errorf("bot has sent %s %q", m.Method, m.Text)
+		}
+
+		return nil
+	})
+}
+
+// ExpectAnswer waits for the answer to the button pressed last and checks it contains the text.
+// Answers are not waited for by the other steps.
+func (s *Scenario) ExpectAnswer(text string) *Scenario {
+	return s.add("expect answer "+text, func(ctx context.Context, r *run) error {
+		for ; ; r.answered++ {
+			m, err := s.h.TG.WaitSent(ctx, s.user.ID, r.answered)
+			if err != nil {
+				return xerrors.Errorf("waiting for the bot: %w", err)
+			}
+
+			if m.Method != methodAnswer {
+				continue
+			}
+
+			r.answered++
+
+			if !strings.Contains(m.Text, text) {
+				return xerrors.Errorf("bot has answered %q", m.Text)
+			}
+
+			return nil
+		}
+	})
+}
+
+// ExpectSent waits for the next thing the bot sends to the chat and checks it.
+func (s *Scenario) ExpectSent(check func(m tgfake.Sent) error) *Scenario {
+	return s.add("expect sent", func(ctx context.Context, r *run) error {
+		m, err := s.next(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		return check(m)
+	})
+}
+
+// ExpectAddress checks the user's address in the store, it's an empty address with ID 0 if there is none.
+func (s *Scenario) ExpectAddress(check func(a *model.Address) error) *Scenario {
+	return s.add("expect address", func(ctx context.Context, _ *run) error {
+		a, err := s.h.Addresses.Get(ctx, s.user.UserName)
+		if err != nil {
+			return xerrors.Errorf("getting address: %w", err)
+		}
+
+		return check(a)
+	})
+}
+
+// Run runs the steps and returns the first failed one.
+// Things the bot sent to the chat before the scenario was made are not expected,
+// so a scenario made before another user's one expects what the other user has caused.
+func (s *Scenario) Run(ctx context.Context) error {
+	r := &run{seen: s.from, answered: s.from}
+
+	for i, st := range s.steps {
+		stepCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+		err := st.run(stepCtx, r)
+
+		cancel()
+
+		if err != nil {
+			return xerrors.Errorf("step %d (%s) of @%s: %w", i+1, st.name, s.user.UserName, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scenario) add(name string, f func(ctx context.Context, r *run) error) *Scenario {
+	s.steps = append(s.steps, step{name: name, run: f})
+
+	return s
+}
+
+// next returns the next thing the bot sent to the chat skipping answers to callback queries.
+func (s *Scenario) next(ctx context.Context, r *run) (tgfake.Sent, error) {
+	for {
+		m, err := s.h.TG.WaitSent(ctx, s.user.ID, r.seen)
+		if err != nil {
+			return m, xerrors.Errorf("waiting for the bot: %w", err)
+		}
+
+		r.seen++
+
+		if m.Method != methodAnswer {
+			r.last = m
+
+			return m, nil
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package e2e
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/repo"
+	"github.com/grbit/post_bot/internal/sheetsfake"
+)
+
+// SheetsSource returns sync source backed by the fake spreadsheet with default column names.
+func SheetsSource(
+	ctx context.Context,
+	fake *sheetsfake.Server,
+	spreadsheetID string,
+	flushInterval time.Duration,
+) (db.SyncSource, error) {
+	auth := db.GoogleAuth{Mode: db.AuthNone, Endpoint: fake.URL}
+
+	return db.NewGoogleSheetsSource(ctx, spreadsheetID, auth, db.DefaultColumnNames, flushInterval)
+}
@@ -0,0 +1,397 @@
+// Package fsm runs conversations declared as flows of steps.
+// A flow is started by a command, every step asks something, checks the answer and saves it,
+// the conversation position is kept in model.State, so it survives restarts like the rest of the state.
+package fsm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+)
+
+// End is returned by Step.Next to finish the flow.
+const End = "$end"
+
+// SkipButton is the answer which skips an optional step.
+const SkipButton = "Пропустить"
+
+// CancelButton is the answer which cancels the flow, e.g. on a confirmation step.
+const CancelButton = "Отменить"
+
+// Canceled is the reply to canceling a flow.
+const Canceled = "Хорошо, отменили."
+
+// cantSkip is the reply to SkipButton on a step which isn't optional, e.g. pressed on an old keyboard
+const cantSkip = "Этот шаг нельзя пропустить."
+
+// Commands which are understood in any flow.
+const (
+	CmdCancel = "cancel"
+	CmdBack   = "back"
+)
+
+// Flow is a conversation started by a command.
+type Flow struct {
+	// Name is the command which starts the flow, flows started by buttons only are named anyhow
+	Name string
+	// Check is called before the flow starts, if it returns a reply, the flow isn't started
+	Check func(ctx context.Context, c *Conv) (string, error)
+	// Steps are asked starting from the first one
+	Steps []*Step
+	// Done returns the reply sent when the flow is finished
+	Done func(ctx context.Context, c *Conv) (Reply, error)
+}
+
+// Step asks one question.
+type Step struct {
+	Name   string
+	Prompt func(c *Conv) string
+	// Buttons are suggested answers
+	Buttons []string
+	// Optional reports whether the step can be skipped with SkipButton, nil means it can't
+	Optional func(c *Conv) bool
+	// Validate returns an error with a message for the user, the step is asked again then
+	Validate func(text string) error
+	// Set saves the answer, e.g. to Conv data or to DB
+	Set func(ctx context.Context, c *Conv, text string) error
+	// Next returns name of the next step, by default it's the next one in Flow.Steps or End after the last one
+	Next func(c *Conv) string
+}
+
+// Conv is a conversation in a flow, flow data is kept in State.FlowData.
+type Conv struct {
+	*model.State
+}
+
+// Get returns value kept by a previous step.
+func (c *Conv) Get(key string) string {
+	return c.FlowData[key]
+}
+
+// Put keeps value for the next steps.
+func (c *Conv) Put(key, value string) {
+	if c.FlowData == nil {
+		c.FlowData = make(map[string]string)
+	}
+
+	c.FlowData[key] = value
+}
+
+// Reply is what is sent to the user.
+type Reply struct {
+	Text    string
+	Buttons []Button
+}
+
+// Button is shown under the reply. Button without Data is an answer to the step, pressing it is the same
+// as sending its text. Data of other buttons is handled by the bot, e.g. to show the next page.
+type Button struct {
+	Text string
+	Data string
+}
+
+// Text is Flow.Done which replies with the text.
+func Text(text string) func(context.Context, *Conv) (Reply, error) {
+	return func(context.Context, *Conv) (Reply, error) { return Reply{Text: text}, nil }
+}
+
+// Say is Step.Prompt which doesn't depend on the conversation.
+func Say(text string) func(c *Conv) string {
+	return func(*Conv) string { return text }
+}
+
+// Invalid is an answer validation error, its text is sent to the user.
+type Invalid string
+
+func (e Invalid) Error() string { return string(e) }
+
+// Always is Step.Optional for steps which can always be skipped.
+func Always(*Conv) bool { return true }
+
+// NotEmpty is Step.Validate which rejects empty answers, e.g. photos without a caption.
+func NotEmpty(msg string) func(text string) error {
+	return func(text string) error {
+		if text == "" {
+			return Invalid(msg)
+		}
+
+		return nil
+	}
+}
+
+// Machine runs registered flows.
+type Machine struct {
+	flows map[string]*Flow
+	now   func() time.Time
+}
+
+// New checks the flows are declared right.
+func New(flows ...*Flow) (*Machine, error) {
+	m := &Machine{flows: make(map[string]*Flow, len(flows)), now: time.Now}
+
+	for _, f := range flows {
+		if _, dup := m.flows[f.Name]; dup {
+			return nil, xerrors.Errorf("flow %q is declared twice", f.Name)
+		}
+
+		if f.Name == CmdCancel || f.Name == CmdBack {
+			return nil, xerrors.Errorf("flow can't be named %q, it's used in every flow", f.Name)
+		}
+
+		if len(f.Steps) == 0 {
+			return nil, xerrors.Errorf("flow %q has no steps", f.Name)
+		}
+
+		names := make(map[string]bool, len(f.Steps))
+
+		for _, s := range f.Steps {
+			if s.Name == "" || s.Name == End || names[s.Name] {
+				return nil, xerrors.Errorf("flow %q has step with empty, reserved or duplicated name %q", f.Name, s.Name)
+			}
+
+			if s.Prompt == nil {
+				return nil, xerrors.Errorf("step %q of flow %q has no prompt", s.Name, f.Name)
+			}
+
+			names[s.Name] = true
+		}
+
+		m.flows[f.Name] = f
+	}
+
+	return m, nil
+}
+
+// Has reports whether the command starts a flow.
+func (m *Machine) Has(cmd string) bool {
+	_, ok := m.flows[cmd]
+
+	return ok
+}
+
+// Active reports whether the chat is in a flow.
+func (m *Machine) Active(st *model.State) bool {
+	_, s := m.current(st)
+
+	return s != nil
+}
+
+// Start starts the flow, the previous one is dropped.
+// Text after the command, if any, is the answer to the first step, e.g. `/add_address DE`.
+func (m *Machine) Start(ctx context.Context, st *model.State, name, args string) (Reply, error) {
+	return m.start(ctx, st, name, args, nil)
+}
+
+// StartWith starts the flow with data put into the conversation, e.g. when it's started by a button
+// and the button tells what the flow is about.
+func (m *Machine) StartWith(ctx context.Context, st *model.State, name string, data map[string]string) (Reply, error) {
+	return m.start(ctx, st, name, "", data)
+}
+
+func (m *Machine) start(ctx context.Context, st *model.State, name, args string, data map[string]string) (Reply, error) {
+	f, ok := m.flows[name]
+	if !ok {
+		return Reply{}, xerrors.Errorf("unknown flow %q", name)
+	}
+
+	m.reset(st)
+
+	c := &Conv{State: st}
+	for k, v := range data {
+		c.Put(k, v)
+	}
+
+	if f.Check != nil {
+		text, err := f.Check(ctx, c)
+		if err != nil {
+			return Reply{}, xerrors.Errorf("checking flow %q can be started: %w", name, err)
+		}
+
+		if text != "" {
+			m.reset(st)
+
+			return Reply{Text: text}, nil
+		}
+	}
+
+	st.PreviousCmd = f.Name
+	st.PreviousCmdAt = m.now()
+	st.Step = f.Steps[0].Name
+
+	if args = strings.TrimSpace(args); args != "" {
+		return m.Handle(ctx, st, args)
+	}
+
+	return prompt(f.Steps[0], c), nil
+}
+
+// Handle takes text as the answer to the current step and returns the next prompt
+// or, if the flow is finished, its final reply.
+func (m *Machine) Handle(ctx context.Context, st *model.State, text string) (Reply, error) {
+	f, s := m.current(st)
+	if s == nil {
+		return Reply{}, xerrors.Errorf("chat isn't in a flow, previous command is %q, step %q", st.PreviousCmd, st.Step)
+	}
+
+	text = strings.TrimSpace(text)
+	c := &Conv{State: st}
+
+	if text == CancelButton {
+		m.reset(st)
+
+		return Reply{Text: Canceled}, nil
+	}
+
+	skip := text == SkipButton
+	if skip && (s.Optional == nil || !s.Optional(c)) {
+		return Reply{Text: cantSkip + "\n\n" + s.Prompt(c), Buttons: prompt(s, c).Buttons}, nil
+	}
+
+	if s.Validate != nil && !skip {
+		if err := s.Validate(text); err != nil {
+			return Reply{Text: err.Error(), Buttons: prompt(s, c).Buttons}, nil
+		}
+	}
+
+	if s.Set != nil && !skip {
+		if err := s.Set(ctx, c, text); err != nil {
+			var inv Invalid
+			if xerrors.As(err, &inv) {
+				return Reply{Text: inv.Error(), Buttons: prompt(s, c).Buttons}, nil
+			}
+
+			return Reply{}, xerrors.Errorf("saving answer to step %q of flow %q: %w", s.Name, f.Name, err)
+		}
+	}
+
+	next := m.next(f, s, c)
+	if next == End {
+		var r Reply
+
+		if f.Done != nil {
+			var err error
+			if r, err = f.Done(ctx, c); err != nil {
+				return Reply{}, xerrors.Errorf("finishing flow %q: %w", f.Name, err)
+			}
+		}
+
+		m.reset(st)
+
+		return r, nil
+	}
+
+	ns := f.step(next)
+	if ns == nil {
+		return Reply{}, xerrors.Errorf("step %q of flow %q leads to unknown step %q", s.Name, f.Name, next)
+	}
+
+	st.History = append(st.History, s.Name)
+	st.Step = ns.Name
+	st.PreviousCmdAt = m.now()
+
+	return prompt(ns, c), nil
+}
+
+// Back returns to the previous step and asks it again, ok is false if there is no step to return to.
+func (m *Machine) Back(st *model.State) (r Reply, ok bool) {
+	f, s := m.current(st)
+	if s == nil || len(st.History) == 0 {
+		return Reply{}, false
+	}
+
+	prev := f.step(st.History[len(st.History)-1])
+	if prev == nil {
+		return Reply{}, false
+	}
+
+	st.History = st.History[:len(st.History)-1]
+	st.Step = prev.Name
+	st.PreviousCmdAt = m.now()
+
+	return prompt(prev, &Conv{State: st}), true
+}
+
+// Cancel drops the flow, ok is false if the chat isn't in a flow.
+func (m *Machine) Cancel(st *model.State) (ok bool) {
+	ok = m.Active(st)
+	m.reset(st)
+
+	return ok
+}
+
+// Answer returns text of the answer button under the prompt of the step,
+// ok is false if the chat isn't at the step anymore.
+func (m *Machine) Answer(st *model.State, step string, i int) (text string, ok bool) {
+	_, s := m.current(st)
+	if s == nil || s.Name != step {
+		return "", false
+	}
+
+	bb := prompt(s, &Conv{State: st}).Buttons
+	if i < 0 || i >= len(bb) {
+		return "", false
+	}
+
+	return bb[i].Text, true
+}
+
+func prompt(s *Step, c *Conv) Reply {
+	r := Reply{Text: s.Prompt(c)}
+
+	for _, b := range s.Buttons {
+		r.Buttons = append(r.Buttons, Button{Text: b})
+	}
+
+	if s.Optional != nil && s.Optional(c) {
+		r.Buttons = append(r.Buttons, Button{Text: SkipButton})
+	}
+
+	return r
+}
+
+func (m *Machine) current(st *model.State) (*Flow, *Step) {
+	f, ok := m.flows[st.PreviousCmd]
+	if !ok {
+		return nil, nil
+	}
+
+	return f, f.step(st.Step)
+}
+
+func (m *Machine) next(f *Flow, s *Step, c *Conv) string {
+	if s.Next != nil {
+		if next := s.Next(c); next != "" {
+			return next
+		}
+	}
+
+	for i := range f.Steps {
+		if f.Steps[i] == s && i+1 < len(f.Steps) {
+			return f.Steps[i+1].Name
+		}
+	}
+
+	return End
+}
+
+func (m *Machine) reset(st *model.State) {
+	st.PreviousCmd = ""
+	st.PreviousCmdAt = time.Time{}
+	st.Step = ""
+	st.History = nil
+	st.FlowData = nil
+}
+
+func (f *Flow) step(name string) *Step {
+	for _, s := range f.Steps {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package fsm
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// orderFlow asks the size and, optionally, the color of a t-shirt
+func orderFlow() *Flow {
+	return &Flow{
+		Name: "order",
+		Check: func(_ context.Context, c *Conv) (string, error) {
+			if c.Get("closed") != "" {
+				return "Заказы закрыты.", nil
+			}
+
+			return "", nil
+		},
+		Steps: []*Step{
+			{
+				Name:     "size",
+				Prompt:   Say("Какой размер?"),
+				Validate: NotEmpty("Напиши размер."),
+				Set: func(_ context.Context, c *Conv, text string) error {
+					if text == "XXL" {
+						return Invalid("Такого размера нет.")
+					}
+
+					c.Put("size", text)
+
+					return nil
+				},
+			},
+			{
+				Name:     "color",
+				Prompt:   Say("Какой цвет?"),
+				Buttons:  []string{"red", "blue"},
+				Optional: Always,
+				Set: func(_ context.Context, c *Conv, text string) error {
+					c.Put("color", text)
+
+					return nil
+				},
+				Next: func(c *Conv) string {
+					if c.Get("color") == "green" {
+						return "nowhere"
+					}
+
+					return ""
+				},
+			},
+		},
+		Done: func(_ context.Context, c *Conv) (Reply, error) {
+			return Reply{Text: "Заказ: " + c.Get("size") + " " + c.Get("color")}, nil
+		},
+	}
+}
+
+func newMachine(t *testing.T) *Machine {
+	t.Helper()
+
+	m, err := New(orderFlow())
+	if err != nil {
+		t.Fatalf("creating machine: %v", err)
+	}
+
+	return m
+}
+
+func TestNew(t *testing.T) {
+	step := func(name string) *Step { return &Step{Name: name, Prompt: Say(name)} }
+
+	for _, tt := range []struct {
+		name    string
+		flows   []*Flow
+		wantErr string
+	}{
+		{"ok", []*Flow{orderFlow(), {Name: "other", Steps: []*Step{step("a")}}}, ""},
+		{"duplicated flow", []*Flow{orderFlow(), orderFlow()}, "declared twice"},
+		{"named cancel", []*Flow{{Name: CmdCancel, Steps: []*Step{step("a")}}}, "can't be named"},
+		{"named back", []*Flow{{Name: CmdBack, Steps: []*Step{step("a")}}}, "can't be named"},
+		{"no steps", []*Flow{{Name: "empty"}}, "has no steps"},
+		{"unnamed step", []*Flow{{Name: "f", Steps: []*Step{step("")}}}, "reserved or duplicated"},
+		{"step named end", []*Flow{{Name: "f", Steps: []*Step{step(End)}}}, "reserved or duplicated"},
+		{"duplicated step", []*Flow{{Name: "f", Steps: []*Step{step("a"), step("a")}}}, "reserved or duplicated"},
+		{"no prompt", []*Flow{{Name: "f", Steps: []*Step{{Name: "a"}}}}, "has no prompt"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.flows...)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error is %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandle(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// args follow the command
+		args    string
+		answers []string
+		// want is the beginning of the last reply
+		want    string
+		wantErr string
+		// wantStep is where the chat is after the last answer, empty if it's out of the flow
+		wantStep string
+	}{
+		{name: "start", want: "Какой размер?", wantStep: "size"},
+		{name: "start with args", args: " M ", want: "Какой цвет?", wantStep: "color"},
+		{name: "invalid args", args: "XXL", want: "Такого размера нет.", wantStep: "size"},
+		{name: "empty answer", answers: []string{" "}, want: "Напиши размер.", wantStep: "size"},
+		{name: "invalid from set", answers: []string{"XXL"}, want: "Такого размера нет.", wantStep: "size"},
+		{name: "skip not optional", answers: []string{SkipButton}, want: cantSkip + "\n\nКакой размер?", wantStep: "size"},
+		{name: "skip optional", answers: []string{"M", SkipButton}, want: "Заказ: M "},
+		{name: "done", answers: []string{"M", "red"}, want: "Заказ: M red"},
+		{name: "cancel button", answers: []string{"M", CancelButton}, want: Canceled},
+		{name: "next to unknown step", answers: []string{"M", "green"}, wantErr: `unknown step "nowhere"`, wantStep: "color"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newMachine(t)
+			st := &model.State{ChatID: 1}
+
+			r, err := m.Start(ctx, st, "order", tt.args)
+
+			for _, a := range tt.answers {
+				if err != nil {
+					break
+				}
+
+				r, err = m.Handle(ctx, st, a)
+			}
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error is %v, want %q", err, tt.wantErr)
+			case tt.wantErr == "" && !strings.HasPrefix(r.Text, tt.want):
+				t.Fatalf("reply is %q, want %q", r.Text, tt.want)
+			}
+
+			if st.Step != tt.wantStep || m.Active(st) != (tt.wantStep != "") {
+				t.Fatalf("chat is at step %q of %q, want %q", st.Step, st.PreviousCmd, tt.wantStep)
+			}
+
+			if tt.wantStep == "" && (st.PreviousCmd != "" || st.History != nil || st.FlowData != nil) {
+				t.Fatalf("state isn't reset: %+v", st)
+			}
+		})
+	}
+}
+
+func TestPromptButtons(t *testing.T) {
+	ctx := context.Background()
+	m := newMachine(t)
+	st := &model.State{ChatID: 1}
+
+	r, err := m.Start(ctx, st, "order", "M")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Button{{Text: "red"}, {Text: "blue"}, {Text: SkipButton}}
+	if !reflect.DeepEqual(r.Buttons, want) {
+		t.Fatalf("buttons are %+v, want %+v", r.Buttons, want)
+	}
+
+	if text, ok := m.Answer(st, "color", 1); !ok || text != "blue" {
+		t.Fatalf("answer is %q, %v", text, ok)
+	}
+
+	if _, ok := m.Answer(st, "size", 0); ok {
+		t.Fatal("answer of the step the chat isn't at is taken")
+	}
+}
+
+func TestStartWithCheck(t *testing.T) {
+	ctx := context.Background()
+	m := newMachine(t)
+	st := &model.State{ChatID: 1}
+
+	r, err := m.StartWith(ctx, st, "order", map[string]string{"closed": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Text != "Заказы закрыты." || m.Active(st) || st.FlowData != nil {
+		t.Fatalf("reply %q, state %+v", r.Text, st)
+	}
+
+	if _, err := m.Start(ctx, st, "unknown", ""); err == nil {
+		t.Fatal("unknown flow is started")
+	}
+}
+
+func TestBack(t *testing.T) {
+	ctx := context.Background()
+	m := newMachine(t)
+	st := &model.State{ChatID: 1}
+
+	if _, err := m.Start(ctx, st, "order", "M"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := m.Back(st)
+	if !ok || r.Text != "Какой размер?" || st.Step != "size" || len(st.History) != 0 {
+		t.Fatalf("back is %q, %v, state %+v", r.Text, ok, st)
+	}
+
+	// the answer is kept, so the prompt may show it
+	if st.FlowData["size"] != "M" {
+		t.Fatalf("flow data is %v", st.FlowData)
+	}
+
+	if _, ok := m.Back(st); ok {
+		t.Fatal("back from the first step")
+	}
+
+	m.Cancel(st)
+
+	if _, ok := m.Back(st); ok {
+		t.Fatal("back out of a flow")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx := context.Background()
+	m := newMachine(t)
+	st := &model.State{ChatID: 1}
+
+	if m.Cancel(st) {
+		t.Fatal("canceled while not in a flow")
+	}
+
+	if _, err := m.Start(ctx, st, "order", "M"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Cancel(st) {
+		t.Fatal("flow isn't canceled")
+	}
+
+	if m.Active(st) || st.PreviousCmd != "" || st.FlowData != nil || st.History != nil {
+		t.Fatalf("state isn't reset: %+v", st)
+	}
+
+	if _, err := m.Handle(ctx, st, "M"); err == nil {
+		t.Fatal("answer is taken after cancel")
+	}
+}
@@ -0,0 +1,272 @@
+package migrate
+
+import (
+	"context"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/grbit/post_bot/migrations"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// advisoryLockID is any number, the same for every replica, so they don't migrate concurrently.
+const advisoryLockID = 4242
+
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator applies embedded migrations and keeps track of them in schema_migrations table.
+type Migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []*migration
+}
+
+func New(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+
+	mm, err := load(migrations.FS, dialect)
+	if err != nil {
+		return nil, xerrors.Errorf("loading %s migrations: %w", dialect, err)
+	}
+
+	return &Migrator{db: db, dialect: dialect, migrations: mm}, nil
+}
+
+func load(fsys fs.FS, dir string) ([]*migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, xerrors.Errorf("reading dir %q: %w", dir, err)
+	}
+
+	byVersion := make(map[int64]*migration)
+
+	for _, e := range entries {
+		parts := fileRe.FindStringSubmatch(e.Name())
+		if parts == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing version of %q: %w", e.Name(), err)
+		}
+
+		b, err := fs.ReadFile(fsys, dir+"/"+e.Name())
+		if err != nil {
+			return nil, xerrors.Errorf("reading %q: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: parts[2]}
+			byVersion[version] = m
+		}
+
+		if m.name != parts[2] {
+			return nil, xerrors.Errorf("version %d has two names: %q and %q", version, m.name, parts[2])
+		}
+
+		if parts[3] == "up" {
+			m.up = string(b)
+		} else {
+			m.down = string(b)
+		}
+	}
+
+	mm := make([]*migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, xerrors.Errorf("migration %d_%s has no up file", m.version, m.name)
+		}
+
+		mm = append(mm, m)
+	}
+
+	sort.Slice(mm, func(i, j int) bool { return mm[i].version < mm[j].version })
+
+	return mm, nil
+}
+
+// Up applies all migrations which are not applied yet.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(db *gorm.DB) error {
+		current, err := m.current(db)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if mg.version <= current {
+				continue
+			}
+
+			if err := m.apply(db, mg.version, mg.up, mg.version); err != nil {
+				return xerrors.Errorf("applying %d_%s: %w", mg.version, mg.name, err)
+			}
+
+			log.Info().Int64("version", mg.version).Str("name", mg.name).Msg("migration applied")
+		}
+
+		return nil
+	})
+}
+
+// Down reverts last `steps` applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(db *gorm.DB) error {
+		current, err := m.current(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			mg := m.migrations[i]
+			if mg.version > current {
+				continue
+			}
+
+			prev := int64(0)
+			if i > 0 {
+				prev = m.migrations[i-1].version
+			}
+
+			if mg.down == "" {
+				return xerrors.Errorf("migration %d_%s can't be reverted: no down file", mg.version, mg.name)
+			}
+
+			if err := m.apply(db, mg.version, mg.down, prev); err != nil {
+				return xerrors.Errorf("reverting %d_%s: %w", mg.version, mg.name, err)
+			}
+
+			log.Info().Int64("version", mg.version).Str("name", mg.name).Msg("migration reverted")
+
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Version returns the last applied migration version and whether the database is dirty.
+func (m *Migrator) Version(ctx context.Context) (version int64, dirty bool, err error) {
+	db := m.db.WithContext(ctx)
+
+	if err := m.ensureTable(db); err != nil {
+		return 0, false, err
+	}
+
+	return m.read(db)
+}
+
+// Force sets version and clears dirty flag without applying anything.
+// It's used after a failed migration was fixed by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	return m.locked(ctx, func(db *gorm.DB) error {
+		return m.setVersion(db, version, false)
+	})
+}
+
+// locked runs f on a single connection, holding postgres advisory lock if possible.
+func (m *Migrator) locked(ctx context.Context, f func(db *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(db *gorm.DB) error {
+		if m.dialect == "postgres" {
+			if err := db.Exec("SELECT pg_advisory_lock(?)", advisoryLockID).Error; err != nil {
+				return xerrors.Errorf("taking advisory lock: %w", err)
+			}
+
+			defer func() {
+				if err := db.Exec("SELECT pg_advisory_unlock(?)", advisoryLockID).Error; err != nil {
+					log.Error().Err(err).Msg("releasing advisory lock")
+				}
+			}()
+		}
+
+		if err := m.ensureTable(db); err != nil {
+			return err
+		}
+
+		return f(db)
+	})
+}
+
+// current returns the last applied version or error if the database is dirty.
+func (m *Migrator) current(db *gorm.DB) (int64, error) {
+	version, dirty, err := m.read(db)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return 0, xerrors.Errorf("database is dirty at version %d, fix it by hand and run `migrate force`", version)
+	}
+
+	return version, nil
+}
+
+// apply marks database dirty at `version`, then runs sql and sets `newVersion` in one transaction.
+// If sql fails, dirty flag stays.
+func (m *Migrator) apply(db *gorm.DB, version int64, sql string, newVersion int64) error {
+	if err := m.setVersion(db, version, true); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(sql).Error; err != nil {
+			return xerrors.Errorf("executing sql: %w", err)
+		}
+
+		return m.setVersion(tx, newVersion, false)
+	})
+}
+
+func (m *Migrator) ensureTable(db *gorm.DB) error {
+	err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL, dirty BOOLEAN NOT NULL)").Error
+	if err != nil {
+		return xerrors.Errorf("creating schema_migrations: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) read(db *gorm.DB) (version int64, dirty bool, err error) {
+	var rows []struct {
+		Version int64
+		Dirty   bool
+	}
+
+	if err := db.Raw("SELECT version, dirty FROM schema_migrations").Scan(&rows).Error; err != nil {
+		return 0, false, xerrors.Errorf("reading schema_migrations: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return 0, false, nil
+	}
+
+	return rows[0].Version, rows[0].Dirty, nil
+}
+
+func (m *Migrator) setVersion(db *gorm.DB, version int64, dirty bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM schema_migrations").Error; err != nil {
+			return xerrors.Errorf("cleaning schema_migrations: %w", err)
+		}
+
+		if err := tx.Exec("INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)", version, dirty).Error; err != nil {
+			return xerrors.Errorf("setting version %d: %w", version, err)
+		}
+
+		return nil
+	})
+}
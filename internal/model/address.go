@@ -1,19 +1,65 @@
 package model
 
+// Moderation statuses of an address which isn't approved. Approval itself is Address.Approved,
+// as it's also the column moderators edit in the table.
+const (
+	ModerationPending  = "pending"
+	ModerationRejected = "rejected"
+	ModerationChanges  = "changes"
+)
+
+// Privacy modes, they tell who gets the address.
+const (
+	// PrivacyOpen gives the address to anyone approved, it's the default
+	PrivacyOpen = "open"
+	// PrivacyRandom gives the address only in random draws, it's not given on search
+	PrivacyRandom = "random"
+	// PrivacyAsk asks the participant before giving the address on search
+	PrivacyAsk = "ask"
+)
+
 type Address struct {
 	Base
 
 	Telegram   string
 	Instagram  string
 	PersonName string
-	Address    string
-	Wishes     string
+	// Address is the postal address in one line. If the address is structured, it's rendered from the parts,
+	// otherwise it's what the participant has written.
+	Address string
+	Wishes  string
+
+	// Parts of the structured address, they are empty if the address was written in one line.
+	// RecipientLine is written on the envelope instead of the person name, if it's set.
+	RecipientLine string
+	Street        string
+	City          string
+	Region        string
+	PostalCode    string
+	// Country is ISO 3166-1 alpha-2 code, it may be set even if the other parts aren't
+	Country string
 
 	Approved bool
+	// Moderation is the status of a not approved address, it's empty if the address wasn't sent to moderators
+	Moderation string
+	// ModerationReason is the moderator's comment to rejection or requested changes
+	ModerationReason string
+
+	// ChatID is the private chat with the participant, it's known once they've registered via bot
+	ChatID int64
+	// Privacy is one of Privacy* modes, empty means PrivacyOpen
+	Privacy string
 
-	// Not added yet
 	Email string
 	Phone string
+	// PhoneIndex and EmailIndex are blind indexes phone and email are searched by when they are encrypted,
+	// they are set only in DB
+	PhoneIndex string `json:"-"`
+	EmailIndex string `json:"-"`
+
+	// SyncHash is a hash of the address as it was when it was last synced with the spreadsheet.
+	// Empty hash means the address never got there.
+	SyncHash string
 }
 
 func (p Address) String() string {
@@ -40,3 +86,8 @@ func (p Address) IsEmpty() bool {
 
 	return false
 }
+
+// Structured reports whether the address is split into parts.
+func (p Address) Structured() bool {
+	return p.Street != "" && p.Country != ""
+}
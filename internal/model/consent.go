@@ -0,0 +1,23 @@
+package model
+
+// Consent statuses.
+const (
+	ConsentPending  = "pending"
+	ConsentApproved = "approved"
+	ConsentDenied   = "denied"
+	// ConsentExpired wasn't answered in time
+	ConsentExpired = "expired"
+)
+
+// Consent is a request for the address of the participant who asked to be asked first, see PrivacyAsk.
+type Consent struct {
+	Base
+
+	// RequesterID is the private chat of the participant who wants the address
+	RequesterID int64
+	Requester   string
+	Recipient   string
+	// RecipientChatID is where the recipient is asked
+	RecipientChatID int64
+	Status          string
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Postcard statuses.
+const (
+	// PostcardAssigned is a postcard whose recipient's address is given to the sender
+	PostcardAssigned = "assigned"
+	// PostcardSent is in transit
+	PostcardSent     = "sent"
+	PostcardReceived = "received"
+	// PostcardExpired hasn't been received in time
+	PostcardExpired = "expired"
+)
+
+// Postcard is a postcard from the participant who got the address to its owner.
+type Postcard struct {
+	Base
+
+	// Code is written on the postcard, so the recipient can tell it has arrived
+	Code string
+	// SenderID is the private chat of the sender
+	SenderID   int64
+	Sender     string
+	Recipient  string
+	Status     string
+	SentAt     *time.Time
+	ReceivedAt *time.Time
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// How the address was requested.
+const (
+	MethodRandom = "random"
+	MethodSearch = "search"
+)
+
+// AddressRequest is a record of the address given to the participant, so nobody gets the same address twice.
+type AddressRequest struct {
+	Base
+
+	// RequesterID is the private chat of the participant who got the address
+	RequesterID int64
+	// Recipient is telegram nick of the address owner, who is going to get a postcard
+	Recipient string
+	Method    string
+}
+
+// Received tells how often the recipient has been drawn.
+type Received struct {
+	Count  int
+	LastAt time.Time
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 const (
 	CmdGiveMeSome    = "give_me_some"
 	CmdAddAddress    = "add_address"
@@ -7,15 +9,37 @@ const (
 	CmdAddWishes     = "add_wishes"
 	CmdAddPersonName = "add_name"
 	CmdMyData        = "my_data"
+	CmdRegister      = "register"
+	CmdMenu          = "menu"
+	CmdPending       = "pending"
+	CmdSent          = "sent"
+	CmdReceived      = "received"
+	CmdPrivacy       = "privacy"
+	CmdFilter        = "filter"
+	CmdExportMe      = "export_me"
+	CmdDeleteMe      = "delete_me"
+	CmdStats         = "stats"
+	CmdBan           = "ban"
+	CmdUnban         = "unban"
+	CmdRole          = "role"
+	CmdLookup        = "lookup"
+	CmdBroadcast     = "broadcast"
+	CmdReload        = "reload"
+	CmdExport        = "export"
 )
 
 type State struct {
 	Base
 
-	ChatID            int64
-	GivenAddressesCtr int
-	PreviousCmd       string
-	FileIDs           map[string]string
-	Telegram          string
-	Users             []*User
+	ChatID        int64
+	PreviousCmd   string
+	PreviousCmdAt time.Time
+	// Step is the current step of the PreviousCmd flow, History are the steps answered before it
+	Step    string
+	History []string `gorm:"serializer:json"`
+	// FlowData is what the flow keeps between steps
+	FlowData map[string]string `gorm:"serializer:json"`
+	FileIDs  map[string]string `gorm:"serializer:json"`
+	Telegram string
+	Users    []*User `gorm:"-"`
 }
@@ -1,8 +1,26 @@
 package model
 
+// Roles of telegram users, from the least to the most privileged.
+const (
+	RoleBanned      = "banned"
+	RoleParticipant = "participant"
+	RoleModerator   = "moderator"
+	RoleOwner       = "owner"
+)
+
+// ErasedTelegram replaces the nick of the participant who has erased their data, wherever it's kept,
+// it can't be a telegram nick.
+const ErasedTelegram = "[deleted]"
+
+// User is a telegram user who has talked to the bot, ChatID is the private chat with them, it's the user ID.
 type User struct {
 	Base
-	BookingID *int64
-	Requested *bool
-	CheckedIn *bool
+
+	ChatID   int64
+	Telegram string
+	// Role is empty for users whose role wasn't set, they are participants unless config says otherwise
+	Role string
+	// DrawFilter restricts addresses given to the user when the request has no filter of its own,
+	// it's written as postal.ParseFilter takes it
+	DrawFilter string
 }
@@ -0,0 +1,208 @@
+// Package pii keeps personal data of participants private: it's encrypted in DB and hidden from logs.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// prefix marks encrypted values, values without it were written before encryption was turned on
+const prefix = "enc1:"
+
+// keySize is the size of key encryption keys and data keys, it's AES-256
+const keySize = 32
+
+// Cipher does envelope encryption: every value is encrypted with its own random data key,
+// which is encrypted with a key from config. The ID of that key is kept with the value,
+// so the keys can be rotated while older values are still decrypted.
+type Cipher struct {
+	// current is the ID of the key new values are encrypted with
+	current string
+	keys    map[string]cipher.AEAD
+	// index is the key of blind indexes
+	index []byte
+}
+
+// NewCipher parses keys given as "id:base64", the first key encrypts, all of them decrypt.
+// Index key is base64 too, it must not change, or blind indexes have to be rebuilt.
+func NewCipher(keys []string, indexKey string) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, xerrors.Errorf("no encryption keys")
+	}
+
+	if indexKey == "" {
+		return nil, xerrors.Errorf("blind index key is required to encrypt data")
+	}
+
+	index, err := base64.StdEncoding.DecodeString(indexKey)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding blind index key: %w", err)
+	}
+
+	c := &Cipher{keys: make(map[string]cipher.AEAD), index: index}
+
+	for i, k := range keys {
+		id, encoded, ok := strings.Cut(k, ":")
+		if !ok || id == "" {
+			return nil, xerrors.Errorf("key #%d isn't id:base64", i+1)
+		}
+
+		if _, ok := c.keys[id]; ok {
+			return nil, xerrors.Errorf("key ID %q is repeated", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding key %q: %w", id, err)
+		}
+
+		if len(key) != keySize {
+			return nil, xerrors.Errorf("key %q is %d bytes, must be %d", id, len(key), keySize)
+		}
+
+		if c.keys[id], err = newAEAD(key); err != nil {
+			return nil, xerrors.Errorf("key %q: %w", id, err)
+		}
+
+		if i == 0 {
+			c.current = id
+		}
+	}
+
+	return c, nil
+}
+
+// Encrypt encrypts the value with the current key, empty value stays empty.
+func (c *Cipher) Encrypt(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", xerrors.Errorf("generating data key: %w", err)
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(data, []byte(plain), nil)
+	if err != nil {
+		return "", xerrors.Errorf("encrypting value: %w", err)
+	}
+
+	// the key ID is authenticated, so the wrapped key can't be passed off as wrapped by another key
+	wrapped, err := seal(c.keys[c.current], dataKey, []byte(c.current))
+	if err != nil {
+		return "", xerrors.Errorf("encrypting data key: %w", err)
+	}
+
+	return prefix + c.current + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the value with the key it was encrypted with.
+// Value which isn't encrypted is returned as it is.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", xerrors.Errorf("malformed encrypted value")
+	}
+
+	id := parts[0]
+
+	kek, ok := c.keys[id]
+	if !ok {
+		return "", xerrors.Errorf("value is encrypted with unknown key %q", id)
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", xerrors.Errorf("decoding data key: %w", err)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", xerrors.Errorf("decoding value: %w", err)
+	}
+
+	dataKey, err := open(kek, wrapped, []byte(id))
+	if err != nil {
+		return "", xerrors.Errorf("decrypting data key with key %q: %w", id, err)
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := open(data, sealed, nil)
+	if err != nil {
+		return "", xerrors.Errorf("decrypting value: %w", err)
+	}
+
+	return string(plain), nil
+}
+
+// Index returns blind index of the value: equal values have equal indexes, so encrypted values
+// can be searched by them, but the value can't be got back. Empty value has empty index.
+func (c *Cipher) Index(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	h := hmac.New(sha256.New, c.index)
+	h.Write([]byte(value))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, xerrors.Errorf("creating AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, xerrors.Errorf("creating GCM: %w", err)
+	}
+
+	return gcm, nil
+}
+
+// seal encrypts the data and puts random nonce before it.
+func seal(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, xerrors.Errorf("generating nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, data, ad), nil
+}
+
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, xerrors.Errorf("encrypted data is too short")
+	}
+
+	n := aead.NonceSize()
+
+	return aead.Open(nil, sealed[:n], sealed[n:], ad)
+}
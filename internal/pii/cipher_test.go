@@ -0,0 +1,211 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// key returns key config of the ID with 32 bytes of b
+func key(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keySize))
+}
+
+var indexKey = base64.StdEncoding.EncodeToString([]byte("blind index key"))
+
+func newCipher(t *testing.T, keys ...string) *Cipher {
+	t.Helper()
+
+	c, err := NewCipher(keys, indexKey)
+	if err != nil {
+		t.Fatalf("creating cipher: %v", err)
+	}
+
+	return c
+}
+
+func TestNewCipher(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		keys     []string
+		indexKey string
+		wantErr  string
+	}{
+		{"ok", []string{key("k2", 2), key("k1", 1)}, indexKey, ""},
+		{"no keys", nil, indexKey, "no encryption keys"},
+		{"no index key", []string{key("k1", 1)}, "", "blind index key is required"},
+		{"bad index key", []string{key("k1", 1)}, "not base64!", "decoding blind index key"},
+		{"no ID", []string{":" + strings.SplitN(key("k1", 1), ":", 2)[1]}, indexKey, "isn't id:base64"},
+		{"no colon", []string{"k1"}, indexKey, "isn't id:base64"},
+		{"repeated ID", []string{key("k1", 1), key("k1", 2)}, indexKey, "is repeated"},
+		{"bad base64", []string{"k1:not base64!"}, indexKey, "decoding key"},
+		{"short key", []string{"k1:" + base64.StdEncoding.EncodeToString([]byte("short"))}, indexKey, "must be 32"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCipher(tt.keys, tt.indexKey)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error is %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newCipher(t, key("k1", 1))
+
+	for _, plain := range []string{
+		"",
+		"a",
+		"Moscow, Red square 1",
+		"Иванов Иван Иванович",
+		"enc1:looks encrypted, but isn't",
+		strings.Repeat("long address ", 1000),
+	} {
+		enc, err := c.Encrypt(plain)
+		if err != nil {
+			t.Fatalf("encrypting %q: %v", plain, err)
+		}
+
+		switch {
+		case plain == "" && enc != "":
+			t.Fatalf("empty value is encrypted to %q", enc)
+		// a short value could be found in base64 by chance
+		case plain != "" && (!strings.HasPrefix(enc, prefix+"k1:") || len(plain) > 3 && strings.Contains(enc, plain)):
+			t.Fatalf("%q is encrypted to %q", plain, enc)
+		}
+
+		got, err := c.Decrypt(enc)
+		if err != nil {
+			t.Fatalf("decrypting %q: %v", plain, err)
+		}
+
+		if got != plain {
+			t.Fatalf("decrypted %q, want %q", got, plain)
+		}
+	}
+
+	// every value has its own data key and nonce
+	a, _ := c.Encrypt("same")
+	b, _ := c.Encrypt("same")
+
+	if a == b {
+		t.Fatalf("equal values are encrypted equally: %q", a)
+	}
+}
+
+func TestDecryptNotEncrypted(t *testing.T) {
+	c := newCipher(t, key("k1", 1))
+
+	for _, v := range []string{"", "Moscow", "enc:not ours"} {
+		if got, err := c.Decrypt(v); err != nil || got != v {
+			t.Fatalf("decrypting %q: %q, %v", v, got, err)
+		}
+	}
+}
+
+func TestDecryptWithOlderKey(t *testing.T) {
+	old := newCipher(t, key("k1", 1))
+
+	enc, err := old.Encrypt("Moscow")
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+
+	rotated := newCipher(t, key("k2", 2), key("k1", 1))
+
+	if got, err := rotated.Decrypt(enc); err != nil || got != "Moscow" {
+		t.Fatalf("decrypting with rotated keys: %q, %v", got, err)
+	}
+
+	if enc, _ := rotated.Encrypt("Moscow"); !strings.HasPrefix(enc, prefix+"k2:") {
+		t.Fatalf("new value is encrypted as %q, want key k2", enc)
+	}
+
+	// the old key is dropped
+	if _, err := newCipher(t, key("k2", 2)).Decrypt(enc); err == nil || !strings.Contains(err.Error(), `unknown key "k1"`) {
+		t.Fatalf("error is %v", err)
+	}
+
+	// the same ID with another key
+	if _, err := newCipher(t, key("k1", 3)).Decrypt(enc); err == nil {
+		t.Fatal("value is decrypted with a wrong key")
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	c := newCipher(t, key("k1", 1), key("k2", 2))
+
+	enc, err := c.Encrypt("Moscow")
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(enc, prefix), ":")
+
+	// flip changes a character in the middle of s
+	flip := func(s string) string {
+		b := []byte(s)
+		if b[len(b)/2] == 'A' {
+			b[len(b)/2] = 'B'
+		} else {
+			b[len(b)/2] = 'A'
+		}
+
+		return string(b)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		value string
+	}{
+		{"data key", prefix + parts[0] + ":" + flip(parts[1]) + ":" + parts[2]},
+		{"value", prefix + parts[0] + ":" + parts[1] + ":" + flip(parts[2])},
+		{"key ID", prefix + "k2:" + parts[1] + ":" + parts[2]},
+		{"truncated value", prefix + parts[0] + ":" + parts[1] + ":" + parts[2][:4]},
+		{"missing part", prefix + parts[0] + ":" + parts[1]},
+		{"extra part", enc + ":" + parts[2]},
+		{"bad base64", prefix + parts[0] + ":" + parts[1] + ":" + parts[2] + "!"},
+		{"empty parts", prefix + "::"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := c.Decrypt(tt.value); err == nil {
+				t.Fatalf("tampered value is decrypted to %q", got)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	c := newCipher(t, key("k1", 1))
+
+	if c.Index("") != "" {
+		t.Fatal("empty value has an index")
+	}
+
+	if c.Index("+79990001122") != c.Index("+79990001122") {
+		t.Fatal("equal values have different indexes")
+	}
+
+	if c.Index("+79990001122") == c.Index("+79990001123") {
+		t.Fatal("different values have equal indexes")
+	}
+
+	// rotated encryption keys don't change indexes
+	if newCipher(t, key("k2", 2), key("k1", 1)).Index("a@b.c") != c.Index("a@b.c") {
+		t.Fatal("index depends on encryption keys")
+	}
+
+	other, err := NewCipher([]string{key("k1", 1)}, base64.StdEncoding.EncodeToString([]byte("other")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Index("a@b.c") == c.Index("a@b.c") {
+		t.Fatal("index doesn't depend on the index key")
+	}
+}
@@ -0,0 +1,100 @@
+package pii
+
+import (
+	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog"
+)
+
+// hidden replaces personal data and secrets in logs
+const hidden = "***"
+
+// RedactLogs makes addresses and config logged with Interface hide personal data and secrets.
+func RedactLogs() {
+	marshal := zerolog.InterfaceMarshalFunc
+
+	zerolog.InterfaceMarshalFunc = func(v interface{}) ([]byte, error) {
+		return marshal(Redact(v))
+	}
+}
+
+// Redact returns a copy of the address or config without personal data and secrets,
+// other values are returned as they are.
+func Redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case model.Address:
+		return redactAddress(&v)
+	case *model.Address:
+		if v == nil {
+			return v
+		}
+
+		return redactAddress(v)
+	case []*model.Address:
+		aa := make([]*model.Address, 0, len(v))
+		for _, a := range v {
+			if a != nil {
+				a = redactAddress(a)
+			}
+
+			aa = append(aa, a)
+		}
+
+		return aa
+	case config.Values:
+		return redactConfig(&v)
+	case *config.Values:
+		if v == nil {
+			return v
+		}
+
+		return redactConfig(v)
+	default:
+		return v
+	}
+}
+
+func redactAddress(a *model.Address) *model.Address {
+	r := *a
+	r.Instagram = hide(r.Instagram)
+	r.PersonName = hide(r.PersonName)
+	r.Wishes = hide(r.Wishes)
+	r.Address = hide(r.Address)
+	r.Phone = hide(r.Phone)
+	r.Email = hide(r.Email)
+	r.RecipientLine = hide(r.RecipientLine)
+	r.Street = hide(r.Street)
+	r.City = hide(r.City)
+	r.Region = hide(r.Region)
+	r.PostalCode = hide(r.PostalCode)
+	// moderators may quote the address in the reason
+	r.ModerationReason = hide(r.ModerationReason)
+
+	return &r
+}
+
+func redactConfig(cfg *config.Values) *config.Values {
+	r := *cfg
+	r.BotToken = hide(r.BotToken)
+	r.PostgresURL = hide(r.PostgresURL)
+	r.GoogleCredentialsJSON = hide(r.GoogleCredentialsJSON)
+	r.WebhookSecret = hide(r.WebhookSecret)
+	r.BlindIndexKey = hide(r.BlindIndexKey)
+
+	r.EncryptionKeys = make([]string, len(cfg.EncryptionKeys))
+	for i := range cfg.EncryptionKeys {
+		r.EncryptionKeys[i] = hidden
+	}
+
+	return &r
+}
+
+// hide hides the value, but it's still seen if it's set.
+func hide(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	return hidden
+}
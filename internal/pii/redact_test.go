@@ -0,0 +1,140 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/config"
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog"
+)
+
+// personal are values of personal data and secrets which mustn't get to logs
+var personal = []string{
+	"Ivan Petrov", "Moscow, Red square 1", "+79990001122", "ivan@example.com", "ivan_insta", "socks please",
+	"Mr Petrov", "Red square 1", "Moscow city", "Moscow region", "101000",
+	"123456:bot-token", "postgres://user:password@db/post_bot", `{"private_key": "pk"}`, "webhook-secret",
+	"index-key", "k1:encryption-key", "no such street as Red square 1",
+}
+
+func address() *model.Address {
+	return &model.Address{
+		Telegram: "ivan", PersonName: "Ivan Petrov", Address: "Moscow, Red square 1", Phone: "+79990001122",
+		Email: "ivan@example.com", Instagram: "ivan_insta", Wishes: "socks please", RecipientLine: "Mr Petrov",
+		Street: "Red square 1", City: "Moscow city", Region: "Moscow region", PostalCode: "101000", Country: "RU",
+		ModerationReason: "no such street as Red square 1",
+	}
+}
+
+func cfg() config.Values {
+	return config.Values{
+		BotToken: "123456:bot-token", PostgresURL: "postgres://user:password@db/post_bot",
+		GoogleCredentialsJSON: `{"private_key": "pk"}`, WebhookSecret: "webhook-secret",
+		BlindIndexKey: "index-key", EncryptionKeys: []string{"k1:encryption-key"}, Storage: "postgres",
+	}
+}
+
+// leaked returns personal values found in s
+func leaked(s string) []string {
+	var found []string
+
+	for _, p := range personal {
+		if strings.Contains(s, p) {
+			found = append(found, p)
+		}
+	}
+
+	return found
+}
+
+func TestRedact(t *testing.T) {
+	c := cfg()
+
+	for _, tt := range []struct {
+		name string
+		v    interface{}
+		// kept must stay, so the logged value is still of use
+		kept []string
+	}{
+		{"address", *address(), []string{`"Telegram":"ivan"`, `"Country":"RU"`}},
+		{"address pointer", address(), []string{`"Telegram":"ivan"`}},
+		{"addresses", []*model.Address{address(), nil, address()}, []string{`"Telegram":"ivan"`, "null"}},
+		{"config", c, []string{`"Storage":"postgres"`}},
+		{"config pointer", &c, []string{`"Storage":"postgres"`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			bb, err := json.Marshal(Redact(tt.v))
+			if err != nil {
+				t.Fatalf("marshalling: %v", err)
+			}
+
+			if found := leaked(string(bb)); len(found) > 0 {
+				t.Fatalf("%s has %q", bb, found)
+			}
+
+			for _, k := range tt.kept {
+				if !strings.Contains(string(bb), k) {
+					t.Fatalf("%s has no %s", bb, k)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactKeepsOriginal(t *testing.T) {
+	a, c := address(), cfg()
+
+	Redact(a)
+	Redact(&c)
+
+	if *a != *address() {
+		t.Fatalf("address is changed: %+v", *a)
+	}
+
+	if c.BotToken != cfg().BotToken || c.EncryptionKeys[0] != cfg().EncryptionKeys[0] {
+		t.Fatalf("config is changed: %+v", c)
+	}
+}
+
+func TestRedactEmpty(t *testing.T) {
+	var (
+		a *model.Address
+		c *config.Values
+	)
+
+	if Redact(a) != a || Redact(c) != c {
+		t.Fatal("nil isn't kept")
+	}
+
+	r, ok := Redact(model.Address{Telegram: "ivan"}).(*model.Address)
+	if !ok || r.PersonName != "" || r.Phone != "" {
+		t.Fatalf("empty fields are redacted to %+v", r)
+	}
+}
+
+func TestRedactLogs(t *testing.T) {
+	prev := zerolog.InterfaceMarshalFunc
+	t.Cleanup(func() { zerolog.InterfaceMarshalFunc = prev })
+
+	RedactLogs()
+
+	var buf bytes.Buffer
+
+	logger := zerolog.New(&buf)
+	logger.Info().
+		Interface("address", address()).
+		Interface("addresses", []*model.Address{address()}).
+		Interface("config", cfg()).
+		Msg("logged")
+
+	if found := leaked(buf.String()); len(found) > 0 {
+		t.Fatalf("log %s has %q", buf.String(), found)
+	}
+
+	if !strings.Contains(buf.String(), `"Telegram":"ivan"`) {
+		t.Fatalf("log %s has no telegram", buf.String())
+	}
+}
@@ -0,0 +1,198 @@
+package postal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+// kinds of filter conditions
+const (
+	filterCountry  = "country"
+	filterRegion   = "region"
+	filterLanguage = "lang"
+)
+
+// filterKeys are how conditions are written, Russian ones are for participants who don't type in English
+var filterKeys = map[string]string{
+	"country":  filterCountry,
+	"страна":   filterCountry,
+	"region":   filterRegion,
+	"регион":   filterRegion,
+	"lang":     filterLanguage,
+	"language": filterLanguage,
+	"язык":     filterLanguage,
+}
+
+// regionNames are names of the parts of the world by Country.Region
+var regionNames = map[string][]string{
+	"europe":   {"европа"},
+	"asia":     {"азия"},
+	"americas": {"америка", "america"},
+	"oceania":  {"океания", "australia", "австралия"},
+}
+
+// ErrNoCountries is returned when conditions of the filter rule out every country.
+var ErrNoCountries = xerrors.New("no country matches the filter")
+
+// FilterError is returned for a value of the filter which isn't known.
+type FilterError struct {
+	Value string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("unknown filter value %q", e.Value)
+}
+
+// Filter restricts addresses to some countries. Conditions of different kinds must all hold,
+// a condition with several values holds if any of them does. Empty filter lets any address through.
+type Filter struct {
+	// Countries are ISO codes
+	Countries []string
+	// Regions are parts of the world, see Country.Region
+	Regions []string
+	// Languages are ISO 639-1 codes, the country must have one of them as official
+	Languages []string
+}
+
+// ParseFilter takes conditions like "country:DE,AT region:europe lang:de" out of the text,
+// the rest of the text is returned as it is.
+func ParseFilter(text string) (f Filter, rest string, err error) {
+	var words []string
+
+	for _, w := range strings.Fields(text) {
+		key, values, ok := strings.Cut(w, ":")
+		kind := filterKeys[strings.ToLower(key)]
+
+		if !ok || kind == "" {
+			words = append(words, w)
+
+			continue
+		}
+
+		for _, v := range strings.Split(values, ",") {
+			if v == "" {
+				continue
+			}
+
+			if err := f.add(kind, v); err != nil {
+				return Filter{}, "", err
+			}
+		}
+	}
+
+	if !f.IsEmpty() && len(f.Codes()) == 0 {
+		return Filter{}, "", ErrNoCountries
+	}
+
+	return f, strings.Join(words, " "), nil
+}
+
+func (f *Filter) add(kind, value string) error {
+	switch kind {
+	case filterCountry:
+		c := Find(value)
+		if c == nil {
+			return &FilterError{Value: value}
+		}
+
+		f.Countries = appendNew(f.Countries, c.Code)
+	case filterRegion:
+		region := findRegion(value)
+		if region == "" {
+			return &FilterError{Value: value}
+		}
+
+		f.Regions = appendNew(f.Regions, region)
+	case filterLanguage:
+		lang := strings.ToLower(value)
+		if !lo.ContainsBy(countries, func(c *Country) bool { return lo.Contains(c.Languages, lang) }) {
+			return &FilterError{Value: value}
+		}
+
+		f.Languages = appendNew(f.Languages, lang)
+	}
+
+	return nil
+}
+
+func findRegion(name string) string {
+	name = strings.ToLower(name)
+
+	for region, names := range regionNames {
+		if name == region || lo.Contains(names, name) {
+			return region
+		}
+	}
+
+	return ""
+}
+
+func appendNew(ss []string, s string) []string {
+	if lo.Contains(ss, s) {
+		return ss
+	}
+
+	return append(ss, s)
+}
+
+// IsEmpty reports whether the filter has no conditions.
+func (f Filter) IsEmpty() bool {
+	return len(f.Countries) == 0 && len(f.Regions) == 0 && len(f.Languages) == 0
+}
+
+// Match reports whether the address in the country with the ISO code passes the filter.
+// Addresses without a country pass only the empty filter.
+func (f Filter) Match(code string) bool {
+	if f.IsEmpty() {
+		return true
+	}
+
+	c := Lookup(code)
+	if c == nil {
+		return false
+	}
+
+	return (len(f.Countries) == 0 || lo.Contains(f.Countries, c.Code)) &&
+		(len(f.Regions) == 0 || lo.Contains(f.Regions, c.Region)) &&
+		(len(f.Languages) == 0 || lo.Some(f.Languages, c.Languages))
+}
+
+// Codes returns ISO codes of the countries which pass the filter, nil for the empty filter.
+func (f Filter) Codes() []string {
+	if f.IsEmpty() {
+		return nil
+	}
+
+	var codes []string
+
+	for _, c := range countries {
+		if f.Match(c.Code) {
+			codes = append(codes, c.Code)
+		}
+	}
+
+	return codes
+}
+
+// String returns the filter the way ParseFilter takes it.
+func (f Filter) String() string {
+	var conds []string
+
+	for _, c := range []struct {
+		kind   string
+		values []string
+	}{
+		{filterCountry, f.Countries},
+		{filterRegion, f.Regions},
+		{filterLanguage, f.Languages},
+	} {
+		if len(c.values) > 0 {
+			conds = append(conds, c.kind+":"+strings.Join(c.values, ","))
+		}
+	}
+
+	return strings.Join(conds, " ")
+}
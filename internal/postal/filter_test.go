@@ -0,0 +1,132 @@
+package postal
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		text     string
+		want     Filter
+		wantRest string
+	}{
+		{"empty", "", Filter{}, ""},
+		{"no filter", " Bob  Marley ", Filter{}, "Bob Marley"},
+		{"countries", "country:DE,at", Filter{Countries: []string{"DE", "AT"}}, ""},
+		{"country names", "country:Германия,austria", Filter{Countries: []string{"DE", "AT"}}, ""},
+		{"repeated country", "country:DE country:de,Germany", Filter{Countries: []string{"DE"}}, ""},
+		{"empty values", "country:,DE,", Filter{Countries: []string{"DE"}}, ""},
+		{"russian keys", "Страна:DE регион:europe ЯЗЫК:de", Filter{
+			Countries: []string{"DE"}, Regions: []string{"europe"}, Languages: []string{"de"},
+		}, ""},
+		{"language key", "language:DE", Filter{Languages: []string{"de"}}, ""},
+		{"europe alias", "region:Европа", Filter{Regions: []string{"europe"}}, ""},
+		{"asia alias", "region:азия", Filter{Regions: []string{"asia"}}, ""},
+		{"americas aliases", "region:america,Америка,americas", Filter{Regions: []string{"americas"}}, ""},
+		{"oceania aliases", "region:океания,Australia,австралия", Filter{Regions: []string{"oceania"}}, ""},
+		{"rest is kept", "Bob region:asia Marley", Filter{Regions: []string{"asia"}}, "Bob Marley"},
+		{"unknown key", "city:Berlin", Filter{}, "city:Berlin"},
+		{"no colon", "country", Filter{}, "country"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, rest, err := ParseFilter(tt.text)
+			if err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+
+			if !reflect.DeepEqual(f, tt.want) || rest != tt.wantRest {
+				t.Fatalf("filter is %+v with %q, want %+v with %q", f, rest, tt.want, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+		// wantValue is the value of FilterError, empty means ErrNoCountries
+		wantValue string
+	}{
+		{"unknown country", "country:DE,Narnia", "Narnia"},
+		{"unknown country in russian", "страна:Нарния", "Нарния"},
+		{"unknown region", "region:antarctica", "antarctica"},
+		{"unknown lang", "lang:xx", "xx"},
+		{"country out of region", "country:DE region:asia", ""},
+		{"lang out of region", "регион:oceania язык:de", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, rest, err := ParseFilter(tt.text)
+			if !f.IsEmpty() || rest != "" {
+				t.Fatalf("filter is %+v with %q on error", f, rest)
+			}
+
+			var fe *FilterError
+
+			switch {
+			case tt.wantValue == "" && !xerrors.Is(err, ErrNoCountries):
+				t.Fatalf("error is %v, want %v", err, ErrNoCountries)
+			case tt.wantValue != "" && (!xerrors.As(err, &fe) || fe.Value != tt.wantValue):
+				t.Fatalf("error is %v, want unknown %q", err, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"country:de", "country:DE"},
+		{"язык:DE регион:Европа страна:Австрия,германия", "country:AT,DE region:europe lang:de"},
+		{"region:asia,океания lang:en", "region:asia,oceania lang:en"},
+	} {
+		t.Run(tt.text, func(t *testing.T) {
+			f, _, err := ParseFilter(tt.text)
+			if err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+
+			if f.String() != tt.want {
+				t.Fatalf("filter is %q, want %q", f.String(), tt.want)
+			}
+
+			// saved filters are parsed back
+			back, rest, err := ParseFilter(f.String())
+			if err != nil || rest != "" || !reflect.DeepEqual(back, f) {
+				t.Fatalf("%q is parsed back as %+v with %q, %v", f.String(), back, rest, err)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	f, _, err := ParseFilter("region:europe lang:de")
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+
+	for code, want := range map[string]bool{"DE": true, "at": true, "CH": true, "RU": false, "US": false, "": false} {
+		if f.Match(code) != want {
+			t.Fatalf("match of %q is %v, want %v", code, !want, want)
+		}
+	}
+
+	codes := f.Codes()
+	sort.Strings(codes)
+
+	if !reflect.DeepEqual(codes, []string{"AT", "BE", "CH", "DE"}) {
+		t.Fatalf("codes are %v", codes)
+	}
+
+	if !(Filter{}).Match("") || (Filter{}).Codes() != nil {
+		t.Fatal("empty filter doesn't let everything through")
+	}
+}
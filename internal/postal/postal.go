@@ -0,0 +1,158 @@
+// Package postal knows how addresses are written in different countries: what their postal codes look like
+// and in which order the address lines go. The rules are data in countries.json.
+package postal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultFormat is used for countries without rules, tokens are described at Country.Format
+const defaultFormat = "%N\n%A\n%Z %C\n%S"
+
+//go:embed countries.json
+var countriesJSON []byte
+
+// Country is how addresses are written in the country.
+type Country struct {
+	// Code is ISO 3166-1 alpha-2 code
+	Code string `json:"code"`
+	// Names are how participants may call the country, the first one is shown to them
+	Names []string `json:"names"`
+	// English is the name written on the last line of the address, in capitals as it's required for
+	// international mail
+	English string `json:"en"`
+	// Region is the part of the world, one of the keys of regionNames
+	Region string `json:"region"`
+	// Languages are ISO 639-1 codes of official languages
+	Languages []string `json:"languages"`
+	// Postal is a regular expression of postal codes, empty if the country doesn't use them
+	Postal string `json:"postal"`
+	// PostalOptional is set for countries where postal codes are used, but many addresses go without them
+	PostalOptional bool   `json:"postal_optional"`
+	Example        string `json:"example"`
+	// Format is the order of the address lines: %N is recipient, %A is street with house,
+	// %C is city, %S is region, %Z is postal code
+	Format string `json:"format"`
+
+	postal *regexp.Regexp
+}
+
+// Address is an address split into parts.
+type Address struct {
+	Recipient  string
+	Street     string
+	City       string
+	Region     string
+	PostalCode string
+	// Country is ISO code
+	Country string
+}
+
+var (
+	countries []*Country
+	byCode    map[string]*Country
+)
+
+func init() {
+	if err := json.Unmarshal(countriesJSON, &countries); err != nil {
+		panic(xerrors.Errorf("parsing countries.json: %w", err))
+	}
+
+	byCode = make(map[string]*Country, len(countries))
+
+	for _, c := range countries {
+		if c.Postal != "" {
+			c.postal = regexp.MustCompile(c.Postal)
+		}
+
+		byCode[c.Code] = c
+	}
+}
+
+// Lookup returns the country by ISO code, nil if there are no rules for it.
+func Lookup(code string) *Country {
+	return byCode[strings.ToUpper(code)]
+}
+
+// Find returns the country by ISO code or by one of its names, nil if there is no such country.
+func Find(text string) *Country {
+	text = strings.Join(strings.Fields(text), " ")
+
+	if c := Lookup(text); c != nil {
+		return c
+	}
+
+	for _, c := range countries {
+		for _, n := range c.Names {
+			if strings.EqualFold(n, text) {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// Name returns the name shown to participants.
+func (c *Country) Name() string {
+	return c.Names[0]
+}
+
+// HasPostalCodes reports whether addresses in the country have postal codes.
+func (c *Country) HasPostalCodes() bool {
+	return c.postal != nil
+}
+
+// PostalCode returns the postal code as it's written in the country or an error if it doesn't look like one.
+func (c *Country) PostalCode(code string) (string, error) {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), " "))
+
+	if c.postal != nil && !c.postal.MatchString(code) {
+		return "", xerrors.Errorf("postal code %q doesn't match %s", code, c.Postal)
+	}
+
+	return code, nil
+}
+
+// Format returns the address lines in the order of the country, the last one is the country.
+// Empty parts are left out.
+func Format(a Address) []string {
+	format, country := defaultFormat, strings.ToUpper(a.Country)
+	if c := Lookup(a.Country); c != nil {
+		format, country = c.Format, c.English
+	}
+
+	r := strings.NewReplacer(
+		"%N", a.Recipient,
+		"%A", a.Street,
+		"%C", a.City,
+		"%S", a.Region,
+		"%Z", a.PostalCode,
+	)
+
+	var lines []string
+
+	for _, l := range strings.Split(format, "\n") {
+		// separators of empty parts are left at the ends
+		l = strings.Trim(strings.Join(strings.Fields(r.Replace(l)), " "), " ,/-")
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+
+	if country != "" {
+		lines = append(lines, country)
+	}
+
+	return lines
+}
+
+// Line returns the address in one line, e.g. for a table cell.
+func Line(a Address) string {
+	return strings.Join(Format(a), ", ")
+}
@@ -0,0 +1,144 @@
+package postal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCountries(t *testing.T) {
+	names := map[string]string{}
+
+	for _, c := range countries {
+		t.Run(c.Code, func(t *testing.T) {
+			if c.Name() == "" || c.English == "" || regionNames[c.Region] == nil || len(c.Languages) == 0 {
+				t.Fatalf("country is incomplete: %+v", c)
+			}
+
+			if !strings.Contains(c.Format, "%N") || !strings.Contains(c.Format, "%A") {
+				t.Fatalf("format %q has no recipient or street", c.Format)
+			}
+
+			if c.HasPostalCodes() != (c.Example != "") {
+				t.Fatalf("postal code %q has example %q", c.Postal, c.Example)
+			}
+
+			// the example is shown to participants, it must be taken as it is
+			if code, err := c.PostalCode(c.Example); err != nil || code != c.Example {
+				t.Fatalf("example %q is taken as %q, %v", c.Example, code, err)
+			}
+
+			for _, n := range append([]string{c.Code, strings.ToLower(c.Code)}, c.Names...) {
+				if other, ok := names[strings.ToLower(n)]; ok && other != c.Code {
+					t.Fatalf("name %q is taken by %s", n, other)
+				}
+
+				names[strings.ToLower(n)] = c.Code
+
+				if f := Find(" " + strings.ToUpper(n) + " "); f != c {
+					t.Fatalf("%q is found as %+v", n, f)
+				}
+			}
+		})
+	}
+
+	if Lookup("XX") != nil || Find("Atlantis") != nil {
+		t.Fatal("unknown country is found")
+	}
+}
+
+func TestPostalCode(t *testing.T) {
+	for _, tt := range []struct {
+		country string
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{"RU", "101000", "101000", false},
+		{"RU", " 101000 ", "101000", false},
+		{"RU", "10100", "", true},
+		{"RU", "101 000", "", true},
+		{"GB", "sw1a  1aa", "SW1A 1AA", false},
+		{"GB", "SW1A1AA", "SW1A1AA", false},
+		{"GB", "12345", "", true},
+		{"NL", "1011 ab", "1011 AB", false},
+		{"NL", "AB 1011", "", true},
+		{"LV", "lv-1050", "LV-1050", false},
+		{"LV", "1050", "", true},
+		{"LT", "01001", "01001", false},
+		{"LT", "LT-01001", "LT-01001", false},
+		{"PT", "1000-001", "1000-001", false},
+		{"PT", "1000001", "", true},
+		{"US", "10001-1234", "10001-1234", false},
+		{"US", "1000", "", true},
+		{"CA", "k1a0b1", "K1A0B1", false},
+		{"KZ", "a10b1c1", "A10B1C1", false},
+		{"AZ", "az 1000", "AZ 1000", false},
+		{"AZ", "1000", "1000", false},
+		{"IE", "d02 x285", "D02 X285", false},
+		{"ME", "91000", "", true},
+		// there are no postal codes, anything goes
+		{"AE", "whatever", "WHATEVER", false},
+	} {
+		t.Run(tt.country+" "+tt.code, func(t *testing.T) {
+			got, err := Lookup(tt.country).PostalCode(tt.code)
+
+			switch {
+			case tt.wantErr && err == nil:
+				t.Fatalf("%q is taken as %q", tt.code, got)
+			case !tt.wantErr && (err != nil || got != tt.want):
+				t.Fatalf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	full := func(country string) Address {
+		return Address{
+			Recipient: "Ivan Petrov", Street: "Main st 1", City: "Town", Region: "Shire", PostalCode: "12345",
+			Country: country,
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		a    Address
+		want []string
+	}{
+		{"RU", full("RU"), []string{"Ivan Petrov", "Main st 1", "Town", "Shire", "12345", "RUSSIA"}},
+		{"lower case code", full("ru"), []string{"Ivan Petrov", "Main st 1", "Town", "Shire", "12345", "RUSSIA"}},
+		{"KZ starts with postal code", full("KZ"), []string{"12345", "Shire", "Town", "Main st 1", "Ivan Petrov", "KAZAKHSTAN"}},
+		{"HU has city before street", full("HU"), []string{"Ivan Petrov", "Town", "Main st 1", "12345", "HUNGARY"}},
+		{"US", full("US"), []string{"Ivan Petrov", "Main st 1", "Town, Shire 12345", "UNITED STATES"}},
+		{"TR", full("TR"), []string{"Ivan Petrov", "Main st 1", "12345 Town/Shire", "TURKEY"}},
+		{"TR without region", Address{Street: "Main st 1", City: "Town", PostalCode: "12345", Country: "TR"},
+			[]string{"Main st 1", "12345 Town", "TURKEY"}},
+		{"TR without city", Address{Street: "Main st 1", Region: "Shire", Country: "TR"},
+			[]string{"Main st 1", "Shire", "TURKEY"}},
+		{"TR with postal code only", Address{Street: "Main st 1", PostalCode: "12345", Country: "TR"},
+			[]string{"Main st 1", "12345", "TURKEY"}},
+		{"LV", full("LV"), []string{"Ivan Petrov", "Main st 1", "Town, 12345", "LATVIA"}},
+		{"LV without postal code", Address{Street: "Main st 1", City: "Town", Country: "LV"},
+			[]string{"Main st 1", "Town", "LATVIA"}},
+		{"LV without city", Address{Street: "Main st 1", PostalCode: "LV-1050", Country: "LV"},
+			[]string{"Main st 1", "LV-1050", "LATVIA"}},
+		{"BR without region", Address{Street: "Main st 1", City: "Town", Country: "BR"},
+			[]string{"Main st 1", "Town", "BRAZIL"}},
+		{"spaces are squeezed", Address{Street: "  Main   st 1 ", City: "Town", Country: "DE"},
+			[]string{"Main st 1", "Town", "GERMANY"}},
+		{"unknown country", full("XX"), []string{"Ivan Petrov", "Main st 1", "12345 Town", "Shire", "XX"}},
+		{"no country", Address{Recipient: "Ivan Petrov", City: "Town"}, []string{"Ivan Petrov", "Town"}},
+		{"empty", Address{}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(tt.a); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lines are %q, want %q", got, tt.want)
+			}
+
+			if got, want := Line(tt.a), strings.Join(tt.want, ", "); got != want {
+				t.Fatalf("line is %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package postcards
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+type memoryStore struct {
+	// postcards are ordered by ID
+	postcards []*model.Postcard
+	sync.Mutex
+}
+
+// NewMemoryStore returns process-local PostcardStore, postcards are lost on restart.
+func NewMemoryStore() PostcardStore {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Create(_ context.Context, p *model.Postcard) error {
+	m.Lock()
+	defer m.Unlock()
+
+	code := ""
+
+	for i := 0; i < codeAttempts && code == ""; i++ {
+		c := newCode()
+		if m.find(func(pc *model.Postcard) bool { return pc.Code == c }) == nil {
+			code = c
+		}
+	}
+
+	if code == "" {
+		return errNoCode
+	}
+
+	p.ID = int64(len(m.postcards) + 1)
+	p.Code = code
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = p.CreatedAt
+
+	c := *p
+	m.postcards = append(m.postcards, &c)
+
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, code string) (*model.Postcard, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.find(func(p *model.Postcard) bool { return p.Code == code }), nil
+}
+
+func (m *memoryStore) Last(_ context.Context, senderID int64, recipient string) (*model.Postcard, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.find(func(p *model.Postcard) bool { return p.SenderID == senderID && p.Recipient == recipient }), nil
+}
+
+func (m *memoryStore) Active(_ context.Context, senderID int64) ([]*model.Postcard, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var pp []*model.Postcard
+
+	for _, p := range m.postcards {
+		if p.SenderID == senderID && active(p) {
+			c := *p
+			pp = append(pp, &c)
+		}
+	}
+
+	return pp, nil
+}
+
+func (m *memoryStore) Involving(_ context.Context, senderID int64, recipient string) ([]*model.Postcard, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var pp []*model.Postcard
+
+	for _, p := range m.postcards {
+		if p.SenderID == senderID || p.Recipient == recipient {
+			c := *p
+			pp = append(pp, &c)
+		}
+	}
+
+	return pp, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, p *model.Postcard) error {
+	m.Lock()
+	defer m.Unlock()
+
+	p.UpdatedAt = time.Now()
+	c := *p
+	m.postcards[p.ID-1] = &c
+
+	return nil
+}
+
+func (m *memoryStore) Expire(_ context.Context, before time.Time) ([]*model.Postcard, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var pp []*model.Postcard
+
+	for _, p := range m.postcards {
+		if active(p) && p.CreatedAt.Before(before) {
+			p.Status = model.PostcardExpired
+			p.UpdatedAt = time.Now()
+
+			c := *p
+			pp = append(pp, &c)
+		}
+	}
+
+	return pp, nil
+}
+
+// find returns a copy of the latest postcard matching, must be called with the lock held.
+func (m *memoryStore) find(f func(p *model.Postcard) bool) *model.Postcard {
+	for i := len(m.postcards) - 1; i >= 0; i-- {
+		if f(m.postcards[i]) {
+			c := *m.postcards[i]
+
+			return &c
+		}
+	}
+
+	return nil
+}
+
+func active(p *model.Postcard) bool {
+	return p.Status == model.PostcardAssigned || p.Status == model.PostcardSent
+}
+
+func (m *memoryStore) Forget(_ context.Context, senderID int64, recipient string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, p := range m.postcards {
+		if p.SenderID == senderID {
+			p.Sender = model.ErasedTelegram
+			p.UpdatedAt = time.Now()
+		}
+
+		if p.Recipient == recipient {
+			p.Recipient = model.ErasedTelegram
+			p.UpdatedAt = time.Now()
+		}
+	}
+
+	return nil
+}
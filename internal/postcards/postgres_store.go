@@ -0,0 +1,139 @@
+package postcards
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// activeStatuses are statuses of postcards which are neither received nor expired
+var activeStatuses = []string{model.PostcardAssigned, model.PostcardSent}
+
+type postgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore returns PostcardStore backed by the `postcards` table.
+func NewPostgresStore(db *gorm.DB) PostcardStore {
+	return &postgresStore{db: db}
+}
+
+// Create relies on the unique index of codes: if the code is taken, e.g. by a postcard created concurrently,
+// another one is tried.
+func (p *postgresStore) Create(ctx context.Context, pc *model.Postcard) error {
+	for i := 0; i < codeAttempts; i++ {
+		pc.Code = newCode()
+
+		err := p.db.WithContext(ctx).Create(pc).Error
+		if err == nil {
+			return nil
+		}
+
+		if !p.duplicated(err) {
+			return xerrors.Errorf("creating postcard (code=%q): %w", pc.Code, err)
+		}
+	}
+
+	pc.Code = ""
+
+	return errNoCode
+}
+
+// duplicated reports whether err is a violation of a unique index.
+func (p *postgresStore) duplicated(err error) bool {
+	if t, ok := p.db.Dialector.(gorm.ErrorTranslator); ok {
+		err = t.Translate(err)
+	}
+
+	return xerrors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+func (p *postgresStore) Get(ctx context.Context, code string) (*model.Postcard, error) {
+	return p.last(ctx, "code = ?", code)
+}
+
+func (p *postgresStore) Last(ctx context.Context, senderID int64, recipient string) (*model.Postcard, error) {
+	return p.last(ctx, "sender_id = ? AND recipient = ?", senderID, recipient)
+}
+
+func (p *postgresStore) last(ctx context.Context, query string, args ...interface{}) (*model.Postcard, error) {
+	pp := []*model.Postcard{}
+	if err := p.db.WithContext(ctx).Where(query, args...).Order("id DESC").Limit(1).Find(&pp).Error; err != nil {
+		return nil, xerrors.Errorf("searching postcard: %w", err)
+	}
+
+	if len(pp) == 0 {
+		return nil, nil
+	}
+
+	return pp[0], nil
+}
+
+func (p *postgresStore) Active(ctx context.Context, senderID int64) ([]*model.Postcard, error) {
+	pp := []*model.Postcard{}
+
+	err := p.db.WithContext(ctx).
+		Where("sender_id = ? AND status IN ?", senderID, activeStatuses).Order("id").Find(&pp).Error
+	if err != nil {
+		return nil, xerrors.Errorf("listing postcards (sender_id=%d): %w", senderID, err)
+	}
+
+	return pp, nil
+}
+
+func (p *postgresStore) Involving(ctx context.Context, senderID int64, recipient string) ([]*model.Postcard, error) {
+	pp := []*model.Postcard{}
+
+	err := p.db.WithContext(ctx).Where("sender_id = ? OR recipient = ?", senderID, recipient).Order("id").Find(&pp).Error
+	if err != nil {
+		return nil, xerrors.Errorf("listing postcards (sender_id=%d, recipient=%q): %w", senderID, recipient, err)
+	}
+
+	return pp, nil
+}
+
+func (p *postgresStore) Save(ctx context.Context, pc *model.Postcard) error {
+	if err := p.db.WithContext(ctx).Save(pc).Error; err != nil {
+		return xerrors.Errorf("saving postcard (code=%q): %w", pc.Code, err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Expire(ctx context.Context, before time.Time) ([]*model.Postcard, error) {
+	pp := []*model.Postcard{}
+
+	err := p.db.WithContext(ctx).Model(&pp).Clauses(clause.Returning{}).
+		Where("status IN ? AND created_at < ?", activeStatuses, before).
+		Updates(map[string]interface{}{"status": model.PostcardExpired, "updated_at": time.Now()}).Error
+	if err != nil {
+		return nil, xerrors.Errorf("expiring postcards: %w", err)
+	}
+
+	return pp, nil
+}
+
+func (p *postgresStore) Forget(ctx context.Context, senderID int64, recipient string) error {
+	db := dbtx.DB(ctx, p.db)
+	now := time.Now()
+
+	err := db.Model(&model.Postcard{}).Where("sender_id = ?", senderID).
+		Updates(map[string]interface{}{"sender": model.ErasedTelegram, "updated_at": now}).Error
+	if err != nil {
+		return xerrors.Errorf("forgetting sender (sender_id=%d): %w", senderID, err)
+	}
+
+	err = db.Model(&model.Postcard{}).Where("recipient = ?", recipient).
+		Updates(map[string]interface{}{"recipient": model.ErasedTelegram, "updated_at": now}).Error
+	if err != nil {
+		return xerrors.Errorf("forgetting recipient (recipient=%q): %w", recipient, err)
+	}
+
+	return nil
+}
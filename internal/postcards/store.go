@@ -0,0 +1,57 @@
+// Package postcards keeps postcards on their way from senders to recipients.
+package postcards
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+)
+
+// codePrefix starts every postcard code
+const codePrefix = "PB-"
+
+// codeAttempts is how many codes are generated before giving up, if they all are taken
+const codeAttempts = 10
+
+// PostcardStore keeps postcards by their codes.
+type PostcardStore interface {
+	// Create saves a new postcard with a new unique code.
+	Create(ctx context.Context, p *model.Postcard) error
+	// Get returns the postcard by code, nil if there is no such postcard.
+	Get(ctx context.Context, code string) (*model.Postcard, error)
+	// Last returns the latest postcard from the sender to the recipient, nil if there is none.
+	Last(ctx context.Context, senderID int64, recipient string) (*model.Postcard, error)
+	// Active returns postcards of the sender which are neither received nor expired, the oldest first.
+	Active(ctx context.Context, senderID int64) ([]*model.Postcard, error)
+	// Involving returns postcards from the sender or to the recipient, the oldest first.
+	Involving(ctx context.Context, senderID int64, recipient string) ([]*model.Postcard, error)
+	Save(ctx context.Context, p *model.Postcard) error
+	// Expire marks postcards created before the time and not received yet as expired and returns them.
+	Expire(ctx context.Context, before time.Time) ([]*model.Postcard, error)
+	// Forget replaces the nick of the participant with model.ErasedTelegram in postcards from the sender's chat
+	// and to the recipient, postcards on their way can still be received.
+	Forget(ctx context.Context, senderID int64, recipient string) error
+}
+
+// ParseCode returns the code as it's stored, e.g. "pb 12345" and "12345" are "PB-12345".
+func ParseCode(s string) string {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "PB"), "-")
+
+	return codePrefix + s
+}
+
+// newCode generates a postcard code, it may be taken already, stores try another one then.
+// It's a variable to make codes collide in tests.
+var newCode = func() string {
+	return codePrefix + strconv.Itoa(10000+rand.Intn(90000))
+}
+
+// errNoCode is returned by Create when every generated code was taken
+var errNoCode = xerrors.Errorf("no free code in %d attempts", codeAttempts)
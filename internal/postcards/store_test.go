@@ -0,0 +1,69 @@
+package postcards
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/dbtest"
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+)
+
+// codes makes newCode return the codes in order, the last one over and over
+func codes(t *testing.T, cc ...string) {
+	t.Helper()
+
+	prev := newCode
+	t.Cleanup(func() { newCode = prev })
+
+	newCode = func() string {
+		c := cc[0]
+		if len(cc) > 1 {
+			cc = cc[1:]
+		}
+
+		return c
+	}
+}
+
+func TestCreateRetriesTakenCode(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) PostcardStore{
+		"memory":   func(*testing.T) PostcardStore { return NewMemoryStore() },
+		"postgres": func(t *testing.T) PostcardStore { return NewPostgresStore(dbtest.Open(t)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			postcard := func() *model.Postcard {
+				return &model.Postcard{SenderID: 1, Recipient: "bob", Status: model.PostcardAssigned}
+			}
+
+			codes(t, "PB-11111", "PB-11111", "PB-22222", "PB-22222")
+
+			first := postcard()
+			if err := s.Create(ctx, first); err != nil {
+				t.Fatalf("creating first postcard: %v", err)
+			}
+
+			second := postcard()
+			if err := s.Create(ctx, second); err != nil {
+				t.Fatalf("creating second postcard: %v", err)
+			}
+
+			if first.Code != "PB-11111" || second.Code != "PB-22222" {
+				t.Fatalf("codes are %q and %q", first.Code, second.Code)
+			}
+
+			if p, err := s.Get(ctx, "PB-22222"); err != nil || p == nil || p.ID != second.ID {
+				t.Fatalf("getting second postcard: %+v, %v", p, err)
+			}
+
+			// every code is taken
+			if err := s.Create(ctx, postcard()); !xerrors.Is(err, errNoCode) {
+				t.Fatalf("error is %v, want %v", err, errNoCode)
+			}
+		})
+	}
+}
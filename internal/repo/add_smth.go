@@ -4,114 +4,112 @@ import (
 	"context"
 
 	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/postal"
 
 	"golang.org/x/xerrors"
 )
 
-func AddAddress(ctx context.Context, tg, address string) error {
-	tg = prepareTelegram(tg)
-
-	a, err := globalRepo.getOneAddress(ctx, tg)
-	if err != nil {
-		return xerrors.Errorf("searching address in DB: %w", err)
-	}
-
-	a.Address = address
-
-	if err = globalRepo.upsertAddress(ctx, a); err != nil {
-		return xerrors.Errorf("upserting address: %w", err)
-	}
-
-	cache.Add(a)
+// AddAddress sets the address written in one line, parts of the structured address are dropped.
+func AddAddress(ctx context.Context, s AddressStore, tg, address string) error {
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		setAddress(a, &model.Address{Address: address})
+	})
+}
 
-	return nil
+// AddPostalAddress sets the structured address from the parts of f.
+func AddPostalAddress(ctx context.Context, s AddressStore, tg string, f *model.Address) error {
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		setAddress(a, f)
+	})
 }
 
-func AddInstagram(ctx context.Context, tg, instagram string) error {
-	tg = prepareTelegram(tg)
+func AddInstagram(ctx context.Context, s AddressStore, tg, instagram string) error {
 	instagram = prepareInstagram(instagram)
 
-	a, err := globalRepo.getOneAddress(ctx, tg)
-	if err != nil {
-		return xerrors.Errorf("searching address in DB: %w", err)
-	}
-
-	a.Instagram = instagram
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		a.Instagram = instagram
+	})
+}
 
-	if err = globalRepo.upsertAddress(ctx, a); err != nil {
-		return xerrors.Errorf("upserting address: %w", err)
-	}
+func AddWishes(ctx context.Context, s AddressStore, tg, wishes string) error {
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		a.Wishes = wishes
+	})
+}
 
-	cache.Add(a)
+func AddPersonName(ctx context.Context, s AddressStore, tg, name string) error {
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		a.PersonName = name
+	})
+}
 
-	return nil
+// SetPrivacy sets who gets the address, see model.Privacy* modes.
+func SetPrivacy(ctx context.Context, s AddressStore, tg, privacy string) error {
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		a.Privacy = privacy
+	})
 }
 
-func AddWishes(ctx context.Context, tg, wishes string) error {
-	tg = prepareTelegram(tg)
+// AddFields sets all the fields user fills in at once, e.g. after registration.
+func AddFields(ctx context.Context, s AddressStore, tg string, f *model.Address) error {
+	instagram := prepareInstagram(f.Instagram)
+	phone := preparePhone(f.Phone)
+
+	return updateAddress(ctx, s, tg, func(a *model.Address) {
+		a.PersonName = f.PersonName
+		setAddress(a, f)
+		a.Instagram = instagram
+		a.Wishes = f.Wishes
+		a.Email = f.Email
+		a.Phone = phone
+	})
+}
 
-	a, err := globalRepo.getOneAddress(ctx, tg)
-	if err != nil {
-		return xerrors.Errorf("searching address in DB: %w", err)
+// PostalParts returns parts of the structured address, recipient is the line written on top.
+func PostalParts(a *model.Address, recipient string) postal.Address {
+	return postal.Address{
+		Recipient:  recipient,
+		Street:     a.Street,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
 	}
+}
 
-	a.Wishes = wishes
+// setAddress takes the address from f, structured address is rendered to the line.
+func setAddress(a, f *model.Address) {
+	a.RecipientLine = f.RecipientLine
+	a.Street = f.Street
+	a.City = f.City
+	a.Region = f.Region
+	a.PostalCode = f.PostalCode
+	a.Country = f.Country
+	a.Address = AddressLine(f)
+}
 
-	if err = globalRepo.upsertAddress(ctx, a); err != nil {
-		return xerrors.Errorf("upserting address: %w", err)
+// AddressLine returns the address in one line, structured address is rendered from its parts.
+func AddressLine(a *model.Address) string {
+	if !a.Structured() {
+		return a.Address
 	}
 
-	cache.Add(a)
-
-	return nil
+	return postal.Line(PostalParts(a, a.RecipientLine))
 }
 
-func AddPersonName(ctx context.Context, tg, name string) error {
-	tg = prepareTelegram(tg)
+func updateAddress(ctx context.Context, s AddressStore, tg string, update func(a *model.Address)) error {
+	tg = PrepareTelegram(tg)
 
-	a, err := globalRepo.getOneAddress(ctx, tg)
+	a, err := s.Get(ctx, tg)
 	if err != nil {
 		return xerrors.Errorf("searching address in DB: %w", err)
 	}
 
-	a.PersonName = name
+	update(a)
 
-	if err = globalRepo.upsertAddress(ctx, a); err != nil {
+	if err = s.Upsert(ctx, a); err != nil {
 		return xerrors.Errorf("upserting address: %w", err)
 	}
 
-	cache.Add(a)
-
-	return nil
-}
-
-func (r *Repo) getOneAddress(ctx context.Context, tg string) (*model.Address, error) {
-	tg = prepareTelegram(tg)
-
-	aa := []*model.Address{}
-	if err := r.WithContext(ctx).Find(&aa, "telegram = ?", tg).Error; err != nil {
-		return nil, err
-	}
-
-	if len(aa) > 1 {
-		return nil, xerrors.Errorf("found to many (%d) addresses", len(aa))
-	}
-
-	if len(aa) == 1 {
-		return aa[0], nil
-	}
-
-	return &model.Address{Telegram: tg}, nil
-}
-
-func (r *Repo) upsertAddress(ctx context.Context, a *model.Address) error {
-	if err := r.WithContext(ctx).Save(a).Error; err != nil {
-		return xerrors.Errorf("upserting address: %w", err)
-	}
-
-	if err := r.pushAddressToGoogleTable(a); err != nil {
-		return xerrors.Errorf("pushing address to Google table: %w", err)
-	}
-
 	return nil
 }
@@ -0,0 +1,309 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+// Address fields which can be mapped to table columns.
+const (
+	FieldTelegram   = "telegram"
+	FieldInstagram  = "instagram"
+	FieldPersonName = "person_name"
+	FieldAddress    = "address"
+	FieldWishes     = "wishes"
+	FieldApproved   = "approved"
+	FieldEmail      = "email"
+	FieldPhone      = "phone"
+	// FieldUpdatedAt is optional, but without it the sync can't know when a row was edited in the table.
+	FieldUpdatedAt = "updated_at"
+)
+
+// fields are in the order columns are created in a new table
+var fields = []string{
+	FieldTelegram, FieldInstagram, FieldPersonName, FieldAddress, FieldWishes, FieldApproved, FieldEmail, FieldPhone,
+	FieldUpdatedAt,
+}
+
+// requiredFields must have a column, otherwise the table can't be synced
+var requiredFields = []string{FieldTelegram, FieldAddress}
+
+// ColumnNames maps an address field to header names the column can have, case-insensitive.
+// The first name is used when a new table is created.
+type ColumnNames map[string][]string
+
+// DefaultColumnNames are used for fields not set in config.
+var DefaultColumnNames = ColumnNames{
+	FieldTelegram:   {"Телеграм", "Telegram"},
+	FieldInstagram:  {"Инстаграмм", "Инстаграм", "Instagram"},
+	FieldPersonName: {"Имя и фамилия", "ФИО", "Name", "Full name"},
+	FieldAddress:    {"Адрес", "Address"},
+	FieldWishes:     {"Пожелания", "Wishes"},
+	FieldApproved:   {"Одобрено", "Approved"},
+	FieldEmail:      {"Почта", "Email", "E-mail"},
+	FieldPhone:      {"Телефон", "Phone"},
+	FieldUpdatedAt:  {"Изменено", "Updated at"},
+}
+
+// ParseColumnNames merges config values like {"telegram": "Ник,Telegram"} with DefaultColumnNames.
+func ParseColumnNames(cfg map[string]string) (ColumnNames, error) {
+	names := make(ColumnNames, len(DefaultColumnNames))
+	for f, nn := range DefaultColumnNames {
+		names[f] = nn
+	}
+
+	for f, s := range cfg {
+		if _, ok := DefaultColumnNames[f]; !ok {
+			return nil, xerrors.Errorf("unknown field %q, known fields: %s", f, strings.Join(fields, ", "))
+		}
+
+		var nn []string
+
+		for _, n := range strings.Split(s, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				nn = append(nn, n)
+			}
+		}
+
+		if len(nn) == 0 {
+			return nil, xerrors.Errorf("no column names for field %q", f)
+		}
+
+		names[f] = nn
+	}
+
+	return names, nil
+}
+
+// header describes where the fields are in a table.
+type header struct {
+	// row is index of the header row
+	row     int
+	columns map[string]int
+	width   int
+}
+
+// newHeader returns header for a table which doesn't exist yet.
+func newHeader(names ColumnNames) (*header, []string) {
+	h := &header{columns: make(map[string]int, len(fields))}
+	row := make([]string, 0, len(fields))
+
+	for _, f := range fields {
+		h.columns[f] = len(row)
+		row = append(row, names[f][0])
+	}
+
+	h.width = len(row)
+
+	return h, row
+}
+
+// parseHeader finds the header row among the first rows and maps its columns to fields.
+func parseHeader(rows [][]string, names ColumnNames) (*header, error) {
+	byName := make(map[string]string)
+
+	for f, nn := range names {
+		for _, n := range nn {
+			byName[strings.ToLower(n)] = f
+		}
+	}
+
+	for i, row := range rows {
+		h := &header{row: i, columns: make(map[string]int), width: len(row)}
+
+		for j, cell := range row {
+			f, ok := byName[strings.ToLower(strings.TrimSpace(cell))]
+			if !ok {
+				continue
+			}
+
+			if _, dup := h.columns[f]; dup {
+				return nil, xerrors.Errorf("header row %d has two columns for %s", i+1, f)
+			}
+
+			h.columns[f] = j
+		}
+
+		if _, ok := h.columns[FieldTelegram]; !ok {
+			continue
+		}
+
+		for _, f := range requiredFields {
+			if _, ok := h.columns[f]; !ok {
+				return nil, xerrors.Errorf("header row %d has no column for %s, expected one of %q", i+1, f, names[f])
+			}
+		}
+
+		return h, nil
+	}
+
+	return nil, xerrors.Errorf("header row not found: no row has a column for telegram, expected one of %q",
+		names[FieldTelegram])
+}
+
+func (h *header) cell(row []string, field string) string {
+	i, ok := h.columns[field]
+	if !ok || i >= len(row) {
+		return ""
+	}
+
+	return strings.TrimSpace(row[i])
+}
+
+// toAddress returns nil for empty rows.
+func (h *header) toAddress(row []string) *model.Address {
+	a := &model.Address{
+		Telegram:   PrepareTelegram(h.cell(row, FieldTelegram)),
+		Instagram:  prepareInstagram(h.cell(row, FieldInstagram)),
+		PersonName: h.cell(row, FieldPersonName),
+		Address:    h.cell(row, FieldAddress),
+		Wishes:     h.cell(row, FieldWishes),
+		Approved:   parseBool(h.cell(row, FieldApproved)),
+		Email:      h.cell(row, FieldEmail),
+		Phone:      preparePhone(h.cell(row, FieldPhone)),
+	}
+
+	a.UpdatedAt = parseTime(h.cell(row, FieldUpdatedAt))
+
+	if a.Telegram == "" {
+		return nil
+	}
+
+	return a
+}
+
+// fill writes address fields into the row, keeping columns which are not mapped to fields.
+func (h *header) fill(row []string, a *model.Address) []string {
+	res := make([]string, h.width)
+	copy(res, row)
+
+	if len(row) > len(res) {
+		res = append(res, row[len(res):]...)
+	}
+
+	approved := "нет"
+	if a.Approved {
+		approved = "да"
+	}
+
+	values := map[string]string{
+		FieldTelegram:   a.Telegram,
+		FieldInstagram:  a.Instagram,
+		FieldPersonName: a.PersonName,
+		FieldAddress:    a.Address,
+		FieldWishes:     a.Wishes,
+		FieldApproved:   approved,
+		FieldEmail:      a.Email,
+		FieldPhone:      a.Phone,
+		FieldUpdatedAt:  a.UpdatedAt.UTC().Format(timeLayouts[0]),
+	}
+
+	for f, i := range h.columns {
+		res[i] = values[f]
+	}
+
+	return res
+}
+
+// spans returns mapped columns grouped into contiguous [from, to] ranges.
+func (h *header) spans() [][2]int {
+	cols := make([]int, 0, len(h.columns))
+	for _, i := range h.columns {
+		cols = append(cols, i)
+	}
+
+	sort.Ints(cols)
+
+	var res [][2]int
+
+	for _, i := range cols {
+		if n := len(res); n > 0 && res[n-1][1] == i-1 {
+			res[n-1][1] = i
+
+			continue
+		}
+
+		res = append(res, [2]int{i, i})
+	}
+
+	return res
+}
+
+// findRow returns index of the row with the given telegram or -1.
+func (h *header) findRow(rows [][]string, tg string) int {
+	for i := h.row + 1; i < len(rows); i++ {
+		if PrepareTelegram(h.cell(rows[i], FieldTelegram)) == tg {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// timeLayouts are layouts updated_at column is parsed with, the first one is used to write it
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+	"02.01.2006 15:04:05",
+	"1/2/2006 15:04:05",
+}
+
+// parseTime returns zero time if the cell is empty or can't be parsed. Time without zone is UTC.
+func parseTime(s string) time.Time {
+	for _, l := range timeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// syncHash is a hash of the fields which are synced with a table.
+func syncHash(a *model.Address) string {
+	approved := "0"
+	if a.Approved {
+		approved = "1"
+	}
+
+	h := sha256.New()
+	for _, v := range []string{
+		a.Telegram, a.Instagram, a.PersonName, a.Address, a.Wishes, approved, a.Email, a.Phone,
+	} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// rowsToAddresses parses table rows below the header.
+func rowsToAddresses(rows [][]string, names ColumnNames) ([]*model.Address, error) {
+	h, err := parseHeader(rows, names)
+	if err != nil {
+		return nil, err
+	}
+
+	persons := []*model.Address{}
+
+	for i := h.row + 1; i < len(rows); i++ {
+		a := h.toAddress(rows[i])
+		if a == nil {
+			log.Debug().Int("row", i+1).Msg("Skipping row without telegram")
+
+			continue
+		}
+
+		persons = append(persons, a)
+	}
+
+	return persons, nil
+}
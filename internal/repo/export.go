@@ -0,0 +1,30 @@
+package db
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ExportCSV writes all addresses in the same columns a new sync table gets.
+func ExportCSV(ctx context.Context, s AddressStore, names ColumnNames, w io.Writer) error {
+	aa, err := s.List(ctx)
+	if err != nil {
+		return xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	h, row := newHeader(names)
+	rows := [][]string{row}
+
+	for _, a := range aa {
+		rows = append(rows, h.fill(nil, a))
+	}
+
+	if err := csv.NewWriter(w).WriteAll(rows); err != nil {
+		return xerrors.Errorf("writing CSV: %w", err)
+	}
+
+	return nil
+}
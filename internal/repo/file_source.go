@@ -0,0 +1,300 @@
+package db
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/xerrors"
+)
+
+// fileSource is a local CSV or XLSX file with the same columns as the Google spreadsheet.
+type fileSource struct {
+	path  string
+	xlsx  bool
+	names ColumnNames
+	sync.Mutex
+}
+
+// NewFileSource returns SyncSource backed by a local file, format is chosen by extension.
+// The file is created on the first push if it doesn't exist.
+func NewFileSource(path string, names ColumnNames) (SyncSource, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return &fileSource{path: path, names: names}, nil
+	case ".xlsx":
+		return &fileSource{path: path, xlsx: true, names: names}, nil
+	default:
+		return nil, xerrors.Errorf("unknown file format of %q, only .csv and .xlsx are supported", path)
+	}
+}
+
+func (f *fileSource) Load(context.Context) ([]*model.Address, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	rows, err := f.read()
+	if err != nil {
+		return nil, xerrors.Errorf("reading %q: %w", f.path, err)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	persons, err := rowsToAddresses(rows, f.names)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing %q: %w", f.path, err)
+	}
+
+	log.Info().Int("persons", len(persons)).Str("file", f.path).Msg("persons readed")
+
+	return persons, nil
+}
+
+func (f *fileSource) Push(_ context.Context, a *model.Address) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.xlsx {
+		return f.pushXLSX(a)
+	}
+
+	rows, err := f.read()
+	if err != nil {
+		return xerrors.Errorf("reading %q: %w", f.path, err)
+	}
+
+	h, rows, err := f.header(rows)
+	if err != nil {
+		return err
+	}
+
+	if i := h.findRow(rows, a.Telegram); i >= 0 {
+		rows[i] = h.fill(rows[i], a)
+	} else {
+		rows = append(rows, h.fill(nil, a))
+	}
+
+	if err := f.writeCSV(rows); err != nil {
+		return xerrors.Errorf("writing %q: %w", f.path, err)
+	}
+
+	return nil
+}
+
+func (f *fileSource) Remove(_ context.Context, tg string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.xlsx {
+		return f.removeXLSX(tg)
+	}
+
+	rows, err := f.read()
+	if err != nil {
+		return xerrors.Errorf("reading %q: %w", f.path, err)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	h, err := parseHeader(rows, f.names)
+	if err != nil {
+		return xerrors.Errorf("parsing %q: %w", f.path, err)
+	}
+
+	i := h.findRow(rows, tg)
+	if i < 0 {
+		return nil
+	}
+
+	if err := f.writeCSV(append(rows[:i], rows[i+1:]...)); err != nil {
+		return xerrors.Errorf("writing %q: %w", f.path, err)
+	}
+
+	return nil
+}
+
+// header parses header of the rows or, if there are no rows, creates it.
+func (f *fileSource) header(rows [][]string) (*header, [][]string, error) {
+	if len(rows) == 0 {
+		h, row := newHeader(f.names)
+
+		return h, [][]string{row}, nil
+	}
+
+	h, err := parseHeader(rows, f.names)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("parsing %q: %w", f.path, err)
+	}
+
+	return h, rows, nil
+}
+
+// read returns all rows or nothing if the file doesn't exist yet.
+func (f *fileSource) read() ([][]string, error) {
+	if f.xlsx {
+		return f.readXLSX()
+	}
+
+	file, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	return r.ReadAll()
+}
+
+func (f *fileSource) writeCSV(rows [][]string) error {
+	tmp := f.path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(file)
+	if err := w.WriteAll(rows); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path)
+}
+
+func (f *fileSource) readXLSX() ([][]string, error) {
+	file, err := excelize.OpenFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return file.GetRows(file.GetSheetName(0))
+}
+
+// pushXLSX updates only cells of the changed row, so formatting made by moderators is kept.
+func (f *fileSource) pushXLSX(a *model.Address) error {
+	file, err := excelize.OpenFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		file = excelize.NewFile()
+	} else if err != nil {
+		return xerrors.Errorf("opening %q: %w", f.path, err)
+	}
+
+	defer file.Close()
+
+	sheet := file.GetSheetName(0)
+
+	rows, err := file.GetRows(sheet)
+	if err != nil {
+		return xerrors.Errorf("reading %q: %w", f.path, err)
+	}
+
+	h, newRows, err := f.header(rows)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		if err := file.SetSheetRow(sheet, "A1", &newRows[0]); err != nil {
+			return xerrors.Errorf("writing header: %w", err)
+		}
+	}
+
+	rows = newRows
+
+	n := h.findRow(rows, a.Telegram)
+
+	var row []string
+	if n < 0 {
+		n = len(rows)
+		row = h.fill(nil, a)
+	} else {
+		row = h.fill(rows[n], a)
+	}
+
+	if err := file.SetSheetRow(sheet, "A"+strconv.Itoa(n+1), &row); err != nil {
+		return xerrors.Errorf("writing row %d: %w", n+1, err)
+	}
+
+	return f.saveXLSX(file)
+}
+
+// removeXLSX removes the row, so it doesn't stay empty.
+func (f *fileSource) removeXLSX(tg string) error {
+	file, err := excelize.OpenFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("opening %q: %w", f.path, err)
+	}
+
+	defer file.Close()
+
+	sheet := file.GetSheetName(0)
+
+	rows, err := file.GetRows(sheet)
+	if err != nil {
+		return xerrors.Errorf("reading %q: %w", f.path, err)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	h, err := parseHeader(rows, f.names)
+	if err != nil {
+		return xerrors.Errorf("parsing %q: %w", f.path, err)
+	}
+
+	n := h.findRow(rows, tg)
+	if n < 0 {
+		return nil
+	}
+
+	if err := file.RemoveRow(sheet, n+1); err != nil {
+		return xerrors.Errorf("removing row %d: %w", n+1, err)
+	}
+
+	return f.saveXLSX(file)
+}
+
+func (f *fileSource) saveXLSX(file *excelize.File) error {
+	tmp := f.path + ".tmp.xlsx"
+	if err := file.SaveAs(tmp); err != nil {
+		return xerrors.Errorf("saving %q: %w", tmp, err)
+	}
+
+	if err := os.Rename(tmp, f.path); err != nil {
+		return xerrors.Errorf("renaming %q: %w", tmp, err)
+	}
+
+	return nil
+}
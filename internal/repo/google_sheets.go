@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/sheets/v4"
+)
+
+// range concepts https://developers.google.com/sheets/api/guides/concepts#expandable-1
+const (
+	page      = "Лист1"
+	readRange = page + "!A:Z"
+)
+
+type googleSheets struct {
+	sheets        *sheets.Service
+	sheetID       string
+	names         ColumnNames
+	flushInterval time.Duration
+
+	// pending are addresses waiting to be written, only the last version of each,
+	// nil address means the row must be removed
+	pending map[string]*model.Address
+	// header and rows are the table layout at the last read, rows maps telegram to row index.
+	// nil header means the layout is unknown and must be read before writing.
+	header *header
+	rows   map[string]int
+	// generation counts changes of the rows made by the bot: appends and deletions,
+	// so Load knows the layout it read may be outdated
+	generation int
+	// pageID is the sheet ID of page, rows are deleted by it, nil until it's read
+	pageID *int64
+	sync.Mutex
+}
+
+// NewGoogleSheetsSource returns SyncSource backed by a spreadsheet.
+// Pushed addresses are queued and written every flushInterval by RunQueue.
+func NewGoogleSheetsSource(
+	ctx context.Context,
+	spreadsheetID string,
+	auth GoogleAuth,
+	names ColumnNames,
+	flushInterval time.Duration,
+) (SyncSource, error) {
+	srv, err := connectToGoogleSheetsService(ctx, auth)
+	if err != nil {
+		return nil, xerrors.Errorf("connecting to google sheets: %w", err)
+	}
+
+	return &googleSheets{
+		sheets:        srv,
+		sheetID:       spreadsheetID,
+		names:         names,
+		flushInterval: flushInterval,
+		pending:       make(map[string]*model.Address),
+	}, nil
+}
+
+func (g *googleSheets) Load(ctx context.Context) ([]*model.Address, error) {
+	g.Lock()
+	generation := g.generation
+	g.Unlock()
+
+	rows, err := g.readRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	persons, err := rowsToAddresses(rows, g.names)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing sheet %q: %w", g.sheetID, err)
+	}
+
+	g.loaded(generation, rows)
+
+	log.Info().Int("persons", len(persons)).Msg("persons readed")
+
+	return persons, nil
+}
+
+// loaded keeps the layout of the rows read at the generation, unless rows were changed since then.
+func (g *googleSheets) loaded(generation int, rows [][]string) {
+	g.Lock()
+	defer g.Unlock()
+
+	if generation != g.generation {
+		g.header = nil
+
+		return
+	}
+
+	g.setLayout(rows)
+}
+
+// setLayout indexes rows by telegram, must be called with the lock held.
+func (g *googleSheets) setLayout(rows [][]string) {
+	h, err := parseHeader(rows, g.names)
+	if err != nil {
+		g.header = nil
+
+		return
+	}
+
+	g.header = h
+	g.rows = make(map[string]int, len(rows))
+
+	for i := h.row + 1; i < len(rows); i++ {
+		tg := PrepareTelegram(h.cell(rows[i], FieldTelegram))
+		if _, ok := g.rows[tg]; tg != "" && !ok {
+			g.rows[tg] = i
+		}
+	}
+}
+
+func (g *googleSheets) readRows(ctx context.Context) ([][]string, error) {
+	resp, err := g.sheets.Spreadsheets.Values.Get(g.sheetID, readRange).Context(ctx).Do()
+	if err != nil {
+		return nil, xerrors.Errorf("retrieving data from sheet %q: %w", g.sheetID, err)
+	}
+
+	if len(resp.Values) == 0 {
+		return nil, xerrors.Errorf("no data found in spreadsheet %q", g.sheetID)
+	}
+
+	rows := make([][]string, len(resp.Values))
+
+	for i, rowColumns := range resp.Values {
+		rows[i] = make([]string, len(rowColumns))
+		for j, column := range rowColumns {
+			rows[i][j], _ = column.(string)
+		}
+	}
+
+	return rows, nil
+}
+
+func toInterfaces(ss []string) []interface{} {
+	res := make([]interface{}, len(ss))
+	for i := range ss {
+		res[i] = ss[i]
+	}
+
+	return res
+}
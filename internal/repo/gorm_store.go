@@ -0,0 +1,396 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/pii"
+
+	"golang.org/x/xerrors"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	poolSize        = 10
+	connMaxLifetime = time.Minute
+)
+
+// GormStore is AddressStore on top of SQL database.
+type GormStore struct {
+	*gorm.DB
+
+	// nameOp is an operator for case-insensitive substring search by person name
+	nameOp string
+	// cipher encrypts personal data, it's kept in plaintext if cipher is nil
+	cipher *pii.Cipher
+}
+
+var _ AddressStore = (*GormStore)(nil)
+
+// tombstone is left by an erased address
+type tombstone struct {
+	Telegram  string
+	CreatedAt time.Time
+}
+
+func (tombstone) TableName() string { return "address_tombstones" }
+
+func NewPostgresStore(postgresURL string) (*GormStore, error) {
+	pg := postgres.New(postgres.Config{DSN: postgresURL, PreferSimpleProtocol: true})
+
+	db, err := gorm.Open(pg)
+	if err != nil {
+		return nil, xerrors.Errorf("opening gorm connection: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, xerrors.Errorf("getting sql.DB from gorm.DB: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(poolSize)
+	sqlDB.SetMaxIdleConns(poolSize)
+	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+
+	return &GormStore{DB: db, nameOp: "ILIKE"}, nil
+}
+
+// NewSQLiteStore opens database file, it's enough for a small community.
+func NewSQLiteStore(path string) (*GormStore, error) {
+	db, err := gorm.Open(sqlite.Open(path))
+	if err != nil {
+		return nil, xerrors.Errorf("opening sqlite %q: %w", path, err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, xerrors.Errorf("getting sql.DB from gorm.DB: %w", err)
+	}
+
+	// sqlite doesn't like concurrent writers
+	sqlDB.SetMaxOpenConns(1)
+
+	// LIKE in sqlite is case-insensitive only for ASCII, the rest is done by filterByName
+	return &GormStore{DB: db, nameOp: "LIKE"}, nil
+}
+
+// Encrypt makes the store encrypt personal data with the cipher.
+// Data written before is still read, Reencrypt encrypts it.
+func (s *GormStore) Encrypt(c *pii.Cipher) {
+	s.cipher = c
+}
+
+// Close closes DB connection pool.
+func (s *GormStore) Close() error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return xerrors.Errorf("getting sql.DB from gorm.DB: %w", err)
+	}
+
+	if err := sqlDB.Close(); err != nil {
+		return xerrors.Errorf("closing DB: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) Get(ctx context.Context, tg string) (*model.Address, error) {
+	tg = PrepareTelegram(tg)
+
+	aa := []*model.Address{}
+	if err := s.WithContext(ctx).Find(&aa, "telegram = ?", tg).Error; err != nil {
+		return nil, err
+	}
+
+	if len(aa) > 1 {
+		return nil, xerrors.Errorf("found to many (%d) addresses", len(aa))
+	}
+
+	if len(aa) == 1 {
+		return aa[0], s.open(aa...)
+	}
+
+	return &model.Address{Telegram: tg}, nil
+}
+
+func (s *GormStore) Upsert(ctx context.Context, a *model.Address) error {
+	row, err := s.seal(a)
+	if err != nil {
+		return err
+	}
+
+	isNew := a.ID == 0
+	db := s.WithContext(ctx)
+
+	if isNew {
+		// deleted address with the same telegram is still in the table, it's brought back to life
+		db = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "telegram"}},
+			UpdateAll: true,
+		})
+	}
+
+	if err := db.Save(row).Error; err != nil {
+		return xerrors.Errorf("saving address: %w", err)
+	}
+
+	if isNew {
+		if err := s.WithContext(ctx).Where("telegram = ?", a.Telegram).Delete(&tombstone{}).Error; err != nil {
+			return xerrors.Errorf("removing tombstone (tg=%q): %w", a.Telegram, err)
+		}
+	}
+
+	a.Base = row.Base
+
+	return nil
+}
+
+func (s *GormStore) Delete(ctx context.Context, tg string) error {
+	tg = PrepareTelegram(tg)
+
+	if err := s.WithContext(ctx).Where("telegram = ?", tg).Delete(&model.Address{}).Error; err != nil {
+		return xerrors.Errorf("deleting address (tg=%q): %w", tg, err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) Erase(ctx context.Context, tg string) error {
+	tg = PrepareTelegram(tg)
+
+	err := dbtx.DB(ctx, s.DB).Transaction(func(tx *gorm.DB) error {
+		// deleted rows are kept, so personal data is wiped, even if the address was deleted before
+		err := tx.Unscoped().Model(&model.Address{}).Where("telegram = ?", tg).UpdateColumns(map[string]interface{}{
+			"instagram":         "",
+			"person_name":       "",
+			"address":           "",
+			"wishes":            "",
+			"email":             "",
+			"phone":             "",
+			"phone_index":       "",
+			"email_index":       "",
+			"moderation_reason": "",
+			"recipient_line":    "",
+			"street":            "",
+			"city":              "",
+			"region":            "",
+			"postal_code":       "",
+			"country":           "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("telegram = ?", tg).Delete(&model.Address{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tombstone{Telegram: tg}).Error
+	})
+	if err != nil {
+		return xerrors.Errorf("erasing address (tg=%q): %w", tg, err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) Erased(ctx context.Context, tg string) (bool, error) {
+	tg = PrepareTelegram(tg)
+
+	var n int64
+	if err := s.WithContext(ctx).Model(&tombstone{}).Where("telegram = ?", tg).Count(&n).Error; err != nil {
+		return false, xerrors.Errorf("looking for tombstone (tg=%q): %w", tg, err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *GormStore) MarkSynced(ctx context.Context, tg, hash string) error {
+	tg = PrepareTelegram(tg)
+
+	err := s.WithContext(ctx).Model(&model.Address{}).Where("telegram = ?", tg).UpdateColumn("sync_hash", hash).Error
+	if err != nil {
+		return xerrors.Errorf("marking address (tg=%q) synced: %w", tg, err)
+	}
+
+	return nil
+}
+
+// Search finds addresses by phone, email, telegram or instagram, if there are none, by a part of the name.
+// With encryption names are compared after every address is read and decrypted, so the search by name
+// takes the whole table, it's fine for the number of participants the bot has.
+func (s *GormStore) Search(ctx context.Context, req string) ([]*model.Address, error) {
+	if req == "" {
+		return nil, nil
+	}
+
+	phone := preparePhone(req)
+	tg := PrepareTelegram(req)
+	inst := prepareInstagram(req)
+
+	q := s.WithContext(ctx).Where("phone = ? OR email = ? OR telegram = ? OR instagram = ?", phone, req, tg, inst)
+	if s.cipher != nil {
+		// plaintext is still compared, as there may be addresses written before encryption was turned on
+		q = q.Or("phone_index = ? OR email_index = ?", s.cipher.Index(phone), s.cipher.Index(req))
+	}
+
+	aa := []*model.Address{}
+	if err := q.Find(&aa).Error; err != nil {
+		return nil, err
+	}
+
+	if len(aa) > 0 {
+		return aa, s.open(aa...)
+	}
+
+	// encrypted names can't be compared by DB, they are all compared after decryption
+	if s.cipher != nil {
+		all, err := s.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return filterByName(all, req), nil
+	}
+
+	if err := s.WithContext(ctx).Find(&aa, "person_name "+s.nameOp+" ? ESCAPE '\\'", "%"+escapeLike(req)+"%").Error; err != nil {
+		return nil, err
+	}
+
+	return filterByName(aa, req), nil
+}
+
+func (s *GormStore) Random(ctx context.Context, exclude, countries []string) (*model.Address, error) {
+	q := s.WithContext(ctx).Where("approved = ? AND address <> ''", true)
+	if len(exclude) > 0 {
+		q = q.Where("telegram NOT IN ?", exclude)
+	}
+
+	if len(countries) > 0 {
+		q = q.Where("country IN ?", countries)
+	}
+
+	aa := []*model.Address{}
+	if err := q.Order("RANDOM()").Limit(1).Find(&aa).Error; err != nil {
+		return nil, xerrors.Errorf("getting random address: %w", err)
+	}
+
+	if len(aa) == 0 {
+		return nil, nil
+	}
+
+	return aa[0], s.open(aa...)
+}
+
+func (s *GormStore) List(ctx context.Context) ([]*model.Address, error) {
+	aa := []*model.Address{}
+	if err := s.WithContext(ctx).Order("id").Find(&aa).Error; err != nil {
+		return nil, xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	return aa, s.open(aa...)
+}
+
+// Reencrypt encrypts personal data of all addresses with the current key, including deleted ones.
+// It's run after encryption is turned on or the key is rotated, it returns number of addresses.
+func (s *GormStore) Reencrypt(ctx context.Context) (int, error) {
+	if s.cipher == nil {
+		return 0, xerrors.Errorf("there are no encryption keys")
+	}
+
+	aa := []*model.Address{}
+	if err := s.WithContext(ctx).Unscoped().Order("id").Find(&aa).Error; err != nil {
+		return 0, xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	if err := s.open(aa...); err != nil {
+		return 0, err
+	}
+
+	for _, a := range aa {
+		row, err := s.seal(a)
+		if err != nil {
+			return 0, err
+		}
+
+		// updated_at isn't touched, the address isn't changed for sync
+		err = s.WithContext(ctx).Unscoped().Model(&model.Address{}).Where("id = ?", a.ID).UpdateColumns(map[string]interface{}{
+			"person_name":    row.PersonName,
+			"address":        row.Address,
+			"phone":          row.Phone,
+			"email":          row.Email,
+			"recipient_line": row.RecipientLine,
+			"street":         row.Street,
+			"city":           row.City,
+			"region":         row.Region,
+			"postal_code":    row.PostalCode,
+			"phone_index":    row.PhoneIndex,
+			"email_index":    row.EmailIndex,
+		}).Error
+		if err != nil {
+			return 0, xerrors.Errorf("saving address (tg=%q): %w", a.Telegram, err)
+		}
+	}
+
+	return len(aa), nil
+}
+
+// seal returns a copy of the address to be written to DB: personal data is encrypted
+// and blind indexes are set. The address itself is returned if there is no cipher.
+func (s *GormStore) seal(a *model.Address) (*model.Address, error) {
+	if s.cipher == nil {
+		return a, nil
+	}
+
+	row := *a
+	row.PhoneIndex = s.cipher.Index(a.Phone)
+	row.EmailIndex = s.cipher.Index(a.Email)
+
+	for _, f := range personal(&row) {
+		var err error
+		if *f, err = s.cipher.Encrypt(*f); err != nil {
+			return nil, xerrors.Errorf("encrypting address (tg=%q): %w", a.Telegram, err)
+		}
+	}
+
+	return &row, nil
+}
+
+// personal returns fields of the address which are encrypted, country isn't, as addresses are filtered by it.
+func personal(a *model.Address) []*string {
+	return []*string{
+		&a.PersonName, &a.Address, &a.Phone, &a.Email, &a.RecipientLine, &a.Street, &a.City, &a.Region, &a.PostalCode,
+	}
+}
+
+// open decrypts personal data of addresses read from DB.
+func (s *GormStore) open(aa ...*model.Address) error {
+	for _, a := range aa {
+		a.PhoneIndex, a.EmailIndex = "", ""
+
+		if s.cipher == nil {
+			continue
+		}
+
+		for _, f := range personal(a) {
+			var err error
+			if *f, err = s.cipher.Decrypt(*f); err != nil {
+				return xerrors.Errorf("decrypting address (tg=%q): %w", a.Telegram, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+var likeReplacer = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeReplacer.Replace(s)
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/dbtest"
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/pii"
+
+	"github.com/samber/lo"
+)
+
+// encrypted returns SQLite store encrypting personal data
+func encrypted(t *testing.T) *GormStore {
+	t.Helper()
+
+	c, err := pii.NewCipher(
+		[]string{"k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+		base64.StdEncoding.EncodeToString([]byte("index key")),
+	)
+	if err != nil {
+		t.Fatalf("creating cipher: %v", err)
+	}
+
+	s := &GormStore{DB: dbtest.Open(t), nameOp: "LIKE"}
+	s.Encrypt(c)
+
+	return s
+}
+
+func TestUpsertResurrectsDeleted(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		remove func(s *GormStore, ctx context.Context, tg string) error
+	}{
+		{"deleted", (*GormStore).Delete},
+		{"erased", (*GormStore).Erase},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := encrypted(t)
+
+			old := &model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow", Phone: "79990001122"}
+			if err := s.Upsert(ctx, old); err != nil {
+				t.Fatalf("saving address: %v", err)
+			}
+
+			if err := tt.remove(s, ctx, "alice"); err != nil {
+				t.Fatalf("removing address: %v", err)
+			}
+
+			a := &model.Address{Telegram: "alice", PersonName: "Alice L", Address: "Berlin"}
+			if err := s.Upsert(ctx, a); err != nil {
+				t.Fatalf("saving address again: %v", err)
+			}
+
+			if a.ID != old.ID {
+				t.Fatalf("address got ID %d, the deleted one has %d", a.ID, old.ID)
+			}
+
+			got, err := s.Get(ctx, "alice")
+			if err != nil {
+				t.Fatalf("getting address: %v", err)
+			}
+
+			// nothing is left from the deleted address
+			if got.ID != old.ID || got.PersonName != "Alice L" || got.Address != "Berlin" || got.Phone != "" {
+				t.Fatalf("address is %+v", got)
+			}
+
+			if erased, err := s.Erased(ctx, "alice"); err != nil || erased {
+				t.Fatalf("tombstone is left: %v, %v", erased, err)
+			}
+
+			if list, err := s.List(ctx); err != nil || len(list) != 1 {
+				t.Fatalf("addresses are %+v, %v", list, err)
+			}
+		})
+	}
+}
+
+func TestRandom(t *testing.T) {
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			for _, a := range []*model.Address{
+				{Telegram: "alice", Address: "Moscow", Country: "RU", Approved: true},
+				{Telegram: "bob", Address: "Berlin", Country: "DE", Approved: true},
+				{Telegram: "carol", Address: "Munich", Country: "DE", Approved: true},
+				{Telegram: "dave", Address: "Paris", Country: "FR"},
+				{Telegram: "erin", Country: "FR", Approved: true},
+			} {
+				if err := s.Upsert(ctx, a); err != nil {
+					t.Fatalf("saving %q: %v", a.Telegram, err)
+				}
+			}
+
+			for _, tt := range []struct {
+				name      string
+				exclude   []string
+				countries []string
+				// want are nicks which may be drawn, none means nil
+				want []string
+			}{
+				{"any", nil, nil, []string{"alice", "bob", "carol"}},
+				{"excluded", []string{"alice", "bob"}, nil, []string{"carol"}},
+				{"country", nil, []string{"DE"}, []string{"bob", "carol"}},
+				{"countries", []string{"bob"}, []string{"DE", "RU"}, []string{"alice", "carol"}},
+				{"not approved and without address", nil, []string{"FR"}, nil},
+				{"everyone excluded", []string{"alice", "bob", "carol"}, nil, nil},
+			} {
+				t.Run(tt.name, func(t *testing.T) {
+					drawn := map[string]bool{}
+
+					for i := 0; i < 50; i++ {
+						a, err := s.Random(ctx, tt.exclude, tt.countries)
+						if err != nil {
+							t.Fatalf("drawing: %v", err)
+						}
+
+						if a == nil {
+							drawn[""] = true
+						} else {
+							drawn[a.Telegram] = true
+						}
+					}
+
+					want := tt.want
+					if len(want) == 0 {
+						want = []string{""}
+					}
+
+					got := lo.Keys(drawn)
+					sort.Strings(got)
+
+					// 50 draws miss one of three addresses with negligible probability
+					if strings.Join(got, ",") != strings.Join(want, ",") {
+						t.Fatalf("drawn %q, want %q", got, want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestEncryptedSearch(t *testing.T) {
+	ctx := context.Background()
+	s := encrypted(t)
+
+	for _, a := range []*model.Address{
+		{Telegram: "alice", Instagram: "alice_insta", PersonName: "Алиса Иванова", Phone: "79990001122", Email: "alice@example.com"},
+		{Telegram: "bob", PersonName: "Bob Smith", Phone: "79990003344", Email: "bob@example.com"},
+	} {
+		if err := s.Upsert(ctx, a); err != nil {
+			t.Fatalf("saving %q: %v", a.Telegram, err)
+		}
+	}
+
+	// carol was added before encryption was turned on
+	plain := &GormStore{DB: s.DB, nameOp: "LIKE"}
+	if err := plain.Upsert(ctx, &model.Address{Telegram: "carol", PersonName: "Carol", Phone: "79990005566"}); err != nil {
+		t.Fatalf("saving plaintext address: %v", err)
+	}
+
+	var raw []map[string]interface{}
+	if err := s.DB.Table("addresses").Where("telegram IN ?", []string{"alice", "bob"}).Find(&raw).Error; err != nil {
+		t.Fatalf("reading rows: %v", err)
+	}
+
+	for _, row := range raw {
+		for _, col := range []string{"person_name", "phone", "email"} {
+			if v, _ := row[col].(string); !strings.HasPrefix(v, "enc1:") {
+				t.Fatalf("%s of %v is kept as %q", col, row["telegram"], v)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		req  string
+		want []string
+	}{
+		{"+7 (999) 000-11-22", []string{"alice"}},
+		{"89990003344", []string{"bob"}},
+		{"79990005566", []string{"carol"}},
+		{"alice@example.com", []string{"alice"}},
+		{"@Alice", []string{"alice"}},
+		{"https://instagram.com/alice_insta", []string{"alice"}},
+		{"иванова", []string{"alice"}},
+		{"Smith", []string{"bob"}},
+		{"carol", []string{"carol"}},
+		{"79990009999", nil},
+		{"enc1:", nil},
+	} {
+		t.Run(tt.req, func(t *testing.T) {
+			aa, err := s.Search(ctx, tt.req)
+			if err != nil {
+				t.Fatalf("searching: %v", err)
+			}
+
+			got := lo.Map(aa, func(a *model.Address, _ int) string { return a.Telegram })
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("found %q, want %q", got, tt.want)
+			}
+
+			for _, a := range aa {
+				if strings.HasPrefix(a.PersonName, "enc1:") || strings.HasPrefix(a.Phone, "enc1:") {
+					t.Fatalf("found address isn't decrypted: %q, %q", a.PersonName, a.Phone)
+				}
+			}
+		})
+	}
+}
@@ -21,7 +21,8 @@ func preparePhone(b string) string {
 	return b
 }
 
-func prepareTelegram(b string) string {
+// PrepareTelegram returns the nick the way addresses are kept: without @, t.me links and in lower case.
+func PrepareTelegram(b string) string {
 	b = strings.ReplaceAll(b, "@", "")
 	b = strings.ReplaceAll(b, " ", "")
 	b = strings.ReplaceAll(b, "http://t.me", "")
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+type memoryStore struct {
+	persons map[string]*model.Address
+	// erased are tombstones of erased addresses
+	erased map[string]bool
+	lastID int64
+	sync.RWMutex
+}
+
+var _ AddressStore = (*memoryStore)(nil)
+
+// NewMemoryStore returns AddressStore which keeps everything in memory.
+// It's useful for tests and for running the bot from a spreadsheet only.
+func NewMemoryStore() AddressStore {
+	return &memoryStore{persons: make(map[string]*model.Address), erased: make(map[string]bool)}
+}
+
+func (m *memoryStore) Get(_ context.Context, tg string) (*model.Address, error) {
+	tg = PrepareTelegram(tg)
+
+	m.RLock()
+	defer m.RUnlock()
+
+	if a, ok := m.persons[tg]; ok {
+		c := *a
+
+		return &c, nil
+	}
+
+	return &model.Address{Telegram: tg}, nil
+}
+
+func (m *memoryStore) Upsert(_ context.Context, a *model.Address) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.upsert(a)
+
+	return nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, tg string) error {
+	tg = PrepareTelegram(tg)
+
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.persons, tg)
+
+	return nil
+}
+
+// Erase forgets the address, nothing is kept in memory anyway.
+func (m *memoryStore) Erase(_ context.Context, tg string) error {
+	tg = PrepareTelegram(tg)
+
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.persons, tg)
+	m.erased[tg] = true
+
+	return nil
+}
+
+func (m *memoryStore) Erased(_ context.Context, tg string) (bool, error) {
+	tg = PrepareTelegram(tg)
+
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.erased[tg], nil
+}
+
+func (m *memoryStore) MarkSynced(_ context.Context, tg, hash string) error {
+	tg = PrepareTelegram(tg)
+
+	m.Lock()
+	defer m.Unlock()
+
+	if a, ok := m.persons[tg]; ok {
+		a.SyncHash = hash
+	}
+
+	return nil
+}
+
+func (m *memoryStore) upsert(a *model.Address) {
+	now := time.Now()
+
+	if old, ok := m.persons[a.Telegram]; ok {
+		a.ID = old.ID
+		a.CreatedAt = old.CreatedAt
+	} else {
+		m.lastID++
+		a.ID = m.lastID
+		a.CreatedAt = now
+	}
+
+	delete(m.erased, a.Telegram)
+
+	a.UpdatedAt = now
+
+	c := *a
+	m.persons[a.Telegram] = &c
+}
+
+func (m *memoryStore) Search(_ context.Context, req string) ([]*model.Address, error) {
+	if req == "" {
+		return nil, nil
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	var found, byName []*model.Address
+
+	for _, a := range m.sorted() {
+		if matchesExactly(a, req) {
+			found = append(found, a)
+		}
+
+		if strings.Contains(strings.ToLower(a.PersonName), strings.ToLower(req)) {
+			byName = append(byName, a)
+		}
+	}
+
+	if len(found) == 0 {
+		found = filterByName(byName, req)
+	}
+
+	return found, nil
+}
+
+func (m *memoryStore) Random(_ context.Context, exclude, countries []string) (*model.Address, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var tgs []string
+
+	for tg, a := range m.persons {
+		if drawable(a, exclude, countries) {
+			tgs = append(tgs, tg)
+		}
+	}
+
+	if len(tgs) == 0 {
+		return nil, nil
+	}
+
+	c := *m.persons[tgs[rand.Intn(len(tgs))]]
+
+	return &c, nil
+}
+
+func (m *memoryStore) List(_ context.Context) ([]*model.Address, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.sorted(), nil
+}
+
+// sorted returns copies of all addresses ordered by ID. Must be called under lock.
+func (m *memoryStore) sorted() []*model.Address {
+	aa := make([]*model.Address, 0, len(m.persons))
+	for _, a := range m.persons {
+		c := *a
+		aa = append(aa, &c)
+	}
+
+	sort.Slice(aa, func(i, j int) bool { return aa[i].ID < aa[j].ID })
+
+	return aa
+}
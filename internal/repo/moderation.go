@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+// Moderator decisions.
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+	DecisionChanges = "changes"
+)
+
+// Submit sends not approved address to moderators and remembers the chat to tell the user about the decision.
+// It returns the saved address.
+func Submit(ctx context.Context, s AddressStore, tg string, chatID int64) (*model.Address, error) {
+	var saved *model.Address
+
+	err := updateAddress(ctx, s, tg, func(a *model.Address) {
+		a.ChatID = chatID
+
+		if !a.Approved {
+			a.Moderation = model.ModerationPending
+			a.ModerationReason = ""
+		}
+
+		saved = a
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// Moderate saves moderator's decision about the address, reason is shown to the user.
+func Moderate(ctx context.Context, s AddressStore, tg, decision, reason string) (*model.Address, error) {
+	var status string
+
+	switch decision {
+	case DecisionApprove:
+	case DecisionReject:
+		status = model.ModerationRejected
+	case DecisionChanges:
+		status = model.ModerationChanges
+	default:
+		return nil, xerrors.Errorf("unknown decision %q", decision)
+	}
+
+	a, err := s.Get(ctx, PrepareTelegram(tg))
+	if err != nil {
+		return nil, xerrors.Errorf("searching address in DB: %w", err)
+	}
+
+	if a.ID == 0 {
+		return nil, xerrors.Errorf("there is no address of '%s'", tg)
+	}
+
+	a.Approved = decision == DecisionApprove
+	a.Moderation = status
+	a.ModerationReason = reason
+
+	if err := s.Upsert(ctx, a); err != nil {
+		return nil, xerrors.Errorf("upserting address: %w", err)
+	}
+
+	return a, nil
+}
+
+// Pending returns addresses waiting for moderators, the oldest first.
+func Pending(ctx context.Context, s AddressStore) ([]*model.Address, error) {
+	aa, err := s.List(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	return lo.Filter(aa, func(a *model.Address, _ int) bool {
+		return !a.Approved && a.Moderation == model.ModerationPending
+	}), nil
+}
+
+// Count returns how many addresses there are: all, approved and waiting for moderators.
+func Count(ctx context.Context, s AddressStore) (all, approved, pending int, err error) {
+	aa, err := s.List(ctx)
+	if err != nil {
+		return 0, 0, 0, xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	for _, a := range aa {
+		switch {
+		case a.Approved:
+			approved++
+		case a.Moderation == model.ModerationPending:
+			pending++
+		}
+	}
+
+	return len(aa), approved, pending, nil
+}
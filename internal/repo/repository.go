@@ -2,129 +2,106 @@ package db
 
 import (
 	"context"
-	"strings"
-	"time"
+	"sync"
 
-	model2 "github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/model"
 
-	"github.com/samber/lo"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/xerrors"
-	"google.golang.org/api/sheets/v4"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
+// Repo is AddressStore which mirrors every upserted address to the SyncSource.
 type Repo struct {
-	*gorm.DB
-	sheets  *sheets.Service
-	sheetID string
+	AddressStore
+	source SyncSource
+	policy string
+
+	// approved is called when an address is approved in the table
+	approved func(ctx context.Context, a *model.Address)
+	// created is called when a new address which isn't approved is taken from the table
+	created func(ctx context.Context, a *model.Address)
+	sync.Mutex
+
+	// syncMu makes sync runs sequential, a run can be triggered while the updater is running one
+	syncMu sync.Mutex
 }
 
-var globalRepo *Repo
+// OnApproved sets f to be called when a moderator approves an address in the table, not via bot.
+func (r *Repo) OnApproved(f func(ctx context.Context, a *model.Address)) {
+	r.Lock()
+	defer r.Unlock()
 
-const (
-	poolSize        = 10
-	connMaxLifetime = time.Minute
-)
+	r.approved = f
+}
 
-func initGlobalDB(ctx context.Context, postgresURL, spreadsheetID string) (*Repo, error) {
-	pg := postgres.New(postgres.Config{DSN: postgresURL, PreferSimpleProtocol: true})
-	db, err := gorm.Open(pg)
-	if err != nil {
-		return nil, xerrors.Errorf("opening form connection: %w", err)
-	}
+// OnCreated sets f to be called when a new address, which moderators haven't approved yet, is taken from the table.
+func (r *Repo) OnCreated(f func(ctx context.Context, a *model.Address)) {
+	r.Lock()
+	defer r.Unlock()
 
-	sqlDB, err := db.DB()
-	if err != nil {
-		return nil, xerrors.Errorf("getting sql.DB from gorm.DB: %w", err)
-	}
+	r.created = f
+}
 
-	sqlDB.SetMaxOpenConns(poolSize)
-	sqlDB.SetMaxIdleConns(poolSize)
-	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+// setApproved takes approval from the table and calls OnApproved function if the address got approved.
+// It must be called before the address is saved, the function is called after that.
+func (r *Repo) setApproved(a *model.Address, approved bool) (notify func(ctx context.Context)) {
+	got := approved && !a.Approved
+	a.Approved = approved
 
-	googleSheets, err := connectToGoogleSheetsService(ctx)
-	if err != nil {
-		return nil, xerrors.Errorf("connecting to google sheets: %w", err)
+	if a.Approved {
+		a.Moderation = ""
+		a.ModerationReason = ""
 	}
 
-	globalRepo = &Repo{
-		DB:      db,
-		sheets:  googleSheets,
-		sheetID: spreadsheetID,
+	r.Lock()
+	f := r.approved
+	r.Unlock()
+
+	if !got || f == nil {
+		return func(context.Context) {}
 	}
 
-	return globalRepo, nil
+	c := *a
+
+	return func(ctx context.Context) { f(ctx, &c) }
 }
 
-func (r *Repo) findUser(userID string) (*model2.User, error) {
-	user := &model2.User{}
-	if err := r.Take(&user, "id = ?", userID).Error; err != nil {
-		return nil, err
+func (r *Repo) Upsert(ctx context.Context, a *model.Address) error {
+	if err := r.AddressStore.Upsert(ctx, a); err != nil {
+		return xerrors.Errorf("upserting address: %w", err)
 	}
 
-	return user, nil
-}
-
-func (r *Repo) upsertAddresses(addresses []*model2.Address) error {
-	if len(addresses) == 0 {
+	if !r.syncing() {
 		return nil
 	}
 
-	return r.Clauses(
-		clause.OnConflict{
-			Columns:   []clause.Column{{Name: "telegram"}},
-			Where:     clause.Where{Exprs: []clause.Expression{gorm.Expr("addresses.deleted_at IS NULL")}},
-			UpdateAll: true,
-		},
-	).Create(addresses).Error
-}
-
-func (r *Repo) searchAddress(req string) ([]*model2.Address, error) {
-	if req == "" {
-		return nil, nil
+	// the table is just a mirror, the address is already saved, so user shouldn't see an error,
+	// the next sync run pushes it again
+	if err := r.push(ctx, a); err != nil {
+		log.Error().Err(err).Str("telegram", a.Telegram).Msg("pushing address to sync source")
 	}
 
-	phone := preparePhone(req)
-	tg := prepareTelegram(req)
-	inst := prepareInstagram(req)
+	return nil
+}
 
-	aa := []*model2.Address{}
-	if err := r.Find(&aa,
-		"phone = ? OR email = ? OR telegram = ? OR instagram = ?",
-		phone, req, tg, inst).Error; err != nil {
-		return nil, err
+// Erase erases the address and removes it from the sync source.
+func (r *Repo) Erase(ctx context.Context, tg string) error {
+	if err := r.AddressStore.Erase(ctx, tg); err != nil {
+		return xerrors.Errorf("erasing address: %w", err)
 	}
 
-	if len(aa) == 0 {
-		if err := r.Find(&aa, "person_name ~* ?", req).Error; err != nil {
-			return nil, err
-		}
-
-		lo.Filter(aa, func(a *model2.Address, _ int) bool {
-			if a.PersonName == "" {
-				return false
-			}
-
-			if strings.EqualFold(a.PersonName, req) {
-				return true
-			}
-
-			ss := strings.Split(a.PersonName, " ")
-			for _, s := range ss {
-				if len(s) < 2 {
-					continue
-				}
-
-				if strings.EqualFold(s, req) {
-					return true
-				}
-			}
-
-			return false
-		})
+	// the tombstone keeps the row from being taken back, the next sync run removes it again
+	if err := r.source.Remove(ctx, tg); err != nil {
+		log.Error().Err(err).Str("telegram", tg).Msg("removing address from sync source")
 	}
 
-	return aa, nil
+	return nil
+}
+
+// syncing reports whether there is a sync source at all.
+// Without it, addresses must not be marked synced, or they'd be deleted when a source is added.
+func (r *Repo) syncing() bool {
+	_, ok := r.source.(noSync)
+
+	return !ok
 }
@@ -2,17 +2,15 @@ package db
 
 import (
 	"context"
-	"math/rand"
 
 	"github.com/grbit/post_bot/internal/model"
 
-	"github.com/rs/zerolog/log"
-	"golang.org/x/exp/maps"
+	"github.com/samber/lo"
 	"golang.org/x/xerrors"
 )
 
-func FindByTg(ctx context.Context, tg string) (*model.Address, error) {
-	addr, err := globalRepo.getOneAddress(ctx, tg)
+func FindByTg(ctx context.Context, s AddressStore, tg string) (*model.Address, error) {
+	addr, err := s.Get(ctx, tg)
 	if err != nil {
 		return nil, xerrors.Errorf("searching in DB: %w", err)
 	}
@@ -20,42 +18,41 @@ func FindByTg(ctx context.Context, tg string) (*model.Address, error) {
 	return addr, nil
 }
 
-func Search(req string) ([]string, error) {
+// Search finds addresses by the request, if countries are given, only addresses in them are returned.
+func Search(ctx context.Context, s AddressStore, req string, countries []string) ([]*model.Address, error) {
 	if req == "" {
 		return nil, nil
 	}
 
-	bb, err := globalRepo.searchAddress(req)
+	bb, err := s.Search(ctx, req)
 	if err != nil {
 		return nil, xerrors.Errorf("searching in DB: %w", err)
 	}
 
-	var res []string
-	for _, b := range bb {
-		res = append(res, b.String())
+	if len(countries) > 0 {
+		bb = lo.Filter(bb, func(a *model.Address, _ int) bool { return lo.Contains(countries, a.Country) })
 	}
 
-	return res, nil
+	return bb, nil
 }
 
-func Random() *model.Address {
-	cache.RLock()
-	defer cache.RUnlock()
-
-	log.Trace().
-		Interface("persons", cache.Persons).
-		Msg("Random call")
-
-	log.Debug().
-		Interface("len(persons)", len(cache.Persons)).
-		Msg("Random call")
+// Exact reports whether addresses are found by Search exactly by phone, email, telegram or instagram,
+// and not by name.
+func Exact(aa []*model.Address, req string) bool {
+	return len(aa) > 0 && matchesExactly(aa[0], req)
+}
 
-	tgs := maps.Keys(cache.Persons)
-	k := rand.Intn(len(tgs))
-	log.Debug().Str("random key", tgs[k]).Send()
+// Random returns address drawn by the strategy among those which can be sent a postcard, except addresses
+// of the excluded telegram nicks, e.g. the requester's own one and the ones they already got.
+// If countries are given, the address is drawn among those in them.
+// It returns nil if there is no such address.
+func Random(ctx context.Context, s AddressStore, st Strategy, exclude, countries []string) (*model.Address, error) {
+	exclude = lo.Map(exclude, func(tg string, _ int) string { return PrepareTelegram(tg) })
 
-	tg := tgs[k]
-	log.Debug().Str("random telegram", tg).Send()
+	a, err := st.Pick(ctx, s, exclude, countries)
+	if err != nil {
+		return nil, xerrors.Errorf("getting random address: %w", err)
+	}
 
-	return cache.Persons[tg]
+	return a, nil
 }
@@ -1,11 +1,15 @@
 package db
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
@@ -15,53 +19,183 @@ import (
 	"google.golang.org/api/sheets/v4"
 )
 
+// Google auth modes.
 const (
-	credsPath = "credentials.json"
-	tokenPath = "token.json"
+	// AuthOAuth uses OAuth client from credentials file and user token from token file.
+	// Create OAuth2 client ID and save downloaded JSON into credentials.json file in program working folder
+	// https://developers.google.com/workspace/guides/create-credentials#api-key
+	// The token is created by `post_bot auth` command.
+	AuthOAuth = "oauth"
+	// AuthServiceAccount uses service account JSON key from credentials file or env.
+	// Don't forget to share the spreadsheet with the service account email.
+	AuthServiceAccount = "service-account"
+	// AuthDefault uses application default credentials: GOOGLE_APPLICATION_CREDENTIALS,
+	// gcloud credentials or metadata server (workload identity).
+	AuthDefault = "default"
+	// AuthNone sends requests without credentials, it's for a local stand-in of the API set by Endpoint.
+	AuthNone = "none"
 )
 
-// Create OAuth2 client ID and save downloaded JSON into credentials.json file in program working folder
-// https://developers.google.com/workspace/guides/create-credentials#api-key
+// GoogleAuth describes how to authorize in Google API.
+type GoogleAuth struct {
+	Mode            string
+	CredentialsFile string
+	// CredentialsJSON is credentials file content, if set, CredentialsFile is not read.
+	CredentialsJSON string
+	TokenFile       string
+	// Endpoint overrides Sheets API base URL if set.
+	Endpoint string
+}
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tok, err := tokenFromFile(tokenPath)
-	if err != nil {
-		log.Info().Msg("No token found, getting token from web")
+const (
+	readScope      = "https://www.googleapis.com/auth/spreadsheets.readonly"
+	readWriteScope = "https://www.googleapis.com/auth/spreadsheets"
+)
 
-		tok, err = getTokenFromWeb(config)
+func connectToGoogleSheetsService(ctx context.Context, auth GoogleAuth) (*sheets.Service, error) {
+	var opt option.ClientOption
+
+	switch auth.Mode {
+	case AuthNone:
+		opt = option.WithoutAuthentication()
+	case AuthDefault:
+		creds, err := google.FindDefaultCredentials(ctx, readWriteScope)
+		if err != nil {
+			return nil, xerrors.Errorf("finding default credentials: %w", err)
+		}
+
+		opt = option.WithCredentials(creds)
+	case AuthServiceAccount:
+		b, err := auth.credentials()
 		if err != nil {
-			return nil, xerrors.Errorf("getting token from web: %w", err)
+			return nil, err
 		}
 
-		if err := saveToken(tokenPath, tok); err != nil {
-			return nil, xerrors.Errorf("saving token: %w", err)
+		creds, err := google.CredentialsFromJSON(ctx, b, readWriteScope)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing service account key: %w", err)
 		}
-	} else {
-		log.Info().Str("path", tokenPath).Msg("token loadded from file")
+
+		opt = option.WithCredentials(creds)
+	default:
+		config, err := auth.oauthConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		tok, err := tokenFromFile(auth.TokenFile)
+		if err != nil {
+			return nil, xerrors.Errorf("reading token from %q, run `post_bot auth` to get one: %w", auth.TokenFile, err)
+		}
+
+		log.Info().Str("path", auth.TokenFile).Msg("token loadded from file")
+
+		ts := &savingTokenSource{
+			src:  config.TokenSource(ctx, tok),
+			path: auth.TokenFile,
+			last: tok.AccessToken,
+		}
+
+		opt = option.WithTokenSource(oauth2.ReuseTokenSource(tok, ts))
+	}
+
+	opts := []option.ClientOption{opt}
+	if auth.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(auth.Endpoint))
 	}
 
-	return config.Client(ctx, tok), nil
+	srv, err := sheets.NewService(ctx, opts...)
+	if err != nil {
+		return nil, xerrors.Errorf("retrieving Sheets client: %w", err)
+	}
+
+	return srv, nil
 }
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+// RunOAuthFlow asks user to open auth URL, reads authorization code from `in` and saves the token.
+func RunOAuthFlow(ctx context.Context, auth GoogleAuth, in io.Reader, out io.Writer) error {
+	config, err := auth.oauthConfig()
+	if err != nil {
+		return err
+	}
+
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.ApprovalForce)
 
-	fmt.Printf("Go to the following link in your browser then type the "+
+	fmt.Fprintf(out, "Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
 
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		return nil, xerrors.Errorf("reading authorization code: %w", err)
+	authCode, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && authCode != "") {
+		return xerrors.Errorf("reading authorization code: %w", err)
+	}
+
+	tok, err := config.Exchange(ctx, strings.TrimSpace(authCode))
+	if err != nil {
+		return xerrors.Errorf("exchanging auth code for token: %w", err)
+	}
+
+	if err := saveToken(auth.TokenFile, tok); err != nil {
+		return xerrors.Errorf("saving token: %w", err)
+	}
+
+	fmt.Fprintf(out, "Token saved to %s\n", auth.TokenFile)
+
+	return nil
+}
+
+func (a GoogleAuth) credentials() ([]byte, error) {
+	if a.CredentialsJSON != "" {
+		return []byte(a.CredentialsJSON), nil
+	}
+
+	b, err := os.ReadFile(a.CredentialsFile)
+	if err != nil {
+		return nil, xerrors.Errorf("reading credentials file %q: %w", a.CredentialsFile, err)
+	}
+
+	return b, nil
+}
+
+func (a GoogleAuth) oauthConfig() (*oauth2.Config, error) {
+	b, err := a.credentials()
+	if err != nil {
+		return nil, err
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	// If modifying these scopes, delete your previously saved token.json.
+	config, err := google.ConfigFromJSON(b, readWriteScope)
 	if err != nil {
-		return nil, xerrors.Errorf("exchanging auth code for token: %w", err)
+		return nil, xerrors.Errorf("parsing client secret file to config: %w", err)
+	}
+
+	return config, nil
+}
+
+// savingTokenSource writes refreshed token back to disk, so a restart doesn't need refreshing again,
+// and a rotated refresh token isn't lost.
+type savingTokenSource struct {
+	src  oauth2.TokenSource
+	path string
+	last string
+	sync.Mutex
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if tok.AccessToken != s.last {
+		if err := saveToken(s.path, tok); err != nil {
+			// the token is still good for the requests, so just complain
+			log.Error().Err(err).Msg("saving refreshed token")
+		} else {
+			s.last = tok.AccessToken
+		}
 	}
 
 	return tok, nil
@@ -86,46 +220,26 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 func saveToken(path string, token *oauth2.Token) error {
 	log.Info().Str("path", path).Msg("saving token to file")
 
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
-		return xerrors.Errorf("saving oauth token to '%s': %w", path, err)
+		return xerrors.Errorf("saving oauth token to '%s': %w", tmp, err)
 	}
 
-	defer f.Close()
-
 	if err = json.NewEncoder(f).Encode(token); err != nil {
-		return xerrors.Errorf("encoding token: %w", err)
-	}
-
-	return nil
-}
-
-const (
-	readScope      = "https://www.googleapis.com/auth/spreadsheets.readonly"
-	readWriteScope = "https://www.googleapis.com/auth/spreadsheets"
-)
+		f.Close()
 
-func connectToGoogleSheetsService(ctx context.Context) (*sheets.Service, error) {
-	b, err := os.ReadFile(credsPath)
-	if err != nil {
-		return nil, xerrors.Errorf("reading client secret file: %w", err)
-	}
-
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, readWriteScope)
-	if err != nil {
-		return nil, xerrors.Errorf("parsing client secret file to config: %w", err)
+		return xerrors.Errorf("encoding token: %w", err)
 	}
 
-	client, err := getClient(ctx, config)
-	if err != nil {
-		return nil, xerrors.Errorf("retrieving HTTP client: %w", err)
+	if err = f.Close(); err != nil {
+		return xerrors.Errorf("closing '%s': %w", tmp, err)
 	}
 
-	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		return nil, xerrors.Errorf("retrieving Sheets client: %w", err)
+	if err = os.Rename(tmp, path); err != nil {
+		return xerrors.Errorf("renaming '%s': %w", tmp, err)
 	}
 
-	return srv, nil
+	return nil
 }
@@ -0,0 +1,393 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	// maxFlushBackoff limits the delay between retries when the spreadsheet is unavailable
+	maxFlushBackoff = 5 * time.Minute
+	// queueShutdownTimeout is how long the last flush may take on shutdown
+	queueShutdownTimeout = 10 * time.Second
+)
+
+// Push only queues the address, it's written by RunQueue, so user doesn't wait for the spreadsheet.
+func (g *googleSheets) Push(_ context.Context, a *model.Address) error {
+	c := *a
+
+	g.Lock()
+	g.pending[a.Telegram] = &c
+	g.Unlock()
+
+	return nil
+}
+
+// Remove queues removal of the row, it's done by RunQueue like writes.
+func (g *googleSheets) Remove(_ context.Context, tg string) error {
+	g.Lock()
+	g.pending[tg] = nil
+	g.Unlock()
+
+	return nil
+}
+
+func (g *googleSheets) removing(tg string) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	a, ok := g.pending[tg]
+
+	return ok && a == nil
+}
+
+// RunQueue writes queued addresses every flush interval until ctx is done, then writes what's left.
+// When the spreadsheet is unavailable, the addresses are kept and the delay is doubled.
+func (g *googleSheets) RunQueue(ctx context.Context, written func(ctx context.Context, a *model.Address)) {
+	delay := g.flushInterval
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already canceled, but the queue still should get to the table
+			fctx, cancel := context.WithTimeout(context.Background(), queueShutdownTimeout)
+			if err := g.flush(fctx, written); err != nil {
+				log.Error().Err(err).Msg("writing queued addresses on shutdown, the next sync pushes them")
+			}
+
+			cancel()
+			log.Info().Msg("spreadsheet queue stopped")
+
+			return
+		case <-timer.C:
+		}
+
+		err := g.flush(ctx, written)
+
+		switch {
+		case err == nil:
+			delay = g.flushInterval
+		case retryable(err):
+			if delay *= 2; delay > maxFlushBackoff {
+				delay = maxFlushBackoff
+			}
+
+			log.Warn().Err(err).Dur("retry_in", delay).Msg("spreadsheet is unavailable, addresses stay queued")
+		default:
+			delay = g.flushInterval
+
+			log.Error().Err(err).Msg("writing queued addresses, the next sync pushes them")
+		}
+
+		timer.Reset(delay)
+	}
+}
+
+// flush writes all queued addresses with at most one read, one batch update and one append.
+func (g *googleSheets) flush(ctx context.Context, written func(ctx context.Context, a *model.Address)) error {
+	g.Lock()
+	batch := g.pending
+	g.pending = make(map[string]*model.Address)
+	g.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := g.write(ctx, batch); err != nil {
+		g.Lock()
+		// rows could be appended even if the response was lost, so the layout must be read again
+		g.header = nil
+
+		if retryable(err) {
+			for tg, a := range batch {
+				if _, ok := g.pending[tg]; !ok {
+					g.pending[tg] = a
+				}
+			}
+		}
+		g.Unlock()
+
+		return err
+	}
+
+	for _, a := range batch {
+		if a != nil {
+			written(ctx, a)
+		}
+	}
+
+	log.Debug().Int("addresses", len(batch)).Msg("queued addresses written")
+
+	return nil
+}
+
+func (g *googleSheets) write(ctx context.Context, batch map[string]*model.Address) error {
+	h, rows, fresh, err := g.layout(ctx)
+	if err != nil {
+		return err
+	}
+
+	// rows are written and deleted by index, and moderators could sort, insert or delete rows since the layout
+	// was read, so another participant's row would be overwritten, the layout is read again then
+	stale := lo.ContainsBy(lo.Keys(batch), func(tg string) bool {
+		_, ok := rows[tg]
+
+		return ok || batch[tg] == nil
+	})
+	if stale && !fresh {
+		g.Lock()
+		g.header = nil
+		g.Unlock()
+
+		if h, rows, _, err = g.layout(ctx); err != nil {
+			return err
+		}
+	}
+
+	tgs := make([]string, 0, len(batch))
+	for tg := range batch {
+		tgs = append(tgs, tg)
+	}
+
+	sort.Strings(tgs)
+
+	var (
+		updates []*sheets.ValueRange
+		appends [][]interface{}
+		added   []string
+		removed []int
+	)
+
+	for _, tg := range tgs {
+		i, ok := rows[tg]
+
+		if batch[tg] == nil {
+			if ok {
+				removed = append(removed, i)
+			}
+
+			continue
+		}
+
+		row := h.fill(nil, batch[tg])
+
+		if !ok {
+			appends = append(appends, toInterfaces(row))
+			added = append(added, tg)
+
+			continue
+		}
+
+		// only mapped cells are written, so moderators' columns are kept
+		for _, s := range h.spans() {
+			updates = append(updates, &sheets.ValueRange{
+				Range:  fmt.Sprintf("%s!%s%d:%s%d", page, columnName(s[0]), i+1, columnName(s[1]), i+1),
+				Values: [][]interface{}{toInterfaces(row[s[0] : s[1]+1])},
+			})
+		}
+	}
+
+	if len(updates) > 0 {
+		_, err := g.sheets.Spreadsheets.Values.BatchUpdate(g.sheetID, &sheets.BatchUpdateValuesRequest{
+			ValueInputOption: "USER_ENTERED",
+			Data:             updates,
+		}).Context(ctx).Do()
+		if err != nil {
+			return xerrors.Errorf("updating %d ranges of sheet %q: %w", len(updates), g.sheetID, err)
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := g.deleteRows(ctx, removed); err != nil {
+			return err
+		}
+	}
+
+	if len(appends) == 0 {
+		return nil
+	}
+
+	resp, err := g.sheets.Spreadsheets.Values.Append(g.sheetID, readRange, &sheets.ValueRange{Values: appends}).
+		ValueInputOption("USER_ENTERED").Context(ctx).Do()
+	if err != nil {
+		return xerrors.Errorf("appending %d rows to sheet %q: %w", len(appends), g.sheetID, err)
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.generation++
+
+	first, ok := firstRow(resp)
+	if !ok || g.header != h {
+		g.header = nil
+
+		return nil
+	}
+
+	for i, tg := range added {
+		g.rows[tg] = first + i
+	}
+
+	return nil
+}
+
+// deleteRows deletes the rows by their indexes, the layout is read again after that, as the rows below are moved up.
+func (g *googleSheets) deleteRows(ctx context.Context, rows []int) error {
+	pageID, err := g.page(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the lowest row is deleted first, so indexes of the rest stay valid
+	sort.Sort(sort.Reverse(sort.IntSlice(rows)))
+
+	reqs := make([]*sheets.Request, 0, len(rows))
+	for _, i := range rows {
+		reqs = append(reqs, &sheets.Request{DeleteDimension: &sheets.DeleteDimensionRequest{
+			Range: &sheets.DimensionRange{
+				SheetId:         pageID,
+				Dimension:       "ROWS",
+				StartIndex:      int64(i),
+				EndIndex:        int64(i + 1),
+				ForceSendFields: []string{"SheetId", "StartIndex"},
+			},
+		}})
+	}
+
+	_, err = g.sheets.Spreadsheets.BatchUpdate(g.sheetID, &sheets.BatchUpdateSpreadsheetRequest{Requests: reqs}).
+		Context(ctx).Do()
+
+	// rows could be deleted even if the response was lost
+	g.Lock()
+	g.header = nil
+	g.generation++
+	g.Unlock()
+
+	if err != nil {
+		return xerrors.Errorf("deleting %d rows of sheet %q: %w", len(rows), g.sheetID, err)
+	}
+
+	return nil
+}
+
+// page returns the sheet ID of page.
+func (g *googleSheets) page(ctx context.Context) (int64, error) {
+	g.Lock()
+	pageID := g.pageID
+	g.Unlock()
+
+	if pageID != nil {
+		return *pageID, nil
+	}
+
+	resp, err := g.sheets.Spreadsheets.Get(g.sheetID).Fields("sheets.properties").Context(ctx).Do()
+	if err != nil {
+		return 0, xerrors.Errorf("getting sheets of %q: %w", g.sheetID, err)
+	}
+
+	for _, sh := range resp.Sheets {
+		if sh.Properties != nil && sh.Properties.Title == page {
+			id := sh.Properties.SheetId
+
+			g.Lock()
+			g.pageID = &id
+			g.Unlock()
+
+			return id, nil
+		}
+	}
+
+	return 0, xerrors.Errorf("there is no sheet %q in %q", page, g.sheetID)
+}
+
+// layout returns the known table layout or reads it, fresh is true if it's just read.
+func (g *googleSheets) layout(ctx context.Context) (h *header, rows map[string]int, fresh bool, err error) {
+	g.Lock()
+	h, rows = g.header, g.rows
+	g.Unlock()
+
+	if h != nil {
+		return h, rows, false, nil
+	}
+
+	data, err := g.readRows(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.setLayout(data)
+
+	if g.header == nil {
+		_, err := parseHeader(data, g.names)
+
+		return nil, nil, false, xerrors.Errorf("parsing sheet %q: %w", g.sheetID, err)
+	}
+
+	return g.header, g.rows, true, nil
+}
+
+var updatedRangeRe = regexp.MustCompile(`![A-Z]+(\d+)`)
+
+// firstRow returns index of the first appended row.
+func firstRow(resp *sheets.AppendValuesResponse) (int, bool) {
+	if resp.Updates == nil {
+		return 0, false
+	}
+
+	m := updatedRangeRe.FindStringSubmatch(resp.Updates.UpdatedRange)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return n - 1, true
+}
+
+// retryable reports whether the request may succeed later: quota exceeded, server or network errors.
+func retryable(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code >= http.StatusInternalServerError
+	}
+
+	var nerr net.Error
+
+	return errors.As(err, &nerr)
+}
+
+// columnName converts column index to A1 notation: 0 is A, 26 is AA.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+
+	return name
+}
@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+	"github.com/grbit/post_bot/internal/sheetsfake"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+const testSpreadsheet = "spreadsheet"
+
+// testHeader has a column moderators keep for themselves between the mapped ones
+var testHeader = []interface{}{"Телеграм", "Имя и фамилия", "Комментарий", "Адрес", "Одобрено"}
+
+// newTestSheets starts fake Sheets API with the rows under testHeader and returns the source backed by it.
+func newTestSheets(t *testing.T, rows ...[]interface{}) (*sheetsfake.Server, *googleSheets) {
+	t.Helper()
+
+	fake := sheetsfake.NewServer()
+	t.Cleanup(fake.Close)
+
+	fake.AddSheet(testSpreadsheet, page, append([][]interface{}{testHeader}, rows...))
+
+	src, err := NewGoogleSheetsSource(context.Background(), testSpreadsheet,
+		GoogleAuth{Mode: AuthNone, Endpoint: fake.URL}, DefaultColumnNames, time.Hour)
+	if err != nil {
+		t.Fatalf("creating sheets source: %v", err)
+	}
+
+	return fake, src.(*googleSheets)
+}
+
+func writtenTo(tgs *[]string) func(context.Context, *model.Address) {
+	return func(_ context.Context, a *model.Address) { *tgs = append(*tgs, a.Telegram) }
+}
+
+func TestFlushKeepsQueuedOnQuotaError(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t)
+
+	if err := g.Push(ctx, &model.Address{Telegram: "alice", Address: "Moscow"}); err != nil {
+		t.Fatalf("pushing: %v", err)
+	}
+
+	var written []string
+
+	fake.FailNext(1, http.StatusTooManyRequests)
+
+	err := g.flush(ctx, writtenTo(&written))
+	if err == nil || !retryable(err) {
+		t.Fatalf("flush error is %v, want retryable one", err)
+	}
+
+	if _, ok := g.pending["alice"]; !ok || len(written) != 0 {
+		t.Fatalf("address isn't queued after quota error: pending %v, written %v", g.pending, written)
+	}
+
+	if err := g.flush(ctx, writtenTo(&written)); err != nil {
+		t.Fatalf("flushing again: %v", err)
+	}
+
+	if len(g.pending) != 0 || !reflect.DeepEqual(written, []string{"alice"}) {
+		t.Fatalf("after retry pending %v, written %v", g.pending, written)
+	}
+
+	rows := fake.Rows(testSpreadsheet, page)
+	if len(rows) != 2 || rows[1][0] != "alice" || rows[1][3] != "Moscow" {
+		t.Fatalf("table is %q", rows)
+	}
+}
+
+func TestFlushDropsOnPermanentError(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t)
+
+	if err := g.Push(ctx, &model.Address{Telegram: "alice", Address: "Moscow"}); err != nil {
+		t.Fatalf("pushing: %v", err)
+	}
+
+	fake.FailNext(1, http.StatusBadRequest)
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err == nil || retryable(err) {
+		t.Fatalf("flush error is %v, want permanent one", err)
+	}
+
+	// the next sync pushes the address again
+	if len(g.pending) != 0 {
+		t.Fatalf("pending is %v after permanent error", g.pending)
+	}
+}
+
+func TestWriteKeepsModeratorColumns(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t,
+		[]interface{}{"alice", "Alice", "checked by Bob", "Moscow", "1"},
+		[]interface{}{"bob", "Bob", "call first", "Berlin"},
+	)
+
+	if err := g.Push(ctx, &model.Address{Telegram: "alice", PersonName: "Alice L", Address: "Moscow, Red sq 1"}); err != nil {
+		t.Fatalf("pushing: %v", err)
+	}
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	want := [][]string{
+		{"Телеграм", "Имя и фамилия", "Комментарий", "Адрес", "Одобрено"},
+		{"alice", "Alice L", "checked by Bob", "Moscow, Red sq 1", "нет"},
+		{"bob", "Bob", "call first", "Berlin"},
+	}
+	if rows := fake.Rows(testSpreadsheet, page); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("table is %q, want %q", rows, want)
+	}
+
+	if n := fake.Calls("batchUpdate"); n != 1 {
+		t.Fatalf("batchUpdate is called %d times, want 1", n)
+	}
+
+	if n := fake.Calls("append"); n != 0 {
+		t.Fatalf("append is called %d times for an existing row", n)
+	}
+}
+
+func TestAppendedRowsAreUpdatedInPlace(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t, []interface{}{"bob", "Bob", "", "Berlin"})
+
+	for _, addr := range []string{"Moscow", "Moscow, Red sq 1"} {
+		if err := g.Push(ctx, &model.Address{Telegram: "alice", Address: addr}); err != nil {
+			t.Fatalf("pushing: %v", err)
+		}
+
+		if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+			t.Fatalf("flushing: %v", err)
+		}
+	}
+
+	// the row is updated, not appended again, the layout is read again before that, as rows could be moved
+	if n := fake.Calls("append"); n != 1 {
+		t.Fatalf("append is called %d times, want 1", n)
+	}
+
+	if n := fake.Calls("get"); n != 2 {
+		t.Fatalf("table is read %d times, want 2", n)
+	}
+
+	rows := fake.Rows(testSpreadsheet, page)
+	if len(rows) != 3 || rows[2][0] != "alice" || rows[2][3] != "Moscow, Red sq 1" {
+		t.Fatalf("table is %q", rows)
+	}
+}
+
+func TestWriteAfterRowsAreMoved(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t,
+		[]interface{}{"alice", "Alice", "", "Moscow"},
+		[]interface{}{"bob", "Bob", "call first", "Berlin"},
+	)
+
+	if _, err := g.Load(ctx); err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+
+	// a moderator sorts the table after it's loaded
+	fake.AddSheet(testSpreadsheet, page, [][]interface{}{
+		testHeader,
+		{"bob", "Bob", "call first", "Berlin"},
+		{"alice", "Alice", "", "Moscow"},
+	})
+
+	if err := g.Push(ctx, &model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow, Red sq 1"}); err != nil {
+		t.Fatalf("pushing: %v", err)
+	}
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	want := [][]string{
+		{"Телеграм", "Имя и фамилия", "Комментарий", "Адрес", "Одобрено"},
+		{"bob", "Bob", "call first", "Berlin"},
+		{"alice", "Alice", "", "Moscow, Red sq 1", "нет"},
+	}
+	if rows := fake.Rows(testSpreadsheet, page); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("table is %q, want %q", rows, want)
+	}
+}
+
+func TestFirstRow(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		resp   *sheets.AppendValuesResponse
+		want   int
+		wantOK bool
+	}{
+		{"no updates", &sheets.AppendValuesResponse{}, 0, false},
+		{"one row", &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: "Лист1!A5:E5"}}, 4, true},
+		{"quoted sheet", &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: "'My sheet'!A12:Z14"}}, 11, true},
+		{"no row", &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: "Лист1"}}, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := firstRow(tt.resp)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("firstRow is %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"quota", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"server", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"bad request", &googleapi.Error{Code: http.StatusBadRequest}, false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"other", errors.New("parsing sheet"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Fatalf("retryable is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunQueueRetriesUntilWritten(t *testing.T) {
+	fake, g := newTestSheets(t)
+	g.flushInterval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := g.Push(ctx, &model.Address{Telegram: "alice", Address: "Moscow"}); err != nil {
+		t.Fatalf("pushing: %v", err)
+	}
+
+	fake.FailNext(2, http.StatusServiceUnavailable)
+
+	written := make(chan string, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		g.RunQueue(ctx, func(_ context.Context, a *model.Address) { written <- a.Telegram })
+	}()
+
+	select {
+	case tg := <-written:
+		if tg != "alice" {
+			t.Fatalf("written %q", tg)
+		}
+	case <-ctx.Done():
+		t.Fatal("address isn't written after the spreadsheet is back")
+	}
+
+	cancel()
+	<-done
+
+	// two failed reads and the successful one
+	if n := fake.Calls("get"); n != 3 {
+		t.Fatalf("table is read %d times, want 3", n)
+	}
+}
+
+func TestRemoveDeletesRow(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t,
+		[]interface{}{"alice", "Alice", "", "Moscow"},
+		[]interface{}{"bob", "Bob", "call first", "Berlin"},
+		[]interface{}{"carol", "Carol", "", "Paris"},
+	)
+
+	if err := g.Remove(ctx, "bob"); err != nil {
+		t.Fatalf("removing: %v", err)
+	}
+
+	if !g.removing("bob") || g.removing("alice") {
+		t.Fatal("removal isn't queued")
+	}
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	// the rows below are moved up, so the next write must find carol in the row bob had
+	if err := g.Push(ctx, &model.Address{Telegram: "carol", PersonName: "Carol", Address: "Paris, Rue 1"}); err != nil {
+		t.Fatalf("pushing: %v", err)
+	}
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	want := [][]string{
+		{"Телеграм", "Имя и фамилия", "Комментарий", "Адрес", "Одобрено"},
+		{"alice", "Alice", "", "Moscow"},
+		{"carol", "Carol", "", "Paris, Rue 1", "нет"},
+	}
+	if rows := fake.Rows(testSpreadsheet, page); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("table is %q, want %q", rows, want)
+	}
+
+	if n := fake.Calls("batchUpdateSpreadsheet"); n != 1 {
+		t.Fatalf("rows are deleted %d times, want 1", n)
+	}
+
+	if g.removing("bob") {
+		t.Fatal("removal is still queued")
+	}
+}
+
+func TestRemoveOfMissingRow(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t, []interface{}{"alice", "Alice", "", "Moscow"})
+
+	if err := g.Remove(ctx, "bob"); err != nil {
+		t.Fatalf("removing: %v", err)
+	}
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	if n := fake.Calls("batchUpdateSpreadsheet"); n != 0 {
+		t.Fatalf("rows are deleted %d times", n)
+	}
+
+	if rows := fake.Rows(testSpreadsheet, page); len(rows) != 2 {
+		t.Fatalf("table is %q", rows)
+	}
+}
+
+func TestLoadDuringDeletionDropsLayout(t *testing.T) {
+	ctx := context.Background()
+	_, g := newTestSheets(t,
+		[]interface{}{"alice", "Alice", "", "Moscow"},
+		[]interface{}{"bob", "Bob", "", "Berlin"},
+	)
+
+	// Load reads the rows, then the queue deletes one before the layout is kept
+	generation := g.generation
+
+	rows, err := g.readRows(ctx)
+	if err != nil {
+		t.Fatalf("reading rows: %v", err)
+	}
+
+	if err := g.Remove(ctx, "alice"); err != nil {
+		t.Fatalf("removing: %v", err)
+	}
+
+	if err := g.flush(ctx, writtenTo(new([]string))); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	g.loaded(generation, rows)
+
+	if g.header != nil {
+		t.Fatalf("layout read before the deletion is kept: bob is at row %d", g.rows["bob"])
+	}
+}
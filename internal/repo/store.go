@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/samber/lo"
+)
+
+// AddressStore is a storage of participants addresses.
+// Telegram nicks taken by the methods are normalized by PrepareTelegram, so they can be given as typed.
+type AddressStore interface {
+	// Get returns address by telegram nick. If there is no such address,
+	// empty address with only Telegram filled is returned.
+	Get(ctx context.Context, tg string) (*model.Address, error)
+	// Upsert saves address. New address (without ID) replaces a deleted one with the same telegram
+	// and removes its tombstone.
+	Upsert(ctx context.Context, a *model.Address) error
+	// Delete soft deletes address by telegram nick.
+	Delete(ctx context.Context, tg string) error
+	// Erase soft deletes address by telegram nick wiping personal data, and leaves a tombstone,
+	// so the address isn't created again from the sync source.
+	Erase(ctx context.Context, tg string) error
+	// Erased reports whether there is a tombstone of the telegram nick.
+	Erased(ctx context.Context, tg string) (bool, error)
+	// MarkSynced sets SyncHash without touching UpdatedAt.
+	MarkSynced(ctx context.Context, tg, hash string) error
+	// Search finds addresses by phone, email, telegram, instagram or person name.
+	Search(ctx context.Context, req string) ([]*model.Address, error)
+	// Random returns random approved address with a postal address, except addresses of the excluded
+	// telegram nicks. If countries are given, the address must be in one of them.
+	// It returns nil if there is no such address.
+	Random(ctx context.Context, exclude, countries []string) (*model.Address, error)
+	// List returns all not deleted addresses.
+	List(ctx context.Context) ([]*model.Address, error)
+}
+
+// drawable reports whether the address can be given by Random.
+func drawable(a *model.Address, exclude, countries []string) bool {
+	return a.Approved && a.Address != "" && !lo.Contains(exclude, a.Telegram) &&
+		(len(countries) == 0 || lo.Contains(countries, a.Country))
+}
+
+// matchesExactly reports whether address has the same phone, email, telegram or instagram as in request.
+func matchesExactly(a *model.Address, req string) bool {
+	phone := preparePhone(req)
+	tg := PrepareTelegram(req)
+	inst := prepareInstagram(req)
+
+	return (a.Phone != "" && a.Phone == phone) ||
+		(a.Email != "" && a.Email == req) ||
+		(a.Telegram != "" && a.Telegram == tg) ||
+		(a.Instagram != "" && a.Instagram == inst)
+}
+
+// filterByName leaves addresses with the person name equal to request
+// or with one of the name words equal to request.
+func filterByName(aa []*model.Address, req string) []*model.Address {
+	return lo.Filter(aa, func(a *model.Address, _ int) bool {
+		if a.PersonName == "" {
+			return false
+		}
+
+		if strings.EqualFold(a.PersonName, req) {
+			return true
+		}
+
+		ss := strings.Split(a.PersonName, " ")
+		for _, s := range ss {
+			if len(s) < 2 {
+				continue
+			}
+
+			if strings.EqualFold(s, req) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
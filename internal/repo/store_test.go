@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/dbtest"
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// stores make empty address stores of every kind, SQLite stands for SQL databases
+var stores = map[string]func(t *testing.T) AddressStore{
+	"memory": func(*testing.T) AddressStore { return NewMemoryStore() },
+	"gorm":   func(t *testing.T) AddressStore { return &GormStore{DB: dbtest.Open(t), nameOp: "LIKE"} },
+}
+
+func TestStoreNormalizesTelegram(t *testing.T) {
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			for _, tg := range []string{"alice", "bob"} {
+				if err := s.Upsert(ctx, &model.Address{Telegram: tg, Address: "Moscow"}); err != nil {
+					t.Fatalf("saving %q: %v", tg, err)
+				}
+			}
+
+			if err := s.MarkSynced(ctx, "@Alice", "hash"); err != nil {
+				t.Fatalf("marking synced: %v", err)
+			}
+
+			if a, _ := s.Get(ctx, "alice"); a.SyncHash != "hash" {
+				t.Fatalf("address isn't marked synced: %+v", a)
+			}
+
+			if err := s.Erase(ctx, "@Alice"); err != nil {
+				t.Fatalf("erasing: %v", err)
+			}
+
+			if erased, _ := s.Erased(ctx, "ALICE"); !erased {
+				t.Fatal("erased address isn't found by nick in another case")
+			}
+
+			if err := s.Delete(ctx, "https://t.me/Bob"); err != nil {
+				t.Fatalf("deleting: %v", err)
+			}
+
+			if list, _ := s.List(ctx); len(list) != 0 {
+				t.Fatalf("addresses left: %+v", list)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+)
+
+// Strategies of random draws.
+const (
+	// StrategyUniform gives every candidate the same chance
+	StrategyUniform = "uniform"
+	// StrategyLeastReceived prefers those who have been drawn less often and less recently
+	StrategyLeastReceived = "least-received"
+	// StrategyRoundRobin gives the one who hasn't been drawn for the longest time, those never drawn go first
+	StrategyRoundRobin = "round-robin"
+)
+
+// recencyHalfLife is how fast a draw stops counting for StrategyLeastReceived, a draw made that long ago
+// counts as half of a fresh one
+const recencyHalfLife = 7 * 24 * time.Hour
+
+// Strategy picks a recipient of a random draw.
+type Strategy interface {
+	// Pick returns an address which can be sent a postcard, except the excluded telegram nicks,
+	// in one of the countries if they are given, or nil if there is no such address.
+	Pick(ctx context.Context, s AddressStore, exclude, countries []string) (*model.Address, error)
+}
+
+// History tells how often recipients have been drawn.
+type History interface {
+	// Received returns draws of everyone who has been drawn at least once, by telegram nick.
+	Received(ctx context.Context) (map[string]model.Received, error)
+}
+
+// NewStrategy returns the strategy by name, history is used by those which balance recipients.
+func NewStrategy(name string, h History) (Strategy, error) {
+	switch name {
+	case StrategyUniform, "":
+		return uniform{}, nil
+	case StrategyLeastReceived:
+		return &leastReceived{history: h, now: time.Now}, nil
+	case StrategyRoundRobin:
+		return &roundRobin{history: h}, nil
+	}
+
+	return nil, xerrors.Errorf("unknown draw strategy %q", name)
+}
+
+type uniform struct{}
+
+func (uniform) Pick(ctx context.Context, s AddressStore, exclude, countries []string) (*model.Address, error) {
+	return s.Random(ctx, exclude, countries)
+}
+
+// candidates returns addresses which can be drawn and what is known about their draws.
+func candidates(ctx context.Context, s AddressStore, h History, exclude, countries []string) (
+	[]*model.Address, map[string]model.Received, error,
+) {
+	aa, err := s.List(ctx)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	aa = lo.Filter(aa, func(a *model.Address, _ int) bool { return drawable(a, exclude, countries) })
+	if len(aa) == 0 {
+		return nil, nil, nil
+	}
+
+	received, err := h.Received(ctx)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting draws history: %w", err)
+	}
+
+	return aa, received, nil
+}
+
+// leastReceived draws with weights, every draw of the recipient lowers their weight,
+// old draws lower it less, so those who got postcards long ago catch up with newcomers.
+type leastReceived struct {
+	history History
+	now     func() time.Time
+}
+
+func (l *leastReceived) Pick(ctx context.Context, s AddressStore, exclude, countries []string) (*model.Address, error) {
+	aa, received, err := candidates(ctx, s, l.history, exclude, countries)
+	if err != nil || len(aa) == 0 {
+		return nil, err
+	}
+
+	now := l.now()
+	weights := make([]float64, len(aa))
+	total := 0.0
+
+	for i, a := range aa {
+		r := received[a.Telegram]
+
+		// draws are counted as if they all were made at the last one, it's enough to tell fresh draws from old ones
+		age := now.Sub(r.LastAt).Hours() / recencyHalfLife.Hours()
+		n := float64(r.Count) / (1 + age)
+
+		weights[i] = 1 / ((1 + n) * (1 + n))
+		total += weights[i]
+	}
+
+	x := rand.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return aa[i], nil
+		}
+
+		x -= w
+	}
+
+	return aa[len(aa)-1], nil
+}
+
+// roundRobin gives the one who was drawn the longest time ago, so everyone is drawn in turn.
+type roundRobin struct {
+	history History
+}
+
+func (r *roundRobin) Pick(ctx context.Context, s AddressStore, exclude, countries []string) (*model.Address, error) {
+	aa, received, err := candidates(ctx, s, r.history, exclude, countries)
+	if err != nil || len(aa) == 0 {
+		return nil, err
+	}
+
+	// addresses are listed by ID, so among those never drawn the oldest participant goes first
+	sort.SliceStable(aa, func(i, j int) bool {
+		return received[aa[i].Telegram].LastAt.Before(received[aa[j].Telegram].LastAt)
+	})
+
+	return aa[0], nil
+}
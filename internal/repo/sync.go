@@ -0,0 +1,265 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/xerrors"
+)
+
+// Conflict policies, they are used when an address was changed both in DB and in the table since the last sync.
+const (
+	// PolicyLastWriterWins takes the side which was changed later. Time of a table change is taken
+	// from the updated_at column, if there is no such column, it's the time the change was noticed,
+	// so the table wins.
+	PolicyLastWriterWins = "last-writer-wins"
+	// PolicyDBWinsUserFields takes the fields users edit via bot from DB, and approval from the table,
+	// as it's edited by moderators only.
+	PolicyDBWinsUserFields = "db-wins-user-fields"
+)
+
+// SyncReport is what one sync run has done.
+type SyncReport struct {
+	// Created addresses are new rows of the table
+	Created int
+	// FromTable addresses were changed in the table only
+	FromTable int
+	// Pushed addresses were changed in DB only, or never got to the table
+	Pushed int
+	// Conflicts are addresses changed on both sides
+	Conflicts int
+	// Deleted addresses were removed from the table
+	Deleted int
+	// Erased are rows of addresses erased by participants, they are removed from the table again
+	Erased    int
+	Unchanged int
+	Failed    int
+}
+
+// sync reconciles DB with the sync source. Every address has a hash of its state at the last sync,
+// so it's known which side has changed since then.
+func (r *Repo) sync(ctx context.Context) (*SyncReport, error) {
+	started := time.Now()
+	rep := &SyncReport{}
+
+	// DB is read first: if an address is changed and pushed in between,
+	// the table has the new version and it's just taken from there
+	stored, err := r.AddressStore.List(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing addresses: %w", err)
+	}
+
+	table, err := r.source.Load(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("loading addresses from sync source: %w", err)
+	}
+
+	byTg := make(map[string]*model.Address, len(stored))
+	for _, a := range stored {
+		byTg[a.Telegram] = a
+	}
+
+	seen := make(map[string]bool, len(table))
+
+	for _, t := range table {
+		if seen[t.Telegram] {
+			log.Warn().Str("telegram", t.Telegram).Msg("duplicated telegram in the table, only the first row is used")
+
+			continue
+		}
+
+		seen[t.Telegram] = true
+
+		if err := r.syncOne(ctx, rep, t, byTg[t.Telegram], started); err != nil {
+			rep.Failed++
+
+			log.Error().Err(err).Str("telegram", t.Telegram).Msg("syncing address")
+		}
+	}
+
+	if len(table) == 0 && len(stored) > 0 {
+		// most likely the table is broken, it's better not to delete everyone
+		log.Warn().Msg("sync source is empty, deletions are not propagated")
+
+		return rep, nil
+	}
+
+	for _, a := range stored {
+		if seen[a.Telegram] {
+			continue
+		}
+
+		if err := r.syncMissing(ctx, rep, a); err != nil {
+			rep.Failed++
+
+			log.Error().Err(err).Str("telegram", a.Telegram).Msg("syncing address missing in the table")
+		}
+	}
+
+	return rep, nil
+}
+
+// syncOne reconciles table row t with stored address a, which may be nil.
+func (r *Repo) syncOne(ctx context.Context, rep *SyncReport, t, a *model.Address, noticed time.Time) error {
+	th := syncHash(t)
+
+	if a == nil {
+		erased, err := r.AddressStore.Erased(ctx, t.Telegram)
+		if err != nil {
+			return err
+		}
+
+		if erased {
+			// the row is in the table until the queued removal is written, it's counted once
+			if q, ok := r.source.(queuedSource); ok && q.removing(t.Telegram) {
+				return nil
+			}
+
+			rep.Erased++
+
+			return r.source.Remove(ctx, t.Telegram)
+		}
+
+		if !t.Approved {
+			// rows added to the table by hand wait for moderators like the ones registered via bot
+			t.Moderation = model.ModerationPending
+		}
+
+		t.SyncHash = th
+		if err := r.AddressStore.Upsert(ctx, t); err != nil {
+			return xerrors.Errorf("creating address: %w", err)
+		}
+
+		rep.Created++
+
+		r.Lock()
+		f := r.created
+		r.Unlock()
+
+		if f != nil && !t.Approved {
+			f(ctx, t)
+		}
+
+		return nil
+	}
+
+	ah := syncHash(a)
+
+	switch {
+	case th == ah:
+		rep.Unchanged++
+
+		if a.SyncHash != th {
+			return r.AddressStore.MarkSynced(ctx, a.Telegram, th)
+		}
+
+		return nil
+	case th == a.SyncHash:
+		rep.Pushed++
+
+		return r.push(ctx, a)
+	case ah == a.SyncHash:
+		rep.FromTable++
+
+		return r.takeFromTable(ctx, a, t, th)
+	}
+
+	rep.Conflicts++
+
+	if r.policy == PolicyLastWriterWins {
+		tableTime := t.UpdatedAt
+		if tableTime.IsZero() {
+			tableTime = noticed
+		}
+
+		log.Info().
+			Str("telegram", a.Telegram).
+			Time("db_updated_at", a.UpdatedAt).
+			Time("table_updated_at", tableTime).
+			Msg("sync conflict, the last writer wins")
+
+		if a.UpdatedAt.After(tableTime) {
+			return r.push(ctx, a)
+		}
+
+		return r.takeFromTable(ctx, a, t, th)
+	}
+
+	log.Info().Str("telegram", a.Telegram).Msg("sync conflict, user fields are taken from DB")
+
+	notify := r.setApproved(a, t.Approved)
+	if err := r.AddressStore.Upsert(ctx, a); err != nil {
+		return xerrors.Errorf("saving merged address: %w", err)
+	}
+
+	notify(ctx)
+
+	return r.push(ctx, a)
+}
+
+// syncMissing handles stored address which isn't in the table.
+func (r *Repo) syncMissing(ctx context.Context, rep *SyncReport, a *model.Address) error {
+	if a.SyncHash == "" {
+		rep.Pushed++
+
+		return r.push(ctx, a)
+	}
+
+	rep.Deleted++
+
+	log.Info().Str("telegram", a.Telegram).Msg("address was removed from the table, deleting")
+
+	return r.AddressStore.Delete(ctx, a.Telegram)
+}
+
+func (r *Repo) takeFromTable(ctx context.Context, a, t *model.Address, hash string) error {
+	if t.Address != a.Address {
+		// the table has the address in one line only, so the parts are outdated, the country most likely isn't
+		a.RecipientLine, a.Street, a.City, a.Region, a.PostalCode = "", "", "", "", ""
+	}
+
+	a.Instagram = t.Instagram
+	a.PersonName = t.PersonName
+	a.Address = t.Address
+	a.Wishes = t.Wishes
+	a.Email = t.Email
+	a.Phone = t.Phone
+	a.SyncHash = hash
+	notify := r.setApproved(a, t.Approved)
+
+	if err := r.AddressStore.Upsert(ctx, a); err != nil {
+		return xerrors.Errorf("saving address from the table: %w", err)
+	}
+
+	notify(ctx)
+
+	return nil
+}
+
+// push writes address to the table and remembers it's synced, queued sources remember it on their own.
+func (r *Repo) push(ctx context.Context, a *model.Address) error {
+	if err := r.source.Push(ctx, a); err != nil {
+		return xerrors.Errorf("pushing to sync source: %w", err)
+	}
+
+	if _, ok := r.source.(queuedSource); ok {
+		return nil
+	}
+
+	return r.markSynced(ctx, a)
+}
+
+// markSynced remembers the address is in the table as it is now.
+func (r *Repo) markSynced(ctx context.Context, a *model.Address) error {
+	hash := syncHash(a)
+	if err := r.AddressStore.MarkSynced(ctx, a.Telegram, hash); err != nil {
+		return err
+	}
+
+	a.SyncHash = hash
+
+	return nil
+}
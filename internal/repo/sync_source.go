@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// SyncSource is an external table with addresses, e.g. a spreadsheet moderators work with.
+// Addresses are periodically loaded from it, and every address change is pushed to it.
+type SyncSource interface {
+	Load(ctx context.Context) ([]*model.Address, error)
+	Push(ctx context.Context, a *model.Address) error
+	// Remove removes the row with the telegram nick, if there is one.
+	Remove(ctx context.Context, tg string) error
+}
+
+// queuedSource is SyncSource which only queues pushed addresses and writes them later,
+// so an address is marked synced when it's actually written.
+type queuedSource interface {
+	SyncSource
+	// RunQueue writes queued addresses until ctx is done, calling written for each one.
+	RunQueue(ctx context.Context, written func(ctx context.Context, a *model.Address))
+	// removing reports whether removal of the row is queued and not written yet.
+	removing(tg string) bool
+}
+
+type noSync struct{}
+
+// NoSync returns SyncSource which does nothing, so the database is the only source of truth.
+func NoSync() SyncSource {
+	return noSync{}
+}
+
+func (noSync) Load(context.Context) ([]*model.Address, error) { return nil, nil }
+
+func (noSync) Push(context.Context, *model.Address) error { return nil }
+
+func (noSync) Remove(context.Context, string) error { return nil }
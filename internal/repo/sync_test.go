@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// base is the address as both sides had it at the last sync
+func base() *model.Address {
+	return &model.Address{Telegram: "alice", PersonName: "Alice", Address: "Moscow"}
+}
+
+var baseRow = []interface{}{"alice", "Alice", "", "Moscow", "нет"}
+
+func TestSync(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// stored is saved with the hash of base, nil means there is no such address in DB
+		stored func() *model.Address
+		// unsynced stored address has never got to the table
+		unsynced bool
+		rows     [][]interface{}
+		want     SyncReport
+		// check gets the stored address, nil if there is none, and what's queued for the table
+		check func(t *testing.T, a *model.Address, queued *model.Address, isQueued bool)
+	}{
+		{
+			name:   "unchanged",
+			stored: base,
+			rows:   [][]interface{}{baseRow},
+			want:   SyncReport{Unchanged: 1},
+			check: func(t *testing.T, a, _ *model.Address, isQueued bool) {
+				if a == nil || isQueued {
+					t.Fatalf("address %+v, queued %v", a, isQueued)
+				}
+			},
+		},
+		{
+			name: "new row",
+			rows: [][]interface{}{baseRow},
+			want: SyncReport{Created: 1},
+			check: func(t *testing.T, a, _ *model.Address, _ bool) {
+				if a == nil || a.PersonName != "Alice" || a.SyncHash != syncHash(base()) {
+					t.Fatalf("created address is %+v", a)
+				}
+			},
+		},
+		{
+			name:   "changed in the table",
+			stored: base,
+			rows:   [][]interface{}{{"alice", "Alice L", "", "Moscow", "да"}},
+			want:   SyncReport{FromTable: 1},
+			check: func(t *testing.T, a, _ *model.Address, isQueued bool) {
+				if a.PersonName != "Alice L" || !a.Approved || a.SyncHash != syncHash(a) || isQueued {
+					t.Fatalf("address is %+v, queued %v", a, isQueued)
+				}
+			},
+		},
+		{
+			name: "changed in DB",
+			stored: func() *model.Address {
+				a := base()
+				a.Address = "Moscow, Red sq 1"
+
+				return a
+			},
+			rows: [][]interface{}{baseRow},
+			want: SyncReport{Pushed: 1},
+			check: func(t *testing.T, a, q *model.Address, isQueued bool) {
+				if !isQueued || q.Address != "Moscow, Red sq 1" {
+					t.Fatalf("queued %+v, %v", q, isQueued)
+				}
+
+				if a.SyncHash != syncHash(base()) {
+					t.Fatal("address is marked synced before it's written to the table")
+				}
+			},
+		},
+		{
+			name: "changed on both sides",
+			stored: func() *model.Address {
+				a := base()
+				a.Address = "Moscow, Red sq 1"
+
+				return a
+			},
+			rows: [][]interface{}{{"alice", "Alice L", "", "Moscow", "да"}},
+			want: SyncReport{Conflicts: 1},
+			check: func(t *testing.T, a, q *model.Address, isQueued bool) {
+				// user fields come from DB, approval from the table
+				if a.PersonName != "Alice" || a.Address != "Moscow, Red sq 1" || !a.Approved {
+					t.Fatalf("merged address is %+v", a)
+				}
+
+				if !isQueued || q.Address != a.Address || !q.Approved {
+					t.Fatalf("queued %+v, %v", q, isQueued)
+				}
+			},
+		},
+		{
+			name:   "deleted in the table",
+			stored: base,
+			rows:   [][]interface{}{{"bob", "Bob", "", "Berlin"}},
+			want:   SyncReport{Created: 1, Deleted: 1},
+			check: func(t *testing.T, a, _ *model.Address, isQueued bool) {
+				if a != nil || isQueued {
+					t.Fatalf("address %+v, queued %v", a, isQueued)
+				}
+			},
+		},
+		{
+			name:     "never got to the table",
+			stored:   base,
+			unsynced: true,
+			rows:     [][]interface{}{{"bob", "Bob", "", "Berlin"}},
+			want:     SyncReport{Created: 1, Pushed: 1},
+			check: func(t *testing.T, a, q *model.Address, isQueued bool) {
+				if a == nil || !isQueued || q.Address != "Moscow" {
+					t.Fatalf("address %+v, queued %+v, %v", a, q, isQueued)
+				}
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			_, g := newTestSheets(t, tt.rows...)
+			r := &Repo{AddressStore: NewMemoryStore(), source: g, policy: PolicyDBWinsUserFields}
+
+			if tt.stored != nil {
+				a := tt.stored()
+				if !tt.unsynced {
+					a.SyncHash = syncHash(base())
+				}
+
+				if err := r.AddressStore.Upsert(ctx, a); err != nil {
+					t.Fatalf("saving address: %v", err)
+				}
+			}
+
+			rep, err := r.sync(ctx)
+			if err != nil {
+				t.Fatalf("syncing: %v", err)
+			}
+
+			if *rep != tt.want {
+				t.Fatalf("report is %+v, want %+v", *rep, tt.want)
+			}
+
+			list, err := r.AddressStore.List(ctx)
+			if err != nil {
+				t.Fatalf("listing: %v", err)
+			}
+
+			var a *model.Address
+
+			for _, s := range list {
+				if s.Telegram == "alice" {
+					a = s
+				}
+			}
+
+			q, isQueued := g.pending["alice"]
+			tt.check(t, a, q, isQueued)
+		})
+	}
+}
+
+func TestSyncWritesPushedAddress(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t, []interface{}{"alice", "Alice", "checked", "Moscow", "нет"})
+	r := &Repo{AddressStore: NewMemoryStore(), source: g, policy: PolicyDBWinsUserFields}
+
+	a := base()
+	a.SyncHash = syncHash(base())
+	a.Address = "Moscow, Red sq 1"
+
+	if err := r.AddressStore.Upsert(ctx, a); err != nil {
+		t.Fatalf("saving address: %v", err)
+	}
+
+	if _, err := r.sync(ctx); err != nil {
+		t.Fatalf("syncing: %v", err)
+	}
+
+	if err := g.flush(ctx, r.written); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	if rows := fake.Rows(testSpreadsheet, page); rows[1][2] != "checked" || rows[1][3] != "Moscow, Red sq 1" {
+		t.Fatalf("table is %q", rows)
+	}
+
+	// the next run sees both sides equal
+	rep, err := r.sync(ctx)
+	if err != nil {
+		t.Fatalf("syncing again: %v", err)
+	}
+
+	if *rep != (SyncReport{Unchanged: 1}) {
+		t.Fatalf("report is %+v", *rep)
+	}
+
+	if a, _ := r.AddressStore.Get(ctx, "alice"); a.SyncHash != syncHash(a) {
+		t.Fatalf("address isn't marked synced: %+v", a)
+	}
+}
+
+func TestSyncAnnouncesNewRows(t *testing.T) {
+	ctx := context.Background()
+	_, g := newTestSheets(t,
+		[]interface{}{"alice", "Alice", "", "Moscow", "нет"},
+		[]interface{}{"bob", "Bob", "", "Berlin", "да"},
+	)
+	r := &Repo{AddressStore: NewMemoryStore(), source: g, policy: PolicyDBWinsUserFields}
+
+	var created []string
+
+	r.OnCreated(func(_ context.Context, a *model.Address) { created = append(created, a.Telegram) })
+
+	if _, err := r.sync(ctx); err != nil {
+		t.Fatalf("syncing: %v", err)
+	}
+
+	if len(created) != 1 || created[0] != "alice" {
+		t.Fatalf("announced %v, want [alice]", created)
+	}
+
+	pending, err := Pending(ctx, r.AddressStore)
+	if err != nil {
+		t.Fatalf("getting pending: %v", err)
+	}
+
+	if len(pending) != 1 || pending[0].Telegram != "alice" {
+		t.Fatalf("pending are %+v", pending)
+	}
+
+	// known rows aren't announced again
+	if _, err := r.sync(ctx); err != nil {
+		t.Fatalf("syncing again: %v", err)
+	}
+
+	if len(created) != 1 {
+		t.Fatalf("announced %v", created)
+	}
+}
+
+func TestSyncRemovesErasedOnce(t *testing.T) {
+	ctx := context.Background()
+	fake, g := newTestSheets(t, baseRow, []interface{}{"bob", "Bob", "", "Berlin"})
+	r := &Repo{AddressStore: NewMemoryStore(), source: g, policy: PolicyDBWinsUserFields}
+
+	if _, err := r.sync(ctx); err != nil {
+		t.Fatalf("syncing: %v", err)
+	}
+
+	if err := r.AddressStore.Erase(ctx, "alice"); err != nil {
+		t.Fatalf("erasing: %v", err)
+	}
+
+	for i, want := range []SyncReport{{Erased: 1, Unchanged: 1}, {Unchanged: 1}} {
+		rep, err := r.sync(ctx)
+		if err != nil {
+			t.Fatalf("syncing %d: %v", i, err)
+		}
+
+		if *rep != want {
+			t.Fatalf("report %d is %+v, want %+v", i, *rep, want)
+		}
+	}
+
+	if err := g.flush(ctx, r.written); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	if rows := fake.Rows(testSpreadsheet, page); len(rows) != 2 || rows[1][0] != "bob" {
+		t.Fatalf("table is %q", rows)
+	}
+
+	rep, err := r.sync(ctx)
+	if err != nil {
+		t.Fatalf("syncing after removal: %v", err)
+	}
+
+	if *rep != (SyncReport{Unchanged: 1}) {
+		t.Fatalf("report after removal is %+v", *rep)
+	}
+}
@@ -2,10 +2,8 @@ package db
 
 import (
 	"context"
-	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/grbit/post_bot/internal/model"
@@ -13,45 +11,27 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 	"golang.org/x/xerrors"
-	"google.golang.org/api/sheets/v4"
 )
 
-// range concepts https://developers.google.com/sheets/api/guides/concepts#expandable-1
-const (
-	page      = "Лист1"
-	readRange = page + "!A:Z"
-)
-
-func init() {
-	cache.Persons = make(map[string]*model.Address)
-}
-
-type everyone struct {
-	Persons map[string]*model.Address
-	sync.RWMutex
-}
-
-func (e *everyone) Add(a *model.Address) {
-	e.Lock()
-	defer e.Unlock()
-
-	e.Persons[a.Telegram] = a
-}
-
-var cache everyone // almost not used, probably better remove
-
 func InitDataUpdater(
 	ctx context.Context,
-	postgresURL string,
-	spID string,
+	store AddressStore,
+	source SyncSource,
+	policy string,
 ) (*Repo, error) {
-	repo, err := initGlobalDB(ctx, postgresURL, spID)
-	if err != nil {
-		return nil, xerrors.Errorf("init global DB: %w", err)
+	if policy != PolicyLastWriterWins && policy != PolicyDBWinsUserFields {
+		return nil, xerrors.Errorf("unknown sync policy %q", policy)
+	}
+
+	repo := &Repo{
+		AddressStore: store,
+		source:       source,
+		policy:       policy,
 	}
 
+	// the database works without the sync source, so it's not a reason to fail
 	if err := repo.updateData(ctx); err != nil {
-		return nil, xerrors.Errorf("update data: %w", err)
+		log.Error().Err(err).Msg("initial data update")
 	}
 
 	log.Debug().Msg("InitDataUpdater done")
@@ -63,186 +43,91 @@ func (r *Repo) RunDataUpdater(
 	ctx context.Context,
 	dataReloadTimeout time.Duration,
 ) {
-	for range time.NewTicker(dataReloadTimeout).C {
-		if err := r.updateData(ctx); err != nil {
-			log.Error().Err(err).Msg("updating data")
-		}
-	}
-}
-
-func (r *Repo) updateData(ctx context.Context) error {
-	aa, err := r.getAddressesFromGoogle(ctx)
-	if err != nil {
-		return xerrors.Errorf("getting addresses: %w", err)
-	}
+	if !r.syncing() {
+		log.Info().Msg("no sync source, data updater isn't needed")
 
-	if err = globalRepo.upsertAddresses(aa); err != nil {
-		return xerrors.Errorf("upserting addresses: %w", err)
+		return
 	}
 
-	log.Debug().Interface("lenAddresses", len(aa)).Msg("Addresses upserted")
+	if q, ok := r.source.(queuedSource); ok {
+		queueDone := make(chan struct{})
 
-	cache.Lock()
-	defer cache.Unlock()
-
-	for i := range aa {
-		cache.Persons[aa[i].Telegram] = aa[i]
-	}
+		go func() {
+			defer close(queueDone)
+			q.RunQueue(ctx, r.written)
+		}()
 
-	log.Debug().Msg("cache updated")
-
-	return nil
-}
-
-func (r *Repo) getAddressesFromGoogle(ctx context.Context) ([]*model.Address, error) {
-	resp, err := r.sheets.Spreadsheets.Values.Get(globalRepo.sheetID, readRange).Do()
-	if err != nil {
-		return nil, xerrors.Errorf("retrieving data from sheet %q: %w", globalRepo.sheetID, err)
+		// the queue is flushed on shutdown, it must be done before DB is closed
+		defer func() { <-queueDone }()
 	}
 
-	if len(resp.Values) == 0 {
-		return nil, xerrors.Errorf("no data found in spreadsheet %q", globalRepo.sheetID)
-	}
-
-	persons := []*model.Address{}
-
-	for _, rowColumns := range resp.Values {
-		row := make([]string, len(rowColumns))
-		for i, column := range rowColumns {
-			row[i] = column.(string)
-		}
-
-		if len(row) < 2 {
-			log.Debug().Interface("row", row).Msg("Skipping too short row")
-
-			continue
-		}
-
-		if lo.Contains(row, "Телеграм") {
-			// Телеграм	Инстаграмм	Имя и фамилия	Адрес	Пожелания
-			log.Debug().Interface("row", row).Msg("Skipping main row")
+	ticker := time.NewTicker(dataReloadTimeout)
+	defer ticker.Stop()
 
-			continue
-		}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("data updater stopped")
 
-		a := model.Address{}
-
-		for i := range row {
-			switch i {
-			case 0:
-				a.Telegram = strings.ToLower(row[i])
-			case 1:
-				a.Instagram = strings.ToLower(row[i])
-			case 2:
-				a.PersonName = row[i]
-			case 3:
-				a.Address = row[i]
-			case 4:
-				a.Wishes = row[i]
-			case 5:
-				a.Approved = parseBool(row[i])
+			return
+		case <-ticker.C:
+			if err := r.updateData(ctx); err != nil {
+				log.Error().Err(err).Msg("updating data")
 			}
 		}
-
-		persons = append(persons, &a)
-
-		log.Trace().
-			Interface("row", row).
-			Interface("person", a).
-			Msg("Row added")
 	}
-
-	log.Info().Int("persons", len(persons)).Msg("persons readed")
-
-	return persons, nil
 }
 
-var trueValues = []string{"1", "да", "Да", "ДА", "ок", "Ок", "ОК", "ок", "ОК", "Ок", "true", "True", "TRUE", "yes", "Yes", "YES"}
+func (r *Repo) updateData(ctx context.Context) error {
+	if !r.syncing() {
+		return nil
+	}
 
-func parseBool(s string) bool {
-	b, _ := strconv.ParseBool(s)
+	_, err := r.Reload(ctx)
 
-	return b || lo.Contains(trueValues, strings.TrimSpace(s))
+	return err
 }
 
-func (r *Repo) pushAddressToGoogleTable(a *model.Address) error {
-	resp, err := r.sheets.Spreadsheets.Values.Get(globalRepo.sheetID, readRange).Do()
-	if err != nil {
-		return xerrors.Errorf("retrieving data from sheet %q: %w", globalRepo.sheetID, err)
-	}
+// ErrNoSync is returned by Reload if there is no sync source.
+var ErrNoSync = xerrors.New("there is no sync source")
 
-	if len(resp.Values) == 0 {
-		return xerrors.Errorf("no data found in spreadsheet %q", globalRepo.sheetID)
+// Reload syncs with the sync source now, without waiting for the updater.
+func (r *Repo) Reload(ctx context.Context) (*SyncReport, error) {
+	if !r.syncing() {
+		return nil, ErrNoSync
 	}
 
-	for i, rowColumns := range resp.Values {
-		row := make([]string, len(rowColumns))
-		for i, column := range rowColumns {
-			row[i] = column.(string)
-		}
-
-		if len(row) < 4 {
-			log.Debug().Interface("row", row).Msg("Skipping too short row")
-
-			continue
-		}
-
-		if lo.Contains(row, "Телеграм") {
-			// Телеграм	Инстаграмм	Имя и фамилия	Адрес	Пожелания
-			log.Debug().Interface("row", row).Msg("Skipping main row")
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
 
-			continue
-		}
+	started := time.Now()
 
-		if row[0] == a.Telegram {
-			log.Debug().Interface("row", row).Interface("telegram", a.Telegram).Msg("row found, updating")
-
-			// updating all the data
-			vr := &sheets.ValueRange{
-				Values: [][]interface{}{
-					{
-						a.Telegram,
-						a.Instagram,
-						a.PersonName,
-						a.Address,
-						a.Wishes,
-					},
-				},
-			}
+	rep, err := r.sync(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("syncing: %w", err)
+	}
 
-			updRange := fmt.Sprintf("%s!%d:%d", page, i+1, i+1)
+	ev := log.Info()
+	if rep.Created+rep.FromTable+rep.Pushed+rep.Conflicts+rep.Deleted+rep.Erased+rep.Failed == 0 {
+		ev = log.Debug()
+	}
 
-			_, err = r.sheets.Spreadsheets.Values.Update(globalRepo.sheetID, updRange, vr).
-				ValueInputOption("USER_ENTERED").Do()
-			if err != nil {
-				return xerrors.Errorf("updating data to sheet %q: %w", globalRepo.sheetID, err)
-			}
+	ev.Interface("report", rep).Dur("took", time.Since(started)).Msg("sync done")
 
-			return nil
-		}
-	}
+	return rep, nil
+}
 
-	log.Debug().Interface("telegram", a.Telegram).Msg("row not found, appending")
-
-	// append to google table
-	vr := &sheets.ValueRange{
-		Values: [][]interface{}{
-			{
-				a.Telegram,
-				a.Instagram,
-				a.PersonName,
-				a.Address,
-				a.Wishes,
-			},
-		},
+// written is called by queued sync source for every address it has written.
+func (r *Repo) written(ctx context.Context, a *model.Address) {
+	if err := r.markSynced(ctx, a); err != nil {
+		log.Error().Err(err).Str("telegram", a.Telegram).Msg("marking address synced")
 	}
+}
 
-	_, err = r.sheets.Spreadsheets.Values.Append(globalRepo.sheetID, readRange, vr).ValueInputOption("USER_ENTERED").Do()
-	if err != nil {
-		return xerrors.Errorf("appending data to sheet %q: %w", globalRepo.sheetID, err)
-	}
+var trueValues = []string{"1", "да", "Да", "ДА", "ок", "Ок", "ОК", "ок", "ОК", "Ок", "true", "True", "TRUE", "yes", "Yes", "YES"}
 
-	log.Debug().Interface("telegram", a.Telegram).Msg("row appended")
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(s)
 
-	return nil
+	return b || lo.Contains(trueValues, strings.TrimSpace(s))
 }
@@ -0,0 +1,100 @@
+package requests
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"github.com/samber/lo"
+)
+
+type memoryStore struct {
+	requests []model.AddressRequest
+	sync.Mutex
+}
+
+// NewMemoryStore returns process-local RequestStore, the history is lost on restart.
+func NewMemoryStore() RequestStore {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Add(_ context.Context, r *model.AddressRequest) error {
+	m.Lock()
+	defer m.Unlock()
+
+	r.ID = int64(len(m.requests) + 1)
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = r.CreatedAt
+
+	m.requests = append(m.requests, *r)
+
+	return nil
+}
+
+func (m *memoryStore) Recipients(_ context.Context, requesterID int64) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var tgs []string
+
+	for _, r := range m.requests {
+		if r.RequesterID == requesterID {
+			tgs = append(tgs, r.Recipient)
+		}
+	}
+
+	return lo.Uniq(tgs), nil
+}
+
+func (m *memoryStore) CountSince(_ context.Context, requesterID int64, since time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return lo.CountBy(m.requests, func(r model.AddressRequest) bool {
+		return r.RequesterID == requesterID && !r.CreatedAt.Before(since)
+	}), nil
+}
+
+func (m *memoryStore) History(_ context.Context, requesterID int64) ([]*model.AddressRequest, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var rr []*model.AddressRequest
+
+	for _, r := range m.requests {
+		if r.RequesterID == requesterID {
+			c := r
+			rr = append(rr, &c)
+		}
+	}
+
+	return rr, nil
+}
+
+func (m *memoryStore) Received(_ context.Context) (map[string]model.Received, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	rr := make(map[string]model.Received)
+	for _, r := range m.requests {
+		received(rr, r)
+	}
+
+	return rr, nil
+}
+
+func (m *memoryStore) Forget(_ context.Context, recipient string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for i := range m.requests {
+		if m.requests[i].Recipient == recipient {
+			m.requests[i].Recipient = model.ErasedTelegram
+			m.requests[i].UpdatedAt = time.Now()
+		}
+	}
+
+	return nil
+}
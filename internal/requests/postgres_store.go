@@ -0,0 +1,87 @@
+package requests
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+type postgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore returns RequestStore backed by the `address_requests` table.
+func NewPostgresStore(db *gorm.DB) RequestStore {
+	return &postgresStore{db: db}
+}
+
+func (p *postgresStore) Add(ctx context.Context, r *model.AddressRequest) error {
+	if err := p.db.WithContext(ctx).Create(r).Error; err != nil {
+		return xerrors.Errorf("saving request (requester_id=%d, recipient=%q): %w", r.RequesterID, r.Recipient, err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Recipients(ctx context.Context, requesterID int64) ([]string, error) {
+	var tgs []string
+
+	err := p.db.WithContext(ctx).Model(&model.AddressRequest{}).
+		Distinct().Where("requester_id = ?", requesterID).Pluck("recipient", &tgs).Error
+	if err != nil {
+		return nil, xerrors.Errorf("getting recipients (requester_id=%d): %w", requesterID, err)
+	}
+
+	return tgs, nil
+}
+
+func (p *postgresStore) CountSince(ctx context.Context, requesterID int64, since time.Time) (int, error) {
+	var n int64
+
+	err := p.db.WithContext(ctx).Model(&model.AddressRequest{}).
+		Where("requester_id = ? AND created_at >= ?", requesterID, since).Count(&n).Error
+	if err != nil {
+		return 0, xerrors.Errorf("counting requests (requester_id=%d): %w", requesterID, err)
+	}
+
+	return int(n), nil
+}
+
+func (p *postgresStore) Received(ctx context.Context) (map[string]model.Received, error) {
+	// there are only as many requests as postcards, so they are counted here, the same way for every dialect
+	var requests []model.AddressRequest
+	if err := p.db.WithContext(ctx).Select("recipient", "created_at").Find(&requests).Error; err != nil {
+		return nil, xerrors.Errorf("listing requests: %w", err)
+	}
+
+	rr := make(map[string]model.Received)
+	for _, r := range requests {
+		received(rr, r)
+	}
+
+	return rr, nil
+}
+
+func (p *postgresStore) History(ctx context.Context, requesterID int64) ([]*model.AddressRequest, error) {
+	rr := []*model.AddressRequest{}
+	if err := p.db.WithContext(ctx).Where("requester_id = ?", requesterID).Order("id").Find(&rr).Error; err != nil {
+		return nil, xerrors.Errorf("listing requests (requester_id=%d): %w", requesterID, err)
+	}
+
+	return rr, nil
+}
+
+func (p *postgresStore) Forget(ctx context.Context, recipient string) error {
+	err := dbtx.DB(ctx, p.db).Model(&model.AddressRequest{}).Where("recipient = ?", recipient).
+		Updates(map[string]interface{}{"recipient": model.ErasedTelegram, "updated_at": time.Now()}).Error
+	if err != nil {
+		return xerrors.Errorf("forgetting recipient (recipient=%q): %w", recipient, err)
+	}
+
+	return nil
+}
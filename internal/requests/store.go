@@ -0,0 +1,36 @@
+// Package requests keeps the history of addresses given to participants.
+package requests
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// received adds the request to the recipients statistics.
+func received(rr map[string]model.Received, r model.AddressRequest) {
+	s := rr[r.Recipient]
+	s.Count++
+
+	if r.CreatedAt.After(s.LastAt) {
+		s.LastAt = r.CreatedAt
+	}
+
+	rr[r.Recipient] = s
+}
+
+// RequestStore keeps who got whose address.
+type RequestStore interface {
+	Add(ctx context.Context, r *model.AddressRequest) error
+	// Recipients returns telegram nicks of everyone whose address the requester has got.
+	Recipients(ctx context.Context, requesterID int64) ([]string, error)
+	// CountSince returns how many addresses the requester has got since the time.
+	CountSince(ctx context.Context, requesterID int64, since time.Time) (int, error)
+	// Received returns how often everyone who has been drawn was drawn, by telegram nick.
+	Received(ctx context.Context) (map[string]model.Received, error)
+	// History returns addresses given to the requester, the oldest first.
+	History(ctx context.Context, requesterID int64) ([]*model.AddressRequest, error)
+	// Forget replaces the recipient's nick with model.ErasedTelegram, the requests still count in quotas.
+	Forget(ctx context.Context, recipient string) error
+}
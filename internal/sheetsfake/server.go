@@ -0,0 +1,671 @@
+// Package sheetsfake is an in-memory stand-in for the subset of Google Sheets v4 API the sync uses:
+// values.get, values.update, values.append, values.batchUpdate, and spreadsheets.get and spreadsheets.batchUpdate
+// for sheet properties and deleting rows.
+// Point the client to it with option.WithEndpoint(server.URL) and option.WithoutAuthentication().
+//
+// Like the real API, USER_ENTERED input turns numeric strings into numbers, "'" keeps a string as is,
+// and formatted values omit trailing empty cells and rows.
+package sheetsfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server serves spreadsheets made with AddSheet.
+type Server struct {
+	*httptest.Server
+
+	// spreadsheets maps spreadsheet ID to its sheets by name
+	spreadsheets map[string]map[string]*grid
+	// failures are error codes returned instead of handling next requests
+	failures []int
+	calls    map[string]int
+	sync.Mutex
+}
+
+// grid is rows of cells, a cell is string, float64, bool or nil.
+type grid struct {
+	// id is the sheet ID, sheets get them in the order they are added starting with 0 like the real ones
+	id   int64
+	rows [][]interface{}
+}
+
+// NewServer starts the server, it must be closed with Close.
+func NewServer() *Server {
+	s := &Server{
+		spreadsheets: make(map[string]map[string]*grid),
+		calls:        make(map[string]int),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// AddSheet creates the sheet with the rows, the spreadsheet is created if needed.
+// Rows may have cells of any JSON type, e.g. numbers, to check how malformed tables are read.
+func (s *Server) AddSheet(spreadsheetID, sheet string, rows [][]interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.spreadsheets[spreadsheetID] == nil {
+		s.spreadsheets[spreadsheetID] = make(map[string]*grid)
+	}
+
+	g := &grid{id: int64(len(s.spreadsheets[spreadsheetID]))}
+	if old := s.spreadsheets[spreadsheetID][sheet]; old != nil {
+		g.id = old.id
+	}
+
+	for _, r := range rows {
+		g.rows = append(g.rows, append([]interface{}(nil), r...))
+	}
+
+	s.spreadsheets[spreadsheetID][sheet] = g
+}
+
+// Rows returns formatted values of the sheet, the same as values.get returns.
+func (s *Server) Rows(spreadsheetID, sheet string) [][]string {
+	s.Lock()
+	defer s.Unlock()
+
+	g := s.spreadsheets[spreadsheetID][sheet]
+	if g == nil {
+		return nil
+	}
+
+	values := g.read(rangeRef{sheet: sheet, toCol: -1, toRow: -1})
+	rows := make([][]string, len(values))
+
+	for i, r := range values {
+		rows[i] = make([]string, len(r))
+		for j, c := range r {
+			rows[i][j], _ = c.(string)
+		}
+	}
+
+	return rows
+}
+
+// FailNext makes the next n requests fail with the HTTP code, e.g. 429 for exceeded quota.
+func (s *Server) FailNext(n, code int) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, code)
+	}
+}
+
+// Calls returns how many times the method was called: "get", "update", "append" or "batchUpdate" of values,
+// "getSpreadsheet" or "batchUpdateSpreadsheet".
+// Failed requests are counted too.
+func (s *Server) Calls(method string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.calls[method]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v4/spreadsheets/")
+
+	var id, rest, method, rng string
+
+	if i := strings.Index(path, "/values"); i >= 0 {
+		id, rest = path[:i], path[i+len("/values"):]
+	} else {
+		id, rest = strings.TrimSuffix(path, ":batchUpdate"), "spreadsheet"
+	}
+
+	switch {
+	case rest == "spreadsheet" && strings.HasSuffix(path, ":batchUpdate") && r.Method == http.MethodPost:
+		method = "batchUpdateSpreadsheet"
+	case rest == "spreadsheet" && r.Method == http.MethodGet:
+		method = "getSpreadsheet"
+	case rest == ":batchUpdate" && r.Method == http.MethodPost:
+		method = "batchUpdate"
+	case strings.HasSuffix(rest, ":append") && r.Method == http.MethodPost:
+		method, rng = "append", strings.TrimSuffix(rest[1:], ":append")
+	case strings.HasPrefix(rest, "/") && r.Method == http.MethodGet:
+		method, rng = "get", rest[1:]
+	case strings.HasPrefix(rest, "/") && r.Method == http.MethodPut:
+		method, rng = "update", rest[1:]
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "method not found")
+
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.calls[method]++
+
+	if len(s.failures) > 0 {
+		code := s.failures[0]
+		s.failures = s.failures[1:]
+
+		writeError(w, code, statusName(code), "failure injected by the fake")
+
+		return
+	}
+
+	sheets, ok := s.spreadsheets[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Requested entity was not found.")
+
+		return
+	}
+
+	var (
+		resp interface{}
+		err  error
+	)
+
+	switch method {
+	case "get":
+		resp, err = s.get(sheets, rng)
+	case "update":
+		resp, err = s.update(r, id, sheets, rng)
+	case "append":
+		resp, err = s.append(r, id, sheets, rng)
+	case "batchUpdate":
+		resp, err = s.batchUpdate(r, id, sheets)
+	case "getSpreadsheet":
+		resp = s.getSpreadsheet(id, sheets)
+	case "batchUpdateSpreadsheet":
+		resp, err = s.batchUpdateSpreadsheet(r, id, sheets)
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type valueRange struct {
+	Range          string          `json:"range,omitempty"`
+	MajorDimension string          `json:"majorDimension,omitempty"`
+	Values         [][]interface{} `json:"values,omitempty"`
+}
+
+type updateResponse struct {
+	SpreadsheetID  string `json:"spreadsheetId"`
+	UpdatedRange   string `json:"updatedRange"`
+	UpdatedRows    int    `json:"updatedRows"`
+	UpdatedColumns int    `json:"updatedColumns"`
+	UpdatedCells   int    `json:"updatedCells"`
+}
+
+func (s *Server) get(sheets map[string]*grid, rng string) (interface{}, error) {
+	ref, g, err := lookup(sheets, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	return valueRange{Range: ref.String(), MajorDimension: "ROWS", Values: g.read(ref)}, nil
+}
+
+func (s *Server) update(r *http.Request, id string, sheets map[string]*grid, rng string) (interface{}, error) {
+	var vr valueRange
+	if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+
+	return write(id, sheets, rng, vr.Values, r.URL.Query().Get("valueInputOption"))
+}
+
+func (s *Server) batchUpdate(r *http.Request, id string, sheets map[string]*grid) (interface{}, error) {
+	var req struct {
+		ValueInputOption string       `json:"valueInputOption"`
+		Data             []valueRange `json:"data"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+
+	// the real API checks every range before writing anything
+	for _, d := range req.Data {
+		if _, _, err := lookup(sheets, d.Range); err != nil {
+			return nil, err
+		}
+	}
+
+	res := struct {
+		SpreadsheetID     string           `json:"spreadsheetId"`
+		TotalUpdatedRows  int              `json:"totalUpdatedRows"`
+		TotalUpdatedCells int              `json:"totalUpdatedCells"`
+		Responses         []updateResponse `json:"responses"`
+	}{SpreadsheetID: id}
+
+	for _, d := range req.Data {
+		u, err := write(id, sheets, d.Range, d.Values, req.ValueInputOption)
+		if err != nil {
+			return nil, err
+		}
+
+		res.TotalUpdatedRows += u.UpdatedRows
+		res.TotalUpdatedCells += u.UpdatedCells
+		res.Responses = append(res.Responses, u)
+	}
+
+	return res, nil
+}
+
+type sheetProperties struct {
+	SheetID int64  `json:"sheetId"`
+	Title   string `json:"title"`
+}
+
+// getSpreadsheet returns properties of the sheets, the rest of the spreadsheet isn't faked.
+func (s *Server) getSpreadsheet(id string, sheets map[string]*grid) interface{} {
+	type sheet struct {
+		Properties sheetProperties `json:"properties"`
+	}
+
+	res := struct {
+		SpreadsheetID string  `json:"spreadsheetId"`
+		Sheets        []sheet `json:"sheets"`
+	}{SpreadsheetID: id}
+
+	for title, g := range sheets {
+		res.Sheets = append(res.Sheets, sheet{Properties: sheetProperties{SheetID: g.id, Title: title}})
+	}
+
+	sort.Slice(res.Sheets, func(i, j int) bool { return res.Sheets[i].Properties.SheetID < res.Sheets[j].Properties.SheetID })
+
+	return res
+}
+
+// batchUpdateSpreadsheet applies requests in order, only deleteDimension of rows is supported.
+func (s *Server) batchUpdateSpreadsheet(r *http.Request, id string, sheets map[string]*grid) (interface{}, error) {
+	var req struct {
+		Requests []struct {
+			DeleteDimension *struct {
+				Range struct {
+					SheetID    int64  `json:"sheetId"`
+					Dimension  string `json:"dimension"`
+					StartIndex int    `json:"startIndex"`
+					EndIndex   int    `json:"endIndex"`
+				} `json:"range"`
+			} `json:"deleteDimension"`
+		} `json:"requests"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+
+	byID := make(map[int64]*grid, len(sheets))
+	for _, g := range sheets {
+		byID[g.id] = g
+	}
+
+	// the real API applies all requests or none
+	for _, rq := range req.Requests {
+		if rq.DeleteDimension == nil || rq.DeleteDimension.Range.Dimension != "ROWS" {
+			return nil, fmt.Errorf("request isn't supported by the fake")
+		}
+
+		rng := rq.DeleteDimension.Range
+		if byID[rng.SheetID] == nil {
+			return nil, fmt.Errorf("no grid with id: %d", rng.SheetID)
+		}
+
+		if rng.StartIndex < 0 || rng.EndIndex <= rng.StartIndex {
+			return nil, fmt.Errorf("invalid dimension range [%d, %d)", rng.StartIndex, rng.EndIndex)
+		}
+	}
+
+	replies := make([]struct{}, len(req.Requests))
+
+	for _, rq := range req.Requests {
+		rng := rq.DeleteDimension.Range
+		g := byID[rng.SheetID]
+
+		if rng.StartIndex >= len(g.rows) {
+			continue
+		}
+
+		end := rng.EndIndex
+		if end > len(g.rows) {
+			end = len(g.rows)
+		}
+
+		g.rows = append(g.rows[:rng.StartIndex], g.rows[end:]...)
+	}
+
+	return struct {
+		SpreadsheetID string     `json:"spreadsheetId"`
+		Replies       []struct{} `json:"replies"`
+	}{SpreadsheetID: id, Replies: replies}, nil
+}
+
+// append writes values below the last non-empty row of the range, starting at its first column.
+func (s *Server) append(r *http.Request, id string, sheets map[string]*grid, rng string) (interface{}, error) {
+	var vr valueRange
+	if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+
+	ref, g, err := lookup(sheets, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	last := -1
+
+	for i, row := range g.rows {
+		for j, c := range row {
+			if ref.hasColumn(j) && format(c) != "" {
+				last = i
+
+				break
+			}
+		}
+	}
+
+	table := rangeRef{sheet: ref.sheet, fromCol: ref.fromCol, toCol: ref.toCol, fromRow: 0, toRow: last}
+
+	at := rangeRef{sheet: ref.sheet, fromCol: ref.fromCol, toCol: -1, fromRow: last + 1, toRow: -1}
+
+	u, err := write(id, sheets, at.String(), vr.Values, r.URL.Query().Get("valueInputOption"))
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		SpreadsheetID string         `json:"spreadsheetId"`
+		TableRange    string         `json:"tableRange,omitempty"`
+		Updates       updateResponse `json:"updates"`
+	}{SpreadsheetID: id, TableRange: table.String(), Updates: u}, nil
+}
+
+// write puts values into the grid starting at the top left cell of the range.
+func write(id string, sheets map[string]*grid, rng string, values [][]interface{}, input string) (updateResponse, error) {
+	if input != "RAW" && input != "USER_ENTERED" {
+		return updateResponse{}, fmt.Errorf("invalid valueInputOption: %q", input)
+	}
+
+	ref, g, err := lookup(sheets, rng)
+	if err != nil {
+		return updateResponse{}, err
+	}
+
+	width := 0
+
+	for i, row := range values {
+		if ref.toRow >= 0 && ref.fromRow+i > ref.toRow {
+			return updateResponse{}, fmt.Errorf("requested writing within range [%s], but tried writing to row [%d]",
+				ref, ref.fromRow+i+1)
+		}
+
+		if len(row) > width {
+			width = len(row)
+		}
+
+		for j, c := range row {
+			if ref.toCol >= 0 && ref.fromCol+j > ref.toCol {
+				return updateResponse{}, fmt.Errorf("requested writing within range [%s], but tried writing to column [%s]",
+					ref, columnName(ref.fromCol+j))
+			}
+
+			if input == "USER_ENTERED" {
+				c = userEntered(c)
+			}
+
+			g.set(ref.fromRow+i, ref.fromCol+j, c)
+		}
+	}
+
+	written := rangeRef{
+		sheet:   ref.sheet,
+		fromCol: ref.fromCol,
+		toCol:   ref.fromCol + width - 1,
+		fromRow: ref.fromRow,
+		toRow:   ref.fromRow + len(values) - 1,
+	}
+
+	return updateResponse{
+		SpreadsheetID:  id,
+		UpdatedRange:   written.String(),
+		UpdatedRows:    len(values),
+		UpdatedColumns: width,
+		UpdatedCells:   len(values) * width,
+	}, nil
+}
+
+func lookup(sheets map[string]*grid, rng string) (rangeRef, *grid, error) {
+	ref, err := parseRange(rng)
+	if err != nil {
+		return ref, nil, err
+	}
+
+	g, ok := sheets[ref.sheet]
+	if !ok {
+		return ref, nil, fmt.Errorf("unable to parse range: %s", rng)
+	}
+
+	return ref, g, nil
+}
+
+func (g *grid) set(row, col int, c interface{}) {
+	for len(g.rows) <= row {
+		g.rows = append(g.rows, nil)
+	}
+
+	for len(g.rows[row]) <= col {
+		g.rows[row] = append(g.rows[row], nil)
+	}
+
+	g.rows[row][col] = c
+}
+
+// read returns formatted values in the range without trailing empty cells and rows.
+func (g *grid) read(ref rangeRef) [][]interface{} {
+	var res [][]interface{}
+
+	for i := ref.fromRow; i < len(g.rows) && (ref.toRow < 0 || i <= ref.toRow); i++ {
+		var row []interface{}
+
+		for j := ref.fromCol; j < len(g.rows[i]) && (ref.toCol < 0 || j <= ref.toCol); j++ {
+			row = append(row, format(g.rows[i][j]))
+		}
+
+		for len(row) > 0 && row[len(row)-1] == "" {
+			row = row[:len(row)-1]
+		}
+
+		res = append(res, row)
+	}
+
+	for len(res) > 0 && len(res[len(res)-1]) == 0 {
+		res = res[:len(res)-1]
+	}
+
+	for i := range res {
+		if res[i] == nil {
+			res[i] = []interface{}{}
+		}
+	}
+
+	return res
+}
+
+// userEntered parses the value as if it was typed into the cell.
+func userEntered(c interface{}) interface{} {
+	str, ok := c.(string)
+	if !ok {
+		return c
+	}
+
+	if strings.HasPrefix(str, "'") {
+		return str[1:]
+	}
+
+	switch strings.ToUpper(str) {
+	case "TRUE":
+		return true
+	case "FALSE":
+		return false
+	}
+
+	if f, err := strconv.ParseFloat(strings.TrimSpace(str), 64); err == nil {
+		return f
+	}
+
+	return str
+}
+
+// format returns the value as FORMATTED_VALUE render option does.
+func format(c interface{}) string {
+	switch v := c.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// rangeRef is a parsed A1 range, indexes are 0-based, -1 means unbounded.
+type rangeRef struct {
+	sheet          string
+	fromCol, toCol int
+	fromRow, toRow int
+}
+
+var cellRe = regexp.MustCompile(`^([A-Z]*)(\d*)$`)
+
+// parseRange parses ranges like Sheet!A:Z, Sheet!A2:C2, Sheet!2:2, 'My sheet'!A1 or Sheet.
+func parseRange(rng string) (rangeRef, error) {
+	ref := rangeRef{toCol: -1, toRow: -1}
+
+	sheet, cells, found := strings.Cut(rng, "!")
+	ref.sheet = strings.Trim(sheet, "'")
+
+	if !found {
+		return ref, nil
+	}
+
+	from, to, isRange := strings.Cut(cells, ":")
+	if !isRange {
+		to = from
+	}
+
+	fm, tm := cellRe.FindStringSubmatch(strings.ToUpper(from)), cellRe.FindStringSubmatch(strings.ToUpper(to))
+	if fm == nil || tm == nil || from == "" || to == "" {
+		return ref, fmt.Errorf("unable to parse range: %s", rng)
+	}
+
+	ref.fromCol, ref.toCol = columnIndex(fm[1], 0), columnIndex(tm[1], -1)
+	ref.fromRow, ref.toRow = rowIndex(fm[2], 0), rowIndex(tm[2], -1)
+
+	return ref, nil
+}
+
+func (r rangeRef) hasColumn(i int) bool {
+	return i >= r.fromCol && (r.toCol < 0 || i <= r.toCol)
+}
+
+func (r rangeRef) String() string {
+	from := columnName(r.fromCol) + strconv.Itoa(r.fromRow+1)
+	to := ""
+
+	if r.toCol >= 0 {
+		to += columnName(r.toCol)
+	} else {
+		to += "Z"
+	}
+
+	if r.toRow >= 0 {
+		to += strconv.Itoa(r.toRow + 1)
+	}
+
+	return r.sheet + "!" + from + ":" + to
+}
+
+func columnIndex(s string, empty int) int {
+	if s == "" {
+		return empty
+	}
+
+	n := 0
+	for _, c := range s {
+		n = n*26 + int(c-'A') + 1
+	}
+
+	return n - 1
+}
+
+func rowIndex(s string, empty int) int {
+	if s == "" {
+		return empty
+	}
+
+	n, _ := strconv.Atoi(s)
+
+	return n - 1
+}
+
+// columnName converts column index to A1 notation: 0 is A, 26 is AA.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+
+	return name
+}
+
+func statusName(code int) string {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case code == http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case code >= http.StatusInternalServerError:
+		return "INTERNAL"
+	case code == http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case code == http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case code == http.StatusNotFound:
+		return "NOT_FOUND"
+	default:
+		return "INVALID_ARGUMENT"
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, status, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  status,
+		},
+	})
+}
@@ -0,0 +1,67 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/exp/maps"
+)
+
+type memoryStore struct {
+	ttl    time.Duration
+	states map[int64]*model.State
+	sync.Mutex
+}
+
+// NewMemoryStore returns process-local StateStore. State is lost on restart.
+func NewMemoryStore(ttl time.Duration) StateStore {
+	return &memoryStore{
+		ttl:    ttl,
+		states: make(map[int64]*model.State),
+	}
+}
+
+func (m *memoryStore) Get(_ context.Context, chatID int64) (*model.State, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	s, ok := m.states[chatID]
+	if !ok {
+		return newState(chatID), nil
+	}
+
+	st := copyState(s)
+	expire(st, m.ttl, time.Now())
+
+	return st, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, st *model.State) error {
+	m.Lock()
+	defer m.Unlock()
+
+	st.UpdatedAt = time.Now()
+	if st.CreatedAt.IsZero() {
+		st.CreatedAt = st.UpdatedAt
+	}
+
+	m.states[st.ChatID] = copyState(st)
+
+	return nil
+}
+
+func copyState(s *model.State) *model.State {
+	st := *s
+	st.FileIDs = maps.Clone(s.FileIDs)
+	st.History = append([]string(nil), s.History...)
+	st.FlowData = maps.Clone(s.FlowData)
+
+	if st.FileIDs == nil {
+		st.FileIDs = make(map[string]string)
+	}
+
+	return &st
+}
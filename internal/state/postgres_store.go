@@ -0,0 +1,60 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postgresStore struct {
+	db  *gorm.DB
+	ttl time.Duration
+}
+
+// NewPostgresStore returns StateStore backed by the `states` table,
+// so multi-step flows survive restarts and can be shared between replicas.
+func NewPostgresStore(db *gorm.DB, ttl time.Duration) StateStore {
+	return &postgresStore{db: db, ttl: ttl}
+}
+
+func (p *postgresStore) Get(ctx context.Context, chatID int64) (*model.State, error) {
+	ss := []*model.State{}
+	if err := p.db.WithContext(ctx).Find(&ss, "chat_id = ?", chatID).Error; err != nil {
+		return nil, xerrors.Errorf("searching state (chat_id=%d): %w", chatID, err)
+	}
+
+	if len(ss) == 0 {
+		return newState(chatID), nil
+	}
+
+	st := ss[0]
+	if st.FileIDs == nil {
+		st.FileIDs = make(map[string]string)
+	}
+
+	expire(st, p.ttl, time.Now())
+
+	return st, nil
+}
+
+func (p *postgresStore) Save(ctx context.Context, st *model.State) error {
+	err := p.db.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "chat_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"telegram", "previous_cmd", "previous_cmd_at", "file_ids",
+				"step", "history", "flow_data", "updated_at",
+			}),
+		},
+	).Create(st).Error
+	if err != nil {
+		return xerrors.Errorf("saving state (chat_id=%d): %w", st.ChatID, err)
+	}
+
+	return nil
+}
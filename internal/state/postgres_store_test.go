@@ -0,0 +1,75 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grbit/post_bot/internal/dbtest"
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// SQL stores are tested on SQLite, the schema is the same as in Postgres, it's made by the same migrations.
+
+func TestSQLStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := NewPostgresStore(dbtest.Open(t), time.Hour)
+
+	st, err := s.Get(ctx, 42)
+	if err != nil {
+		t.Fatalf("getting new state: %v", err)
+	}
+
+	if st.ChatID != 42 || st.PreviousCmd != "" {
+		t.Fatalf("new state is %+v", st)
+	}
+
+	st.Telegram = "alice"
+	st.PreviousCmd = model.CmdRegister
+	st.PreviousCmdAt = time.Now()
+	st.Step = "address"
+	st.History = []string{"person_name"}
+	st.FlowData = map[string]string{"person_name": "Alice"}
+
+	if err := s.Save(ctx, st); err != nil {
+		t.Fatalf("inserting state: %v", err)
+	}
+
+	// the second save goes through ON CONFLICT, so every updated column must exist
+	st.Step = "instagram"
+	st.History = append(st.History, "address")
+	st.FlowData["address"] = "Moscow"
+
+	if err := s.Save(ctx, st); err != nil {
+		t.Fatalf("updating state: %v", err)
+	}
+
+	got, err := s.Get(ctx, 42)
+	if err != nil {
+		t.Fatalf("getting saved state: %v", err)
+	}
+
+	if got.Telegram != "alice" || got.PreviousCmd != model.CmdRegister || got.Step != "instagram" ||
+		len(got.History) != 2 || got.FlowData["address"] != "Moscow" {
+		t.Fatalf("saved state is %+v", got)
+	}
+}
+
+func TestSQLStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	s := NewPostgresStore(dbtest.Open(t), time.Minute)
+
+	st := &model.State{ChatID: 7, PreviousCmd: model.CmdRegister, PreviousCmdAt: time.Now().Add(-time.Hour), Step: "address"}
+	if err := s.Save(ctx, st); err != nil {
+		t.Fatalf("saving state: %v", err)
+	}
+
+	got, err := s.Get(ctx, 7)
+	if err != nil {
+		t.Fatalf("getting state: %v", err)
+	}
+
+	if got.PreviousCmd != "" || got.Step != "" {
+		t.Fatalf("expired flow is kept: %+v", got)
+	}
+}
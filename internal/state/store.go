@@ -0,0 +1,38 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// StateStore keeps per-chat conversation state between updates.
+type StateStore interface {
+	// Get returns state for the chat. If there is no state yet, a new one is returned.
+	Get(ctx context.Context, chatID int64) (*model.State, error)
+	Save(ctx context.Context, st *model.State) error
+}
+
+func newState(chatID int64) *model.State {
+	return &model.State{
+		ChatID:  chatID,
+		FileIDs: make(map[string]string),
+	}
+}
+
+// expire forgets the previous command if it was sent more than ttl ago,
+// so a user who typed /add_address yesterday isn't caught by it today.
+func expire(st *model.State, ttl time.Duration, now time.Time) {
+	if ttl <= 0 || st.PreviousCmd == "" {
+		return
+	}
+
+	if now.Sub(st.PreviousCmdAt) > ttl {
+		st.PreviousCmd = ""
+		st.PreviousCmdAt = time.Time{}
+		st.Step = ""
+		st.History = nil
+		st.FlowData = nil
+	}
+}
@@ -0,0 +1,496 @@
+// Package tgfake is an in-process fake of Telegram Bot API, so the bot can be run end-to-end without Telegram.
+// Users talk to the bot with SendMessage and PressButton, everything the bot sends is kept and can be waited for.
+package tgfake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// BotID and BotUserName are what getMe returns
+	BotID       = 1
+	BotUserName = "fake_post_bot"
+
+	// maxPollWait limits long polling, so the server is closed fast
+	maxPollWait = 5 * time.Second
+)
+
+// User is a telegram user talking to the bot in a private chat, chat ID is the user ID.
+type User struct {
+	ID       int64
+	UserName string
+}
+
+// Sent is a call the bot has made to send something to a chat.
+type Sent struct {
+	Method string
+	ChatID int64
+	// MessageID is ID of the sent message or of the edited one
+	MessageID int
+	Text      string
+	// ReplyMarkup is raw JSON of reply_markup parameter
+	ReplyMarkup string
+	// FileName is set for sent documents
+	FileName string
+	// CallbackQueryID is set for answerCallbackQuery
+	CallbackQueryID string
+}
+
+// Server is a fake Bot API server, only methods the bot uses are implemented.
+type Server struct {
+	*httptest.Server
+	Token string
+
+	updates []tgbotapi.Update
+	sent    []Sent
+	// commands are set by setMyCommands per scope, see scopeKey
+	commands map[string][]tgbotapi.BotCommand
+	// callbacks maps callback query ID to the user who pressed the button
+	callbacks map[string]int64
+	lastID    int
+	// changed is closed and replaced on every change, so waiters can wake up
+	changed chan struct{}
+	done    chan struct{}
+	sync.Mutex
+}
+
+// NewServer starts a fake server, it must be closed with Close.
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:     token,
+		callbacks: make(map[string]int64),
+		commands:  make(map[string][]tgbotapi.BotCommand),
+		changed:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Endpoint is API endpoint format for tgbotapi.NewBotAPIWithAPIEndpoint.
+func (s *Server) Endpoint() string {
+	return s.URL + "/bot%s/%s"
+}
+
+// Close releases pending long polls and stops the server.
+func (s *Server) Close() {
+	close(s.done)
+	s.Server.Close()
+}
+
+// SendMessage sends text from the user to the bot, commands get bot_command entity like in real telegram.
+func (s *Server) SendMessage(u User, text string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	msg := &tgbotapi.Message{
+		MessageID: s.nextID(),
+		From:      &tgbotapi.User{ID: u.ID, UserName: u.UserName, FirstName: u.UserName},
+		Chat:      &tgbotapi.Chat{ID: u.ID, Type: "private", UserName: u.UserName},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+
+	if strings.HasPrefix(text, "/") {
+		cmd := strings.Fields(text)[0]
+		msg.Entities = []tgbotapi.MessageEntity{
+			{Type: "bot_command", Offset: 0, Length: len(utf16.Encode([]rune(cmd)))},
+		}
+	}
+
+	s.addUpdate(tgbotapi.Update{Message: msg})
+
+	return msg.MessageID
+}
+
+// PressButton presses inline button with callback data under the message the bot has sent.
+// It returns callback query ID.
+func (s *Server) PressButton(u User, messageID int, data string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	id := strconv.Itoa(s.nextID())
+	s.callbacks[id] = u.ID
+
+	text, _ := s.message(messageID)
+
+	s.addUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   id,
+		From: &tgbotapi.User{ID: u.ID, UserName: u.UserName, FirstName: u.UserName},
+		Message: &tgbotapi.Message{
+			MessageID: messageID,
+			From:      &tgbotapi.User{ID: BotID, IsBot: true, UserName: BotUserName},
+			Chat:      &tgbotapi.Chat{ID: u.ID, Type: "private", UserName: u.UserName},
+			Text:      text,
+		},
+		ChatInstance: strconv.FormatInt(u.ID, 10),
+		Data:         data,
+	}})
+
+	return id
+}
+
+// Sent returns everything the bot has sent to the chat.
+func (s *Server) Sent(chatID int64) []Sent {
+	s.Lock()
+	defer s.Unlock()
+
+	var res []Sent
+
+	for _, m := range s.sent {
+		if m.ChatID == chatID {
+			res = append(res, m)
+		}
+	}
+
+	return res
+}
+
+// WaitSent waits until the bot has sent more than n things to the chat and returns the n-th one, counting from 0.
+func (s *Server) WaitSent(ctx context.Context, chatID int64, n int) (Sent, error) {
+	for {
+		s.Lock()
+		changed := s.changed
+
+		i := 0
+		for _, m := range s.sent {
+			if m.ChatID != chatID {
+				continue
+			}
+
+			if i == n {
+				s.Unlock()
+
+				return m, nil
+			}
+
+			i++
+		}
+		s.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Sent{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Commands returns commands set by setMyCommands for everyone.
+func (s *Server) Commands() []tgbotapi.BotCommand {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]tgbotapi.BotCommand(nil), s.commands[defaultScope]...)
+}
+
+// CommandsFor returns commands the user sees in the private chat: set for the chat or, if none, for everyone.
+func (s *Server) CommandsFor(chatID int64) []tgbotapi.BotCommand {
+	s.Lock()
+	defer s.Unlock()
+
+	cmds, ok := s.commands["chat:"+strconv.FormatInt(chatID, 10)]
+	if !ok {
+		cmds = s.commands[defaultScope]
+	}
+
+	return append([]tgbotapi.BotCommand(nil), cmds...)
+}
+
+const defaultScope = "default"
+
+// scopeKey returns the key of commands for the scope form value, only default and chat scopes are supported.
+func scopeKey(r *http.Request) (string, bool) {
+	if r.FormValue("scope") == "" {
+		return defaultScope, true
+	}
+
+	var scope tgbotapi.BotCommandScope
+	if err := json.Unmarshal([]byte(r.FormValue("scope")), &scope); err != nil {
+		return "", false
+	}
+
+	switch scope.Type {
+	case defaultScope:
+		return defaultScope, true
+	case "chat":
+		return "chat:" + strconv.FormatInt(scope.ChatID, 10), true
+	}
+
+	return "", false
+}
+
+// message returns the current text of the message the bot has sent, must be called with the lock held.
+func (s *Server) message(id int) (text string, ok bool) {
+	for _, m := range s.sent {
+		if m.MessageID == id && m.Method != "answerCallbackQuery" {
+			text, ok = m.Text, true
+		}
+	}
+
+	return text, ok
+}
+
+// nextID returns a new ID for updates, messages and callback queries, must be called with the lock held.
+func (s *Server) nextID() int {
+	s.lastID++
+
+	return s.lastID
+}
+
+// addUpdate must be called with the lock held.
+func (s *Server) addUpdate(u tgbotapi.Update) {
+	u.UpdateID = s.nextID()
+	s.updates = append(s.updates, u)
+	s.notify()
+}
+
+// notify must be called with the lock held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + s.Token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+
+		return
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(r.URL.Path, prefix)) {
+	case "getme":
+		writeResult(w, tgbotapi.User{ID: BotID, IsBot: true, FirstName: "Fake", UserName: BotUserName})
+	case "getupdates":
+		s.getUpdates(w, r)
+	case "deletewebhook":
+		writeResult(w, true)
+	case "setmycommands", "getmycommands", "deletemycommands":
+		s.myCommands(w, r, strings.ToLower(strings.TrimPrefix(r.URL.Path, prefix)))
+	case "sendmessage":
+		s.send(w, r, Sent{Method: "sendMessage", Text: r.FormValue("text")})
+	case "senddocument":
+		m := Sent{Method: "sendDocument", Text: r.FormValue("caption"), FileName: r.FormValue("document")}
+		if r.MultipartForm != nil && len(r.MultipartForm.File["document"]) > 0 {
+			m.FileName = r.MultipartForm.File["document"][0].Filename
+		}
+
+		s.send(w, r, m)
+	case "editmessagetext":
+		s.edit(w, r, Sent{Method: "editMessageText", Text: r.FormValue("text")})
+	case "editmessagereplymarkup":
+		s.edit(w, r, Sent{Method: "editMessageReplyMarkup"})
+	case "answercallbackquery":
+		id := r.FormValue("callback_query_id")
+
+		s.Lock()
+		chatID := s.callbacks[id]
+		s.Unlock()
+
+		s.record(Sent{
+			Method:          "answerCallbackQuery",
+			ChatID:          chatID,
+			Text:            r.FormValue("text"),
+			CallbackQueryID: id,
+		})
+
+		writeResult(w, true)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+func (s *Server) myCommands(w http.ResponseWriter, r *http.Request, method string) {
+	key, ok := scopeKey(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Bad Request: unsupported scope")
+
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch method {
+	case "getmycommands":
+		writeResult(w, append([]tgbotapi.BotCommand{}, s.commands[key]...))
+	case "deletemycommands":
+		delete(s.commands, key)
+		writeResult(w, true)
+	default:
+		var cmds []tgbotapi.BotCommand
+		if err := json.Unmarshal([]byte(r.FormValue("commands")), &cmds); err != nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: can't parse commands JSON object")
+
+			return
+		}
+
+		s.commands[key] = cmds
+		writeResult(w, true)
+	}
+}
+
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.Lock()
+		changed := s.changed
+
+		res := []tgbotapi.Update{}
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				res = append(res, u)
+			}
+		}
+		s.Unlock()
+
+		if len(res) > 0 {
+			writeResult(w, res)
+
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			writeResult(w, res)
+
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			writeResult(w, res)
+
+			return
+		}
+	}
+}
+
+func (s *Server) send(w http.ResponseWriter, r *http.Request, m Sent) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+
+		return
+	}
+
+	m.ChatID = chatID
+	m.ReplyMarkup = r.FormValue("reply_markup")
+	m = s.record(m)
+
+	msg := tgbotapi.Message{
+		MessageID: m.MessageID,
+		From:      &tgbotapi.User{ID: BotID, IsBot: true, UserName: BotUserName},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      m.Text,
+	}
+
+	if m.FileName != "" {
+		msg.Text = ""
+		msg.Caption = m.Text
+		msg.Document = &tgbotapi.Document{FileID: "file-" + m.FileName, FileName: m.FileName}
+	}
+
+	writeResult(w, msg)
+}
+
+// edit records editing of a message, the edit keeps ID of the message.
+// Text isn't sent when only reply markup is edited, then the message keeps its text.
+func (s *Server) edit(w http.ResponseWriter, r *http.Request, m Sent) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+
+		return
+	}
+
+	m.MessageID, _ = strconv.Atoi(r.FormValue("message_id"))
+	m.ChatID = chatID
+	m.ReplyMarkup = r.FormValue("reply_markup")
+
+	s.Lock()
+	text, ok := s.message(m.MessageID)
+	s.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Bad Request: message to edit not found")
+
+		return
+	}
+
+	if m.Method == "editMessageReplyMarkup" {
+		m.Text = text
+	}
+
+	m = s.record(m)
+
+	writeResult(w, tgbotapi.Message{
+		MessageID: m.MessageID,
+		From:      &tgbotapi.User{ID: BotID, IsBot: true, UserName: BotUserName},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      m.Text,
+	})
+}
+
+func (s *Server) record(m Sent) Sent {
+	s.Lock()
+	defer s.Unlock()
+
+	if m.MessageID == 0 && m.Method != "answerCallbackQuery" {
+		m.MessageID = s.nextID()
+	}
+
+	s.sent = append(s.sent, m)
+	s.notify()
+
+	return m
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":          false,
+		"error_code":  code,
+		"description": description,
+	})
+}
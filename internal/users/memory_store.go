@@ -0,0 +1,100 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+type memoryStore struct {
+	users  map[int64]*model.User
+	lastID int64
+	sync.Mutex
+}
+
+// NewMemoryStore returns process-local UserStore, roles set via bot are lost on restart.
+func NewMemoryStore() UserStore {
+	return &memoryStore{users: make(map[int64]*model.User)}
+}
+
+func (m *memoryStore) Get(_ context.Context, chatID int64) (*model.User, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if u, ok := m.users[chatID]; ok {
+		c := *u
+
+		return &c, nil
+	}
+
+	return &model.User{ChatID: chatID}, nil
+}
+
+func (m *memoryStore) FindByTelegram(_ context.Context, tg string) (*model.User, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Telegram, tg) {
+			c := *u
+
+			return &c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, u *model.User) error {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	if old, ok := m.users[u.ChatID]; ok {
+		u.ID = old.ID
+		u.CreatedAt = old.CreatedAt
+	} else {
+		m.lastID++
+		u.ID = m.lastID
+		u.CreatedAt = now
+	}
+
+	u.UpdatedAt = now
+
+	c := *u
+	m.users[u.ChatID] = &c
+
+	return nil
+}
+
+func (m *memoryStore) List(_ context.Context) ([]*model.User, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	uu := make([]*model.User, 0, len(m.users))
+	for _, u := range m.users {
+		c := *u
+		uu = append(uu, &c)
+	}
+
+	sort.Slice(uu, func(i, j int) bool { return uu[i].ID < uu[j].ID })
+
+	return uu, nil
+}
+
+func (m *memoryStore) Forget(_ context.Context, chatID int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if u, ok := m.users[chatID]; ok {
+		u.Telegram = model.ErasedTelegram
+		u.UpdatedAt = time.Now()
+	}
+
+	return nil
+}
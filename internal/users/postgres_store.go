@@ -0,0 +1,81 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/grbit/post_bot/internal/dbtx"
+	"github.com/grbit/post_bot/internal/model"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore returns UserStore backed by the `users` table.
+func NewPostgresStore(db *gorm.DB) UserStore {
+	return &postgresStore{db: db}
+}
+
+func (p *postgresStore) Get(ctx context.Context, chatID int64) (*model.User, error) {
+	uu := []*model.User{}
+	if err := p.db.WithContext(ctx).Find(&uu, "chat_id = ?", chatID).Error; err != nil {
+		return nil, xerrors.Errorf("searching user (chat_id=%d): %w", chatID, err)
+	}
+
+	if len(uu) == 0 {
+		return &model.User{ChatID: chatID}, nil
+	}
+
+	return uu[0], nil
+}
+
+func (p *postgresStore) FindByTelegram(ctx context.Context, tg string) (*model.User, error) {
+	uu := []*model.User{}
+	if err := p.db.WithContext(ctx).Order("updated_at DESC").Limit(1).Find(&uu, "LOWER(telegram) = LOWER(?)", tg).Error; err != nil {
+		return nil, xerrors.Errorf("searching user (tg=%q): %w", tg, err)
+	}
+
+	if len(uu) == 0 {
+		return nil, nil
+	}
+
+	return uu[0], nil
+}
+
+func (p *postgresStore) Save(ctx context.Context, u *model.User) error {
+	err := p.db.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "chat_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"telegram", "role", "draw_filter", "updated_at"}),
+		},
+	).Create(u).Error
+	if err != nil {
+		return xerrors.Errorf("saving user (chat_id=%d): %w", u.ChatID, err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) List(ctx context.Context) ([]*model.User, error) {
+	uu := []*model.User{}
+	if err := p.db.WithContext(ctx).Order("id").Find(&uu).Error; err != nil {
+		return nil, xerrors.Errorf("listing users: %w", err)
+	}
+
+	return uu, nil
+}
+
+func (p *postgresStore) Forget(ctx context.Context, chatID int64) error {
+	err := dbtx.DB(ctx, p.db).Model(&model.User{}).Where("chat_id = ?", chatID).
+		Updates(map[string]interface{}{"telegram": model.ErasedTelegram, "updated_at": time.Now()}).Error
+	if err != nil {
+		return xerrors.Errorf("forgetting user (chat_id=%d): %w", chatID, err)
+	}
+
+	return nil
+}
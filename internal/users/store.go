@@ -0,0 +1,21 @@
+// Package users keeps telegram users who have talked to the bot and their roles.
+package users
+
+import (
+	"context"
+
+	"github.com/grbit/post_bot/internal/model"
+)
+
+// UserStore keeps users by their private chat ID.
+type UserStore interface {
+	// Get returns the user. If the user is unknown, a new one without role is returned.
+	Get(ctx context.Context, chatID int64) (*model.User, error)
+	// FindByTelegram returns the user by telegram nick, nil if nobody with the nick has talked to the bot.
+	FindByTelegram(ctx context.Context, tg string) (*model.User, error)
+	Save(ctx context.Context, u *model.User) error
+	// List returns all users ordered by ID.
+	List(ctx context.Context) ([]*model.User, error)
+	// Forget replaces the nick of the user with model.ErasedTelegram, the chat and the role are kept.
+	Forget(ctx context.Context, chatID int64) error
+}
@@ -1,6 +0,0 @@
-BEGIN;
-
-DROP TABLE IF EXISTS users CASCADE;
-DROP TABLE IF EXISTS bookings CASCADE;
-
-COMMIT;
@@ -0,0 +1,10 @@
+// Package migrations contains SQL migrations for every supported database.
+package migrations
+
+import "embed"
+
+// FS has a directory per database dialect (as gorm names it) with
+// NNNN_name.up.sql and NNNN_name.down.sql files inside.
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
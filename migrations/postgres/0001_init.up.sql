@@ -1,6 +1,6 @@
-BEGIN;
+-- IF NOT EXISTS is here because databases created before migrations were introduced already have these tables
 
-CREATE TABLE addresses (
+CREATE TABLE IF NOT EXISTS addresses (
     id          SERIAL PRIMARY KEY,
     telegram    TEXT UNIQUE,
     instagram   TEXT,
@@ -14,11 +14,11 @@ CREATE TABLE addresses (
     updated_at timestamp with time zone,
     deleted_at timestamp with time zone
 );
-CREATE INDEX bookings_phone_idx ON addresses USING btree (phone);
-CREATE INDEX bookings_email_idx ON addresses USING btree (email);
-CREATE INDEX bookings_telegram_idx ON addresses USING btree (telegram);
+CREATE INDEX IF NOT EXISTS bookings_phone_idx ON addresses USING btree (phone);
+CREATE INDEX IF NOT EXISTS bookings_email_idx ON addresses USING btree (email);
+CREATE INDEX IF NOT EXISTS bookings_telegram_idx ON addresses USING btree (telegram);
 
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
     id                 SERIAL PRIMARY KEY,
     chat_id            BIGINT,
     requested          BOOLEAN,
@@ -28,6 +28,4 @@ CREATE TABLE users (
     updated_at timestamp with time zone,
     deleted_at timestamp with time zone
 );
-CREATE UNIQUE INDEX users_chat_id_idx ON users USING btree (chat_id);
-
-COMMIT;
+CREATE UNIQUE INDEX IF NOT EXISTS users_chat_id_idx ON users USING btree (chat_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS states (
+    id                  SERIAL PRIMARY KEY,
+    chat_id             BIGINT NOT NULL,
+    telegram            TEXT,
+    previous_cmd        TEXT,
+    previous_cmd_at     timestamp with time zone,
+    given_addresses_ctr INTEGER NOT NULL DEFAULT 0,
+    file_ids            TEXT,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone
+);
+CREATE UNIQUE INDEX IF NOT EXISTS states_chat_id_idx ON states USING btree (chat_id);
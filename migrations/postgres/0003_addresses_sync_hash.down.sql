@@ -0,0 +1 @@
+ALTER TABLE addresses DROP COLUMN sync_hash;
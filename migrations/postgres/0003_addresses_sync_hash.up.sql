@@ -0,0 +1 @@
+ALTER TABLE addresses ADD COLUMN sync_hash TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE states DROP COLUMN flow_data;
+ALTER TABLE states DROP COLUMN history;
+ALTER TABLE states DROP COLUMN step;
@@ -0,0 +1,3 @@
+ALTER TABLE addresses ADD COLUMN moderation TEXT;
+ALTER TABLE addresses ADD COLUMN moderation_reason TEXT;
+ALTER TABLE addresses ADD COLUMN chat_id BIGINT;
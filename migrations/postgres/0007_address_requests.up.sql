@@ -0,0 +1,15 @@
+CREATE TABLE address_requests (
+    id           SERIAL PRIMARY KEY,
+    requester_id BIGINT NOT NULL,
+    recipient    TEXT NOT NULL,
+    method       TEXT NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone
+);
+CREATE INDEX address_requests_requester_idx ON address_requests USING btree (requester_id, created_at);
+CREATE INDEX address_requests_recipient_idx ON address_requests USING btree (recipient);
+
+-- replaced by address_requests, they were never filled in properly
+ALTER TABLE users DROP COLUMN received_addresses;
+ALTER TABLE states DROP COLUMN given_addresses_ctr;
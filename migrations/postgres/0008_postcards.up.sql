@@ -0,0 +1,16 @@
+CREATE TABLE postcards (
+    id          SERIAL PRIMARY KEY,
+    code        TEXT NOT NULL,
+    sender_id   BIGINT NOT NULL,
+    sender      TEXT,
+    recipient   TEXT NOT NULL,
+    status      TEXT NOT NULL,
+    sent_at     timestamp with time zone,
+    received_at timestamp with time zone,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone
+);
+CREATE UNIQUE INDEX postcards_code_idx ON postcards USING btree (code);
+CREATE INDEX postcards_sender_idx ON postcards USING btree (sender_id, status);
+CREATE INDEX postcards_status_idx ON postcards USING btree (status, created_at);
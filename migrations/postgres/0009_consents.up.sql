@@ -0,0 +1,15 @@
+ALTER TABLE addresses ADD COLUMN privacy TEXT;
+
+CREATE TABLE consents (
+    id                SERIAL PRIMARY KEY,
+    requester_id      BIGINT NOT NULL,
+    requester         TEXT,
+    recipient         TEXT NOT NULL,
+    recipient_chat_id BIGINT NOT NULL,
+    status            TEXT NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone
+);
+CREATE INDEX consents_requester_idx ON consents USING btree (requester_id, recipient, status);
+CREATE INDEX consents_status_idx ON consents USING btree (status, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX addresses_email_index_idx;
+DROP INDEX addresses_phone_index_idx;
+ALTER TABLE addresses DROP COLUMN email_index;
+ALTER TABLE addresses DROP COLUMN phone_index;
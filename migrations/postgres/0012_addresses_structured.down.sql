@@ -0,0 +1,7 @@
+DROP INDEX addresses_country_idx;
+ALTER TABLE addresses DROP COLUMN country;
+ALTER TABLE addresses DROP COLUMN postal_code;
+ALTER TABLE addresses DROP COLUMN region;
+ALTER TABLE addresses DROP COLUMN city;
+ALTER TABLE addresses DROP COLUMN street;
+ALTER TABLE addresses DROP COLUMN recipient_line;
@@ -0,0 +1,8 @@
+-- address stays as the whole address in one line, parts are filled only for addresses entered step by step
+ALTER TABLE addresses ADD COLUMN recipient_line TEXT;
+ALTER TABLE addresses ADD COLUMN street TEXT;
+ALTER TABLE addresses ADD COLUMN city TEXT;
+ALTER TABLE addresses ADD COLUMN region TEXT;
+ALTER TABLE addresses ADD COLUMN postal_code TEXT;
+ALTER TABLE addresses ADD COLUMN country TEXT;
+CREATE INDEX addresses_country_idx ON addresses USING btree (country);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN draw_filter TEXT;
@@ -0,0 +1,28 @@
+CREATE TABLE addresses (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    telegram    TEXT UNIQUE,
+    instagram   TEXT,
+    person_name TEXT,
+    address     TEXT,
+    wishes      TEXT,
+    phone       TEXT,
+    email       TEXT,
+    approved    BOOLEAN,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE INDEX addresses_phone_idx ON addresses (phone);
+CREATE INDEX addresses_email_idx ON addresses (email);
+
+CREATE TABLE users (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id            BIGINT,
+    requested          BOOLEAN,
+    received_addresses TEXT,
+    search_previous    BOOLEAN,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE UNIQUE INDEX users_chat_id_idx ON users (chat_id);
@@ -0,0 +1,13 @@
+CREATE TABLE states (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id             BIGINT NOT NULL,
+    telegram            TEXT,
+    previous_cmd        TEXT,
+    previous_cmd_at     DATETIME,
+    given_addresses_ctr INTEGER NOT NULL DEFAULT 0,
+    file_ids            TEXT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE UNIQUE INDEX states_chat_id_idx ON states (chat_id);
@@ -0,0 +1,3 @@
+ALTER TABLE states ADD COLUMN step TEXT;
+ALTER TABLE states ADD COLUMN history TEXT;
+ALTER TABLE states ADD COLUMN flow_data TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE addresses DROP COLUMN chat_id;
+ALTER TABLE addresses DROP COLUMN moderation_reason;
+ALTER TABLE addresses DROP COLUMN moderation;
@@ -0,0 +1,4 @@
+ALTER TABLE states ADD COLUMN given_addresses_ctr INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN received_addresses TEXT;
+
+DROP TABLE address_requests;
@@ -0,0 +1,15 @@
+CREATE TABLE address_requests (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    requester_id BIGINT NOT NULL,
+    recipient    TEXT NOT NULL,
+    method       TEXT NOT NULL,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE INDEX address_requests_requester_idx ON address_requests (requester_id, created_at);
+CREATE INDEX address_requests_recipient_idx ON address_requests (recipient);
+
+-- replaced by address_requests, they were never filled in properly
+ALTER TABLE users DROP COLUMN received_addresses;
+ALTER TABLE states DROP COLUMN given_addresses_ctr;
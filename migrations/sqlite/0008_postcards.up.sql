@@ -0,0 +1,16 @@
+CREATE TABLE postcards (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    code        TEXT NOT NULL,
+    sender_id   BIGINT NOT NULL,
+    sender      TEXT,
+    recipient   TEXT NOT NULL,
+    status      TEXT NOT NULL,
+    sent_at     DATETIME,
+    received_at DATETIME,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE UNIQUE INDEX postcards_code_idx ON postcards (code);
+CREATE INDEX postcards_sender_idx ON postcards (sender_id, status);
+CREATE INDEX postcards_status_idx ON postcards (status, created_at);
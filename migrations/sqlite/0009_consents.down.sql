@@ -0,0 +1,3 @@
+DROP TABLE consents;
+
+ALTER TABLE addresses DROP COLUMN privacy;
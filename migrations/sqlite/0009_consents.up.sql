@@ -0,0 +1,15 @@
+ALTER TABLE addresses ADD COLUMN privacy TEXT;
+
+CREATE TABLE consents (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    requester_id      BIGINT NOT NULL,
+    requester         TEXT,
+    recipient         TEXT NOT NULL,
+    recipient_chat_id BIGINT NOT NULL,
+    status            TEXT NOT NULL,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE INDEX consents_requester_idx ON consents (requester_id, recipient, status);
+CREATE INDEX consents_status_idx ON consents (status, created_at);
@@ -0,0 +1,4 @@
+ALTER TABLE addresses ADD COLUMN phone_index TEXT;
+ALTER TABLE addresses ADD COLUMN email_index TEXT;
+CREATE INDEX addresses_phone_index_idx ON addresses (phone_index);
+CREATE INDEX addresses_email_index_idx ON addresses (email_index);
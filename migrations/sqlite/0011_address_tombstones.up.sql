@@ -0,0 +1,4 @@
+CREATE TABLE address_tombstones (
+    telegram   TEXT PRIMARY KEY,
+    created_at DATETIME
+);